@@ -1,12 +1,17 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/TwigBush/gnap-go/internal/gnap"
+	"github.com/TwigBush/gnap-go/internal/jwks"
 	"github.com/TwigBush/gnap-go/internal/server"
 	"github.com/TwigBush/gnap-go/internal/types"
 )
@@ -16,22 +21,86 @@ func main() {
 	grantStore := mustGrantStore()
 	rsKeyStore := mustRSKeyStore()
 	tokenStore := mustTokenStore()
+	clientStore := mustClientStore()
+
+	keyProofs := []string{"httpsig", "jwsd", "jws"}
+	tlsCfg := mustTLSConfig()
+	if tlsCfg != nil {
+		keyProofs = append(keyProofs, "mtls")
+	}
 
 	h := server.BuildASRouter(server.Deps{
-		GrantStore: grantStore,
-		RSKeyStore: rsKeyStore,
-		TokenStore: tokenStore,
+		GrantStore:  grantStore,
+		RSKeyStore:  rsKeyStore,
+		TokenStore:  tokenStore,
+		ClientStore: clientStore,
+		AccessDefs:  mustAccessDefinitions(),
+		Pairwise:    mustPairwise(),
+		Keys:        mustKeys(),
+
+		TrustedIssuers: mustTrustedIssuers(),
 	}, server.Options{EnableCORS: true,
-		InteractionStartModes:    []string{"redirect", "user_code"},
-		InteractionFinishMethods: []string{"redirect"},
-		KeyProofs:                []string{"httpsig", "jws"},
-		SubIDFormats:             []string{"public", "pairwise"},
+		InteractionStartModes:    []string{"redirect", "app", "user_code", "user_code_uri"},
+		InteractionFinishMethods: []string{"redirect", "push"},
+		AppLaunchURI:             os.Getenv("TWIGBUSH_APP_LAUNCH_URI"),
+		KeyProofs:                keyProofs,
+		SubIDFormats:             []string{"opaque", "email", "iss_sub", "public", "pairwise"},
 		AssertionFormats:         []string{"jwt"},
-		KeyRotationSupported:     true})
+		KeyRotationSupported:     true,
+		LongPollSeconds:          longPollSeconds(),
+		AssertedUserSkipsConsent: os.Getenv("TWIGBUSH_ASSERTED_USER_SKIPS_CONSENT") == "true",
+		UnknownClients:           unknownClientPolicy(),
+		AdminToken:               os.Getenv("TWIGBUSH_ADMIN_TOKEN"),
+		Issuer:                   issuer(tlsCfg != nil)})
 
+	if tlsCfg != nil {
+		srv := &http.Server{Addr: ":8085", Handler: h, TLSConfig: tlsCfg}
+		log.Fatal(srv.ListenAndServeTLS(os.Getenv("TWIGBUSH_TLS_CERT"), os.Getenv("TWIGBUSH_TLS_KEY")))
+	}
 	log.Fatal(http.ListenAndServe(":8085", h))
 }
 
+// mustTLSConfig returns a TLS config that asks clients for a certificate (mtls key proofs)
+// when TWIGBUSH_TLS_CERT and TWIGBUSH_TLS_KEY are set, or nil to serve plain HTTP.
+// Possession is proven by the handshake itself; TWIGBUSH_TLS_CLIENT_CA additionally
+// requires presented certificates to chain to the given CA bundle.
+func mustTLSConfig() *tls.Config {
+	if os.Getenv("TWIGBUSH_TLS_CERT") == "" || os.Getenv("TWIGBUSH_TLS_KEY") == "" {
+		return nil
+	}
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ClientAuth: tls.RequestClientCert,
+	}
+	if caFile := os.Getenv("TWIGBUSH_TLS_CLIENT_CA"); caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			panic(err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			panic("no certificates found in " + caFile)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return cfg
+}
+
+// longPollSeconds is how long /continue holds polls on pending grants open, from
+// TWIGBUSH_LONG_POLL_SECONDS: 30 when unset, 0 to disable long polling.
+func longPollSeconds() int {
+	v := os.Getenv("TWIGBUSH_LONG_POLL_SECONDS")
+	if v == "" {
+		return 30
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		panic("invalid TWIGBUSH_LONG_POLL_SECONDS: " + v)
+	}
+	return n
+}
+
 func mustGrantStore() types.GrantStore {
 	s, err := gnap.NewFileStore(defaultDataDir(), types.Config{GrantTTLSeconds: 120})
 	if err != nil {
@@ -56,6 +125,88 @@ func mustTokenStore() *gnap.TokenStoreContainer {
 	return s
 }
 
+func mustClientStore() *gnap.ClientStore {
+	s, err := gnap.NewClientStore(defaultDataDir())
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// mustAccessDefinitions loads the named access definitions under <dataDir>/access.
+func mustAccessDefinitions() *gnap.AccessDefinitions {
+	s, err := gnap.NewAccessDefinitions(defaultDataDir())
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// unknownClientPolicy limits grants from clients that are not pre-registered, from
+// TWIGBUSH_UNKNOWN_CLIENTS: allow (the default), deny, or limit to the access types
+// listed in TWIGBUSH_UNKNOWN_CLIENT_ACCESS.
+func unknownClientPolicy() gnap.UnknownClientPolicy {
+	p := gnap.UnknownClientPolicy{Mode: os.Getenv("TWIGBUSH_UNKNOWN_CLIENTS")}
+	switch p.Mode {
+	case "":
+		p.Mode = gnap.UnknownClientsAllow
+	case gnap.UnknownClientsAllow, gnap.UnknownClientsDeny:
+	case gnap.UnknownClientsLimit:
+		for _, t := range strings.Split(os.Getenv("TWIGBUSH_UNKNOWN_CLIENT_ACCESS"), ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				p.AllowedAccess = append(p.AllowedAccess, t)
+			}
+		}
+	default:
+		panic("invalid TWIGBUSH_UNKNOWN_CLIENTS: " + p.Mode)
+	}
+	return p
+}
+
+func mustPairwise() *gnap.PairwiseSubjects {
+	p, err := gnap.NewPairwiseSubjects(defaultDataDir())
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+func mustKeys() *jwks.KeySet {
+	k, err := jwks.Load(defaultDataDir())
+	if err != nil {
+		panic(err)
+	}
+	return k
+}
+
+// mustTrustedIssuers loads the issuers whose user assertions the AS accepts from
+// TWIGBUSH_TRUSTED_ISSUERS, a comma-separated list of issuer=path/to/jwks.json. An
+// assertion's aud must be this AS's issuer URL.
+func mustTrustedIssuers() *jwks.TrustedIssuers {
+	files, err := jwks.ParseTrustedIssuers(os.Getenv("TWIGBUSH_TRUSTED_ISSUERS"))
+	if err != nil {
+		panic(err)
+	}
+	t, err := jwks.LoadTrustedIssuers(files)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// issuer returns the AS's own URL from TWIGBUSH_ISSUER, defaulting to the local
+// listener. It is configured rather than taken from requests, whose Host and
+// X-Forwarded-Proto headers the client controls.
+func issuer(tls bool) string {
+	if v := os.Getenv("TWIGBUSH_ISSUER"); v != "" {
+		return strings.TrimSuffix(v, "/")
+	}
+	if tls {
+		return "https://localhost:8085"
+	}
+	return "http://localhost:8085"
+}
+
 func defaultDataDir() string {
 	// Respect explicit override first
 	if v := os.Getenv("TWIGBUSH_DATA_DIR"); v != "" {
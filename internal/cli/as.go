@@ -7,6 +7,6 @@ func cmdAS() *cobra.Command {
 		Use:   "as",
 		Short: "Authorization Server helpers",
 	}
-	c.AddCommand(cmdASIntrospect())
+	c.AddCommand(cmdASIntrospect(), cmdASPairwise())
 	return c
 }
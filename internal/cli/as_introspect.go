@@ -24,8 +24,7 @@ func cmdASIntrospect() *cobra.Command {
 			if err != nil {
 				return err
 			}
-			fmt.Printf("HTTP %d\n", code)
-			return printJSON(resp)
+			return printResponse(code, resp)
 		},
 	}
 	c.Flags().StringVar(&token, "token", "", "access token to introspect")
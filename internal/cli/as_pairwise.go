@@ -0,0 +1,60 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/TwigBush/gnap-go/internal/gnap"
+	"github.com/spf13/cobra"
+)
+
+// cmdASPairwise maps a pairwise subject id back to the account it was derived from,
+// reading the AS data dir directly. It is meant for admins during incident response.
+func cmdASPairwise() *cobra.Command {
+	var tenant, dataDir string
+
+	c := &cobra.Command{
+		Use:   "pairwise <id>",
+		Short: "Look up the account behind a pairwise subject id",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if dataDir == "" {
+				dir, err := asDataDir()
+				if err != nil {
+					return err
+				}
+				dataDir = dir
+			}
+			pairwise, err := gnap.NewPairwiseSubjects(dataDir)
+			if err != nil {
+				return err
+			}
+			rec, err := pairwise.Lookup(tenant, args[0])
+			if err != nil {
+				return fmt.Errorf("%s in tenant %s: %w", args[0], tenant, err)
+			}
+			b, err := json.Marshal(rec)
+			if err != nil {
+				return err
+			}
+			return printJSON(b)
+		},
+	}
+	c.Flags().StringVar(&tenant, "tenant", gnap.DefaultTenant, "Tenant ID (default: default)")
+	c.Flags().StringVar(&dataDir, "data-dir", "", "AS data dir (default: $TWIGBUSH_DATA_DIR or ~/.twigbush/data)")
+	return c
+}
+
+// asDataDir is where a local AS keeps its data, matching cmd/as.
+func asDataDir() (string, error) {
+	if v := os.Getenv("TWIGBUSH_DATA_DIR"); v != "" {
+		return v, nil
+	}
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "data"), nil
+}
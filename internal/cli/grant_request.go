@@ -1,41 +1,103 @@
 package cli
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
 	"fmt"
+	"net/url"
 	"os"
 	"path"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/TwigBush/gnap-go/internal/sign"
 	"github.com/spf13/cobra"
 )
 
 func cmdGrantRequest() *cobra.Command {
 	var file string
 	var endpoint string
+	var keyPath string
 
 	c := &cobra.Command{
 		Use:   "request",
 		Short: "Post a GNAP grant request",
+		Long: "Posts a grant request with client.key set to the given key and signed with it\n" +
+			"(HTTP Message Signatures), as the AS requires.",
 		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, _ := loadConfig(cfgPath)
+			if cfg != nil && keyPath == "" {
+				keyPath = cfg.DefaultKey
+			}
+			if keyPath == "" {
+				return fmt.Errorf("--key is required or set default_key in config")
+			}
 			b, err := os.ReadFile(file)
 			if err != nil {
 				return err
 			}
-			url := strings.TrimRight(asBaseURL, "/") + endpoint
-			resp, code, err := httpDoJSON("POST", url, b, nil)
+			grantURL := strings.TrimRight(asBaseURL, "/") + endpoint
+			body, headers, err := signedGrantRequest(grantURL, b, keyPath)
+			if err != nil {
+				return err
+			}
+			resp, code, err := httpDoJSON("POST", grantURL, body, headers)
 			if err != nil {
 				return err
 			}
-			fmt.Printf("HTTP %d\n", code)
-			return printJSON(resp)
+			return printResponse(code, resp)
 		},
 	}
 	c.Flags().StringVarP(&file, "file", "f", "", "grant request JSON file")
-	c.Flags().StringVar(&endpoint, "endpoint", "/grant", "AS path for grant requests")
+	c.Flags().StringVar(&endpoint, "endpoint", "/grants", "AS path for grant requests (the TwigBush AS serves /grants)")
+	c.Flags().StringVar(&keyPath, "key", "", "path to the client's private key .jwk (default: default_key in config)")
 	_ = c.MarkFlagRequired("file")
 	return c
 }
 
+// signedGrantRequest sets client.key in a grant request to the public half of the key
+// at keyPath and signs the request with it over the method, target URI and content
+// digest. It returns the body and headers to send.
+func signedGrantRequest(grantURL string, body []byte, keyPath string) ([]byte, map[string]string, error) {
+	u, err := url.Parse(grantURL)
+	if err != nil || !u.IsAbs() {
+		return nil, nil, fmt.Errorf("invalid grant endpoint %q", grantURL)
+	}
+	k, priv, alg, err := loadSigningKey(keyPath, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	pub, err := os.ReadFile(strings.TrimSuffix(keyPath, ".jwk") + ".pub.jwk")
+	if err != nil {
+		return nil, nil, fmt.Errorf("read public key: %w", err)
+	}
+	body, err = withClientKey(body, pub, sign.ProofHTTPSig)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	d := sha256.Sum256(body)
+	headers := map[string]string{
+		"Content-Digest": "sha-256=:" + base64.StdEncoding.EncodeToString(d[:]) + ":",
+		"Content-Type":   "application/json",
+	}
+	comps := []string{"@method", "@target-uri", "content-digest"}
+	kid, _ := k.KeyID()
+	params := map[string]string{"created": strconv.FormatInt(time.Now().Unix(), 10), "keyid": kid, "alg": alg}
+	base, err := buildSignatureBase(u, "POST", headers, comps, params)
+	if err != nil {
+		return nil, nil, err
+	}
+	sig, err := signBase(priv, alg, base)
+	if err != nil {
+		return nil, nil, err
+	}
+	headers["Signature-Input"] = buildSignatureInput("sig1", comps, params)
+	headers["Signature"] = "sig1=:" + base64.StdEncoding.EncodeToString(sig) + ":"
+	return body, headers, nil
+}
+
 // Optional helper to guess default samples path
 func samplesPath(p string) string {
 	if path.IsAbs(p) {
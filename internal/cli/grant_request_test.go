@@ -0,0 +1,51 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/TwigBush/gnap-go/internal/gnap"
+	"github.com/TwigBush/gnap-go/internal/handlers"
+	"github.com/TwigBush/gnap-go/internal/types"
+)
+
+func TestSignedGrantRequest_AcceptedByAS(t *testing.T) {
+	store, err := gnap.NewFileStore(t.TempDir(), types.Config{GrantTTLSeconds: 60})
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	as := httptest.NewServer(handlers.NewGrantHandler(store))
+	defer as.Close()
+
+	keyPath, _, err := generateKey(t.TempDir(), "")
+	if err != nil {
+		t.Fatalf("generateKey: %v", err)
+	}
+	grantURL := as.URL + "/grants"
+	req := []byte(`{"access_token":{"access":[{"type":"photo-api","actions":["read"]}]},"client":{"display":{"name":"cli"}}}`)
+
+	body, headers, err := signedGrantRequest(grantURL, req, keyPath)
+	if err != nil {
+		t.Fatalf("signedGrantRequest: %v", err)
+	}
+	resp, code, err := httpDoJSON("POST", grantURL, body, headers)
+	if err != nil {
+		t.Fatalf("POST: %v", err)
+	}
+	var grant types.GrantResponse
+	if err := json.Unmarshal(resp, &grant); err != nil || code != 200 || grant.Continue.AccessToken == "" {
+		t.Fatalf("signed grant request: HTTP %d %s", code, resp)
+	}
+
+	// The key proof covers the body, so the AS rejects it once changed
+	tampered := bytes.Replace(body, []byte(`"read"`), []byte(`"write"`), 1)
+	resp, code, err = httpDoJSON("POST", grantURL, tampered, headers)
+	if err != nil {
+		t.Fatalf("POST: %v", err)
+	}
+	if ge := parseGNAPError(resp); ge == nil || ge.Code != gnap.CodeInvalidClient {
+		t.Fatalf("tampered grant request: HTTP %d %s, want invalid_client", code, resp)
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"io"
 	"net/http"
 	"os"
+
+	"github.com/TwigBush/gnap-go/internal/gnap"
 )
 
 func httpDoJSON(method, url string, body []byte, headers map[string]string) ([]byte, int, error) {
@@ -57,3 +59,33 @@ func printJSON(b []byte) error {
 	fmt.Println(string(enc))
 	return nil
 }
+
+// printResponse prints an AS response. A GNAP error response is returned as an
+// error instead, so it reads as one line and the command exits non-zero.
+func printResponse(code int, b []byte) error {
+	if ge := parseGNAPError(b); ge != nil && code >= 400 {
+		return fmt.Errorf("HTTP %d %s", code, ge)
+	}
+	fmt.Printf("HTTP %d\n", code)
+	return printJSON(b)
+}
+
+// parseGNAPError decodes the "error" field of a GNAP error response (RFC 9635 §3.6),
+// which is either an object with a code and description or just the code.
+func parseGNAPError(b []byte) *gnap.Error {
+	var resp struct {
+		Error json.RawMessage `json:"error"`
+	}
+	if err := json.Unmarshal(b, &resp); err != nil || len(resp.Error) == 0 {
+		return nil
+	}
+	var ge gnap.Error
+	if err := json.Unmarshal(resp.Error, &ge); err == nil && ge.Code != "" {
+		return &ge
+	}
+	var code string
+	if err := json.Unmarshal(resp.Error, &code); err == nil && code != "" {
+		return gnap.NewError(gnap.ErrorCode(code), "")
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package cli
+
+import "testing"
+
+func TestParseGNAPError(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string // "" for no GNAP error
+	}{
+		{"object form", `{"error":{"code":"invalid_client","description":"invalid key proof"}}`, "invalid_client: invalid key proof"},
+		{"string form", `{"error":"too_fast"}`, "too_fast"},
+		{"success response", `{"continue":{"uri":"https://as.example/continue/1"}}`, ""},
+		{"not JSON", `oops`, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseGNAPError([]byte(tt.body))
+			if (got == nil) != (tt.want == "") || (got != nil && got.Error() != tt.want) {
+				t.Fatalf("parseGNAPError() = %v, want %q", got, tt.want)
+			}
+		})
+	}
+}
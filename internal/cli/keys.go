@@ -9,5 +9,6 @@ func cmdKeys() *cobra.Command {
 	}
 	c.AddCommand(cmdKeysNew())
 	c.AddCommand(cmdKeysRegister())
+	c.AddCommand(cmdKeysRotate())
 	return c
 }
@@ -0,0 +1,92 @@
+package cli
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TwigBush/gnap-go/internal/sign"
+	"github.com/lestrrat-go/jwx/v3/jwk"
+)
+
+// Signature labels of a key rotation request (RFC 9635 §7.3.1.1)
+const (
+	oldKeyLabel = "old-key"
+	newKeyLabel = "new-key"
+)
+
+// rotationRequest builds a key rotation request for a token management URI: a body
+// carrying the new public key, signed with the old key and then with the new key over
+// the old key's signature. It returns the body and headers to send.
+func rotationRequest(manageURI, manageToken, oldPath, newPath string) ([]byte, map[string]string, error) {
+	u, err := url.Parse(manageURI)
+	if err != nil || !u.IsAbs() {
+		return nil, nil, fmt.Errorf("invalid token management URI %q", manageURI)
+	}
+	oldKey, oldPriv, oldAlg, err := loadSigningKey(oldPath, "")
+	if err != nil {
+		return nil, nil, fmt.Errorf("current key: %w", err)
+	}
+	newKey, newPriv, newAlg, err := loadSigningKey(newPath, "")
+	if err != nil {
+		return nil, nil, fmt.Errorf("new key: %w", err)
+	}
+	pub, err := os.ReadFile(strings.TrimSuffix(newPath, ".jwk") + ".pub.jwk")
+	if err != nil {
+		return nil, nil, fmt.Errorf("read new public key: %w", err)
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"key": map[string]any{"proof": sign.ProofHTTPSig, "jwk": json.RawMessage(pub)},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	d := sha256.Sum256(body)
+	headers := map[string]string{
+		"Authorization":  "GNAP " + manageToken,
+		"Content-Digest": "sha-256=:" + base64.StdEncoding.EncodeToString(d[:]) + ":",
+		"Content-Type":   "application/json",
+	}
+	comps := []string{"@method", "@target-uri", "authorization", "content-digest"}
+	created := strconv.FormatInt(time.Now().Unix(), 10)
+
+	// Each signature is added to the Signature and Signature-Input dictionaries in turn
+	signWith := func(label string, k jwk.Key, priv any, alg string, comps []string) error {
+		kid, _ := k.KeyID()
+		params := map[string]string{"created": created, "keyid": kid, "alg": alg}
+		input := buildSignatureInput(label, comps, params)
+		base, err := buildSignatureBase(u, "POST", headers, comps, params)
+		if err != nil {
+			return err
+		}
+		sig, err := signBase(priv, alg, base)
+		if err != nil {
+			return err
+		}
+		headers["Signature-Input"] = joinDictionary(headers["Signature-Input"], input)
+		headers["Signature"] = joinDictionary(headers["Signature"], label+"=:"+base64.StdEncoding.EncodeToString(sig)+":")
+		return nil
+	}
+	if err := signWith(oldKeyLabel, oldKey, oldPriv, oldAlg, comps); err != nil {
+		return nil, nil, fmt.Errorf("sign with current key: %w", err)
+	}
+	comps = append(comps, sign.DictionaryComponent("signature", oldKeyLabel), sign.DictionaryComponent("signature-input", oldKeyLabel))
+	if err := signWith(newKeyLabel, newKey, newPriv, newAlg, comps); err != nil {
+		return nil, nil, fmt.Errorf("sign with new key: %w", err)
+	}
+	return body, headers, nil
+}
+
+func joinDictionary(h, member string) string {
+	if h == "" {
+		return member
+	}
+	return h + ", " + member
+}
@@ -0,0 +1,73 @@
+package cli
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+)
+
+func cmdKeysRotate() *cobra.Command {
+	var keyPath, newKeyPath, manageURI, manageToken string
+
+	c := &cobra.Command{
+		Use:   "rotate",
+		Short: "Rotate the client key bound to a grant's tokens",
+		Long: "Sends a key rotation request (RFC 9635 §6.1.1) to a token management URI, signed\n" +
+			"with both the current and the new key. The AS binds the grant, its live tokens\n" +
+			"and its continuation to the new key and returns the rotated access token.",
+		Example: "twigbush keys rotate --manage-uri http://localhost:8085/token/XYZ --token MANAGE_TOKEN",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, _ := loadConfig(cfgPath)
+			if cfg != nil && keyPath == "" {
+				keyPath = cfg.DefaultKey
+			}
+			if manageURI == "" || manageToken == "" {
+				return fmt.Errorf("--manage-uri and --token are required")
+			}
+			if keyPath == "" {
+				return fmt.Errorf("--key is required or set default_key in config")
+			}
+
+			// Without --new-key, generate one next to the others
+			if newKeyPath == "" {
+				dir, err := configDir()
+				if err != nil {
+					return err
+				}
+				keysDir := filepath.Join(dir, "keys")
+				if err := ensureDir(keysDir); err != nil {
+					return err
+				}
+				path, kid, err := generateKey(keysDir, "")
+				if err != nil {
+					return err
+				}
+				fmt.Printf("Wrote %s\nKey ID: %s\n", path, kid)
+				newKeyPath = path
+			}
+
+			body, headers, err := rotationRequest(manageURI, manageToken, keyPath, newKeyPath)
+			if err != nil {
+				return err
+			}
+			resp, code, err := httpDoJSON("POST", manageURI, body, headers)
+			if err != nil {
+				return err
+			}
+			if code/100 == 2 && cfg != nil && cfg.DefaultKey == keyPath {
+				cfg.DefaultKey = newKeyPath
+				if err := saveConfig(cfgPath, cfg); err != nil {
+					return err
+				}
+				fmt.Printf("Default key is now %s\n", newKeyPath)
+			}
+			return printResponse(code, resp)
+		},
+	}
+	c.Flags().StringVar(&keyPath, "key", "", "path to the current private key .jwk (default: default_key in config)")
+	c.Flags().StringVar(&newKeyPath, "new-key", "", "path to the new private key .jwk; generated if omitted")
+	c.Flags().StringVar(&manageURI, "manage-uri", "", "token management URI from the grant response")
+	c.Flags().StringVar(&manageToken, "token", "", "token management access token")
+	return c
+}
@@ -0,0 +1,83 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/TwigBush/gnap-go/internal/sign"
+	"github.com/TwigBush/gnap-go/internal/types"
+)
+
+func TestRotationRequest_VerifiesWithBothKeys(t *testing.T) {
+	dir := t.TempDir()
+	oldPath, _, err := generateKey(dir, "")
+	if err != nil {
+		t.Fatalf("generateKey: %v", err)
+	}
+	newPath, _, err := generateKey(dir, "")
+	if err != nil {
+		t.Fatalf("generateKey: %v", err)
+	}
+	otherPath, _, err := generateKey(dir, "")
+	if err != nil {
+		t.Fatalf("generateKey: %v", err)
+	}
+	clientKey := func(privPath string) types.ClientKey {
+		b, err := os.ReadFile(strings.TrimSuffix(privPath, ".jwk") + ".pub.jwk")
+		if err != nil {
+			t.Fatalf("read pub key: %v", err)
+		}
+		key := types.ClientKey{Proof: sign.ProofHTTPSig}
+		if err := json.Unmarshal(b, &key.JWK); err != nil {
+			t.Fatalf("parse pub key: %v", err)
+		}
+		return key
+	}
+
+	const uri = "http://as.example/token/m1"
+	body, headers, err := rotationRequest(uri, "manage-token", oldPath, newPath)
+	if err != nil {
+		t.Fatalf("rotationRequest: %v", err)
+	}
+	var sent struct {
+		Key types.ClientKey `json:"key"`
+	}
+	if err := json.Unmarshal(body, &sent); err != nil || sent.Key != clientKey(newPath) {
+		t.Fatalf("body key = %+v, %v; want the new public key", sent.Key, err)
+	}
+
+	request := func(body []byte) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, uri, bytes.NewReader(body))
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+		return r
+	}
+
+	tests := []struct {
+		name     string
+		body     []byte
+		old, new types.ClientKey
+		wantErr  bool
+	}{
+		{"both keys", body, clientKey(oldPath), clientKey(newPath), false},
+		{"keys swapped", body, clientKey(newPath), clientKey(oldPath), true},
+		{"not the current key", body, clientKey(otherPath), clientKey(newPath), true},
+		{"not the new key", body, clientKey(oldPath), clientKey(otherPath), true},
+		{"body changed", bytes.Replace(body, []byte(`"httpsig"`), []byte(`"jwsd"`), 1), clientKey(oldPath), clientKey(newPath), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := sign.VerifyRotationProof(request(tt.body), tt.body, tt.old, tt.new)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("VerifyRotationProof() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -16,6 +16,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/TwigBush/gnap-go/internal/sign"
 	"github.com/lestrrat-go/jwx/v3/jwk"
 	"github.com/spf13/cobra"
 )
@@ -26,6 +27,7 @@ func cmdSignCurl() *cobra.Command {
 		method            string
 		rawURL            string
 		httpsig           bool
+		proof             string // httpsig | jwsd | jws
 		bodyPath          string
 		tenant            string
 		algFlag           string // optional override: ed25519 | ecdsa-p256-sha256 | ecdsa-p384-sha384
@@ -34,59 +36,32 @@ func cmdSignCurl() *cobra.Command {
 	)
 	c := &cobra.Command{
 		Use:   "curl",
-		Short: "Wrap a curl with HTTP Message Signatures",
+		Short: "Wrap a curl with a GNAP key proof (HTTP Message Signatures or JWS)",
 		Example: "twigbush sign curl --httpsig --key ~/.twigbush/keys/key-XYZ.jwk " +
-			"--method POST --url http://localhost:8089/introspect --body ./body.json",
+			"--method POST --url http://localhost:8089/introspect --body ./body.json\n" +
+			"twigbush sign curl --proof jwsd --key ~/.twigbush/keys/key-XYZ.jwk " +
+			"--url http://localhost:8085/grants --body ./grant.json --include-client-key",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if !httpsig {
-				return fmt.Errorf("use --httpsig")
+			if proof == "" {
+				if !httpsig {
+					return fmt.Errorf("use --httpsig or --proof")
+				}
+				proof = sign.ProofHTTPSig
+			}
+			switch proof {
+			case sign.ProofHTTPSig, sign.ProofJWSD, sign.ProofJWS:
+			default:
+				return fmt.Errorf("unsupported --proof %q (httpsig|jwsd|jws)", proof)
 			}
 			if keyPath == "" || rawURL == "" {
 				return fmt.Errorf("--key and --url are required")
 			}
 
-			// Load JWK twice: once as raw private key for signing, once to read kid
-			privJWK, err := os.ReadFile(keyPath)
+			k, priv, alg, err := loadSigningKey(keyPath, algFlag)
 			if err != nil {
-				return fmt.Errorf("read key: %w", err)
-			}
-
-			// Parse as JWK first
-			k, err := jwk.ParseKey(privJWK)
-			if err != nil {
-				return fmt.Errorf("parse jwk: %w", err)
-			}
-
-			kidStr, ok := k.KeyID()
-			if !ok {
-				return fmt.Errorf("key missing kid")
-			}
-
-			// Extract the raw crypto key
-			var priv any
-			if err := jwk.ParseRawKey(privJWK, &priv); err != nil {
-				return fmt.Errorf("extract raw key: %w", err)
-			}
-
-			alg := strings.ToLower(strings.TrimSpace(algFlag))
-
-			if alg == "" {
-				switch pk := priv.(type) {
-				case ed25519.PrivateKey:
-					alg = "ed25519"
-				case *ecdsa.PrivateKey:
-					switch pk.Curve {
-					case elliptic.P256():
-						alg = "ecdsa-p256-sha256"
-					case elliptic.P384():
-						alg = "ecdsa-p384-sha384"
-					default:
-						return fmt.Errorf("unsupported ECDSA curve")
-					}
-				default:
-					return fmt.Errorf("unsupported key type %T", pk)
-				}
+				return err
 			}
+			kidStr, _ := k.KeyID()
 
 			u, err := url.Parse(rawURL)
 			if err != nil {
@@ -94,12 +69,13 @@ func cmdSignCurl() *cobra.Command {
 			}
 
 			var body []byte
-			if bodyPath != "" && includeClientKey {
+			if bodyPath != "" {
 				body, err = os.ReadFile(bodyPath)
 				if err != nil {
 					return fmt.Errorf("read body: %w", err)
 				}
-
+			}
+			if len(body) > 0 && includeClientKey {
 				// Wrap body with GNAP client.key structure
 				pubPath := strings.TrimSuffix(keyPath, ".jwk") + ".pub.jwk"
 				pubJWK, err := os.ReadFile(pubPath)
@@ -107,35 +83,29 @@ func cmdSignCurl() *cobra.Command {
 					return fmt.Errorf("read public key: %w", err)
 				}
 
-				var pubKeyMap map[string]any
-				if err := json.Unmarshal(pubJWK, &pubKeyMap); err != nil {
-					return fmt.Errorf("parse public key: %w", err)
-				}
-
-				// Parse the original body
-				var originalBody map[string]any
-				if err := json.Unmarshal(body, &originalBody); err != nil {
-					return fmt.Errorf("parse body: %w", err)
+				body, err = withClientKey(body, pubJWK, proof)
+				if err != nil {
+					return err
 				}
+			}
 
-				// Add client.key to the body for GNAP
-				if originalBody["client"] == nil {
-					originalBody["client"] = make(map[string]any)
+			if proof != sign.ProofHTTPSig {
+				headers := map[string]string{}
+				if continuationToken != "" {
+					headers["Authorization"] = "GNAP " + continuationToken
 				}
-				clientMap := originalBody["client"].(map[string]any)
-				clientMap["key"] = map[string]any{
-					"proof": "httpsig",
-					"jwk":   pubKeyMap,
+				if tenant != "" {
+					headers["X-Tenant-ID"] = tenant
 				}
-
-				// Re-marshal the body with client.key added
-				body, err = json.Marshal(originalBody)
+				body, err = signJWSProof(k, priv, proof, strings.ToUpper(method), rawURL, continuationToken, body, headers)
 				if err != nil {
-					return fmt.Errorf("marshal wrapped body: %w", err)
+					return err
 				}
+				fmt.Println(curlForCLI(strings.ToUpper(method), rawURL, body, headers))
+				return nil
 			}
 
-			// Optional Content-Digest (recommended when body is present)
+			// Content-Digest is required by the AS whenever a body is present
 			headers := map[string]string{}
 			comps := []string{"@method", "@target-uri"}
 			if continuationToken != "" {
@@ -183,6 +153,7 @@ func cmdSignCurl() *cobra.Command {
 	c.Flags().StringVar(&rawURL, "url", "", "target URL")
 	_ = c.MarkFlagRequired("url")
 	c.Flags().BoolVar(&httpsig, "httpsig", true, "use HTTP Message Signatures")
+	c.Flags().StringVar(&proof, "proof", "", "key proof method: httpsig|jwsd|jws (default httpsig)")
 	c.Flags().StringVar(&bodyPath, "body", "", "path to request body (optional)")
 	c.Flags().StringVar(&tenant, "tenant", "default", "X-Tenant-ID header (optional)")
 	c.Flags().StringVar(&algFlag, "alg", "", "override alg (ed25519|ecdsa-p256-sha256|ecdsa-p384-sha384)")
@@ -192,6 +163,86 @@ func cmdSignCurl() *cobra.Command {
 	return c
 }
 
+// withClientKey sets client.key in a grant request body to the public JWK with the
+// given proof method. A client sent by instance_id keeps it alongside the key.
+func withClientKey(body, pubJWK []byte, proof string) ([]byte, error) {
+	var pubKeyMap map[string]any
+	if err := json.Unmarshal(pubJWK, &pubKeyMap); err != nil {
+		return nil, fmt.Errorf("parse public key: %w", err)
+	}
+	var req map[string]any
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, fmt.Errorf("parse body: %w", err)
+	}
+	clientMap, ok := req["client"].(map[string]any)
+	if !ok {
+		clientMap = make(map[string]any)
+		if id, isID := req["client"].(string); isID {
+			clientMap["instance_id"] = id
+		}
+		req["client"] = clientMap
+	}
+	clientMap["key"] = map[string]any{
+		"proof": proof,
+		"jwk":   pubKeyMap,
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("marshal wrapped body: %w", err)
+	}
+	return body, nil
+}
+
+// loadSigningKey reads a private JWK, returning it along with the raw key and the
+// RFC 9421 algorithm to sign with: alg if given, else the one the key type implies.
+func loadSigningKey(path, alg string) (jwk.Key, any, string, error) {
+	privJWK, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("read key: %w", err)
+	}
+	k, err := jwk.ParseKey(privJWK)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("parse jwk: %w", err)
+	}
+	if _, ok := k.KeyID(); !ok {
+		return nil, nil, "", fmt.Errorf("key missing kid")
+	}
+	var priv any
+	if err := jwk.ParseRawKey(privJWK, &priv); err != nil {
+		return nil, nil, "", fmt.Errorf("extract raw key: %w", err)
+	}
+
+	alg = strings.ToLower(strings.TrimSpace(alg))
+	if alg != "" {
+		return k, priv, alg, nil
+	}
+	switch pk := priv.(type) {
+	case ed25519.PrivateKey:
+		alg = "ed25519"
+	case *ecdsa.PrivateKey:
+		switch pk.Curve {
+		case elliptic.P256():
+			alg = "ecdsa-p256-sha256"
+		case elliptic.P384():
+			alg = "ecdsa-p384-sha384"
+		default:
+			return nil, nil, "", fmt.Errorf("unsupported ECDSA curve")
+		}
+	default:
+		return nil, nil, "", fmt.Errorf("unsupported key type %T", pk)
+	}
+	return k, priv, alg, nil
+}
+
+// quoteComponent serializes a component identifier, keeping the key parameter of
+// dictionary members such as signature;key="old-key" outside the quoted name.
+func quoteComponent(c string) string {
+	if name, member, ok := strings.Cut(c, ";key="); ok {
+		return fmt.Sprintf("%q;key=%s", name, member)
+	}
+	return fmt.Sprintf("%q", c)
+}
+
 func buildSignatureInput(label string, comps []string, params map[string]string) string {
 	var b strings.Builder
 	b.WriteString(label)
@@ -200,7 +251,7 @@ func buildSignatureInput(label string, comps []string, params map[string]string)
 		if i > 0 {
 			b.WriteByte(' ')
 		}
-		fmt.Fprintf(&b, "%q", c)
+		b.WriteString(quoteComponent(c))
 	}
 	b.WriteString(")")
 	if v := params["created"]; v != "" {
@@ -222,7 +273,7 @@ func buildSignatureBase(u *url.URL, method string, hdr map[string]string, comps
 		lc := strings.ToLower(c)
 		switch lc {
 		case "@method":
-			fmt.Fprintf(&b, "\"@method\": %s\n", strings.ToLower(method))
+			fmt.Fprintf(&b, "\"@method\": %s\n", method)
 		case "@target-uri":
 			scheme := u.Scheme
 			if scheme == "" {
@@ -231,6 +282,14 @@ func buildSignatureBase(u *url.URL, method string, hdr map[string]string, comps
 			host := u.Host
 			fmt.Fprintf(&b, "\"@target-uri\": %s://%s%s\n", scheme, host, u.RequestURI())
 		default:
+			if name, member, ok := strings.Cut(c, ";key="); ok {
+				v, ok := dictionaryMember(hdr[textprotoCanonical(name)], strings.Trim(member, "\""))
+				if !ok {
+					return nil, fmt.Errorf("missing covered member %s of %q", member, name)
+				}
+				fmt.Fprintf(&b, "%s: %s\n", quoteComponent(c), v)
+				continue
+			}
 			v, ok := hdr[textprotoCanonical(lc)]
 			if !ok {
 				return nil, fmt.Errorf("missing covered header %q", lc)
@@ -244,7 +303,7 @@ func buildSignatureBase(u *url.URL, method string, hdr map[string]string, comps
 		if i > 0 {
 			b.WriteByte(' ')
 		}
-		fmt.Fprintf(&b, "%q", c)
+		b.WriteString(quoteComponent(c))
 	}
 	b.WriteString(")")
 	if v := params["created"]; v != "" {
@@ -256,10 +315,20 @@ func buildSignatureBase(u *url.URL, method string, hdr map[string]string, comps
 	if v := params["alg"]; v != "" {
 		fmt.Fprintf(&b, ";alg=%q", strings.ToLower(v))
 	}
-	b.WriteByte('\n')
 	return []byte(b.String()), nil
 }
 
+// dictionaryMember finds member in a dictionary header value such as
+// Signature: old-key=:...:, new-key=:...:
+func dictionaryMember(h, member string) (string, bool) {
+	for _, m := range strings.Split(h, ", ") {
+		if k, v, ok := strings.Cut(m, "="); ok && k == member {
+			return v, true
+		}
+	}
+	return "", false
+}
+
 func signBase(priv any, alg string, base []byte) ([]byte, error) {
 	switch strings.ToLower(alg) {
 	case "ed25519":
@@ -274,19 +343,31 @@ func signBase(priv any, alg string, base []byte) ([]byte, error) {
 			return nil, fmt.Errorf("key not ecdsa p256")
 		}
 		sum := sha256.Sum256(base)
-		return ecdsa.SignASN1(rand.Reader, pk, sum[:])
+		return signECDSA(pk, sum[:], 32)
 	case "ecdsa-p384-sha384":
 		pk, ok := priv.(*ecdsa.PrivateKey)
 		if !ok || pk.Curve != elliptic.P384() {
 			return nil, fmt.Errorf("key not ecdsa p384")
 		}
 		sum := sha512.Sum384(base)
-		return ecdsa.SignASN1(rand.Reader, pk, sum[:])
+		return signECDSA(pk, sum[:], 48)
 	default:
 		return nil, fmt.Errorf("unsupported alg %q", alg)
 	}
 }
 
+// signECDSA produces the fixed-size r||s encoding RFC 9421 §3.3 requires for ECDSA.
+func signECDSA(pk *ecdsa.PrivateKey, digest []byte, size int) ([]byte, error) {
+	r, s, err := ecdsa.Sign(rand.Reader, pk, digest)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, 2*size)
+	r.FillBytes(out[:size])
+	s.FillBytes(out[size:])
+	return out, nil
+}
+
 func curlForCLI(method, rawURL string, body []byte, headers map[string]string) string {
 	var b strings.Builder
 	b.WriteString("curl -sS -X ")
@@ -300,7 +381,9 @@ func curlForCLI(method, rawURL string, body []byte, headers map[string]string) s
 	if len(body) > 0 {
 		b.WriteString(" -d ")
 		b.WriteString(fmt.Sprintf("%q", string(body)))
-		b.WriteString(" -H \"Content-Type: application/json\"")
+		if _, ok := headers["Content-Type"]; !ok {
+			b.WriteString(" -H \"Content-Type: application/json\"")
+		}
 	}
 	return b.String()
 }
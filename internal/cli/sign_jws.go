@@ -0,0 +1,78 @@
+package cli
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"fmt"
+	"time"
+
+	"github.com/TwigBush/gnap-go/internal/sign"
+	"github.com/lestrrat-go/jwx/v3/jwa"
+	"github.com/lestrrat-go/jwx/v3/jwk"
+	"github.com/lestrrat-go/jwx/v3/jws"
+)
+
+// signJWSProof signs a request with the jwsd (detached) or jws (attached) method from RFC 9635 §7.3.
+// It sets the Detached-JWS or Content-Type header as needed and returns the body to send.
+func signJWSProof(key jwk.Key, priv any, proof, method, rawURL, accessToken string, body []byte, headers map[string]string) ([]byte, error) {
+	alg, err := jwsAlgForPrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+
+	typ := sign.TypJWSD
+	if proof == sign.ProofJWS {
+		typ = sign.TypJWS
+	}
+
+	hdr := jws.NewHeaders()
+	if kid, ok := key.KeyID(); ok {
+		_ = hdr.Set(jws.KeyIDKey, kid)
+	}
+	_ = hdr.Set(jws.TypeKey, typ)
+	_ = hdr.Set("htm", method)
+	_ = hdr.Set("uri", rawURL)
+	_ = hdr.Set("created", time.Now().Unix())
+	if accessToken != "" {
+		_ = hdr.Set("ath", sign.AccessTokenHash(accessToken))
+	}
+	withKey := jws.WithKey(alg, key, jws.WithProtectedHeaders(hdr))
+
+	// Attached JWS: the body becomes the compact serialization
+	if proof == sign.ProofJWS && len(body) > 0 {
+		compact, err := jws.Sign(body, withKey)
+		if err != nil {
+			return nil, fmt.Errorf("sign jws: %w", err)
+		}
+		headers["Content-Type"] = sign.JOSEContentType
+		return compact, nil
+	}
+
+	// Detached JWS, also used by the jws method when there is no body
+	payload := []byte{}
+	if proof == sign.ProofJWSD {
+		payload = sign.DetachedPayload(body)
+	}
+	compact, err := jws.Sign(nil, withKey, jws.WithDetachedPayload(payload))
+	if err != nil {
+		return nil, fmt.Errorf("sign detached jws: %w", err)
+	}
+	headers[sign.DetachedJWSHdr] = string(compact)
+	return body, nil
+}
+
+func jwsAlgForPrivateKey(priv any) (jwa.SignatureAlgorithm, error) {
+	switch pk := priv.(type) {
+	case ed25519.PrivateKey:
+		return jwa.EdDSA(), nil
+	case *ecdsa.PrivateKey:
+		switch pk.Curve {
+		case elliptic.P256():
+			return jwa.ES256(), nil
+		case elliptic.P384():
+			return jwa.ES384(), nil
+		}
+	}
+	return jwa.SignatureAlgorithm{}, fmt.Errorf("unsupported key type %T for JWS", priv)
+}
@@ -49,8 +49,7 @@ func cmdTokenUse() *cobra.Command {
 			if err != nil {
 				return err
 			}
-			fmt.Printf("HTTP %d\n", code)
-			return printJSON(resp)
+			return printResponse(code, resp)
 		},
 	}
 	c.Flags().StringVar(&label, "label", "access0", "token label to use")
@@ -0,0 +1,89 @@
+package gnap
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/TwigBush/gnap-go/internal/types"
+)
+
+// AccessCovered reports whether every item in requested is within what approved
+// already grants, so a modified request can be honored without asking the user again.
+// An item is covered by an approved item of the same type and id whose actions,
+// locations and datatypes include the requested ones.
+func AccessCovered(requested, approved types.AccessTokenRequest) bool {
+	var granted []types.AccessItem
+	for _, t := range approved {
+		granted = append(granted, t.Access...)
+	}
+	for _, t := range requested {
+		for _, item := range t.Access {
+			if !itemCovered(item, granted) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// ValidateFlags checks the flags of each requested token: only flags this AS knows, and
+// none repeated (RFC 9635 §2.1.1).
+func ValidateFlags(access types.AccessTokenRequest) error {
+	for _, tok := range access {
+		seen := map[string]bool{}
+		for _, f := range tok.Flags {
+			if f != types.FlagBearer && f != types.FlagDurable {
+				return NewError(CodeInvalidFlag, fmt.Sprintf("unknown flag %q", f))
+			}
+			if seen[f] {
+				return NewError(CodeInvalidFlag, fmt.Sprintf("repeated flag %q", f))
+			}
+			seen[f] = true
+		}
+	}
+	return nil
+}
+
+// ValidateLabels checks token labels: when the client asks for several tokens each
+// must have a label no other token in the request has (RFC 9635 §2.1.2).
+func ValidateLabels(access types.AccessTokenRequest, multiple bool) error {
+	if !multiple {
+		return nil
+	}
+	seen := map[string]bool{}
+	for _, tok := range access {
+		if tok.Label == "" {
+			return NewError(CodeInvalidRequest, "each requested access token needs a label")
+		}
+		if seen[tok.Label] {
+			return NewError(CodeInvalidRequest, fmt.Sprintf("duplicate access token label %q", tok.Label))
+		}
+		seen[tok.Label] = true
+	}
+	return nil
+}
+
+func itemCovered(item types.AccessItem, granted []types.AccessItem) bool {
+	for _, g := range granted {
+		if g.Type != item.Type || g.ID != item.ID || g.Identifier != item.Identifier {
+			continue
+		}
+		if !subset(item.Actions, g.Actions) || !subset(item.Locations, g.Locations) || !subset(item.Datatypes, g.Datatypes) {
+			continue
+		}
+		if len(item.Constraints) > 0 && !bytes.Equal(item.Constraints, g.Constraints) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+func subset(sub, set []string) bool {
+	for _, v := range sub {
+		if !contains(set, v) {
+			return false
+		}
+	}
+	return true
+}
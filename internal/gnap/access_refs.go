@@ -0,0 +1,219 @@
+package gnap
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TwigBush/gnap-go/internal/types"
+)
+
+// AccessDefinition is named access that clients may request by reference: a string
+// in the access array stands for Access (RFC 9635 §8.1). Definitions are written by
+// an admin as files, or registered by an RS as a resource set (RFC 9767 §3.4).
+type AccessDefinition struct {
+	Tenant         string             `json:"tenant"`
+	Name           string             `json:"name"`
+	Access         []types.AccessItem `json:"access"`
+	ResourceServer string             `json:"resource_server,omitempty"` // the RS that registered it
+	CreatedAt      time.Time          `json:"created_at"`
+}
+
+var ErrInvalidAccessDefinition = Err("invalid access definition")
+
+var accessNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._:-]{0,127}$`)
+
+// ValidateAccessDefinition checks that a definition has a usable name and only
+// object-form access items, since a reference cannot name other references.
+func ValidateAccessDefinition(def AccessDefinition) error {
+	if !accessNamePattern.MatchString(def.Name) {
+		return fmt.Errorf("%w: invalid name %q", ErrInvalidAccessDefinition, def.Name)
+	}
+	if len(def.Access) == 0 {
+		return fmt.Errorf("%w: no access", ErrInvalidAccessDefinition)
+	}
+	for _, item := range def.Access {
+		if item.Ref != "" || item.Type == "" {
+			return fmt.Errorf("%w: access items must be objects with a type", ErrInvalidAccessDefinition)
+		}
+	}
+	return nil
+}
+
+// AccessDefinitions is the registry of named access, kept per tenant under
+// <dataDir>/access/<tenant>/<name>.json. Files dropped there by hand are loaded at
+// startup; a file without a name is known by its file name.
+type AccessDefinitions struct {
+	mu      sync.RWMutex
+	dataDir string
+	cache   map[string]map[string]AccessDefinition // tenant -> name -> definition
+}
+
+func NewAccessDefinitions(dataDir string) (*AccessDefinitions, error) {
+	if err := os.MkdirAll(dataDir, 0700); err != nil {
+		return nil, fmt.Errorf("create data dir: %w", err)
+	}
+	s := &AccessDefinitions{
+		dataDir: dataDir,
+		cache:   make(map[string]map[string]AccessDefinition),
+	}
+	if err := s.loadFromDisk(); err != nil {
+		return nil, fmt.Errorf("load from disk: %w", err)
+	}
+	return s, nil
+}
+
+// RegisterResourceSet records access an RS wants clients to be able to ask for by
+// reference, and returns the definition holding the reference. An RS registering the
+// same access again gets the same reference back.
+func (s *AccessDefinitions) RegisterResourceSet(ctx context.Context, tenant, rsID string, access []types.AccessItem) (AccessDefinition, error) {
+	want, err := json.Marshal(access)
+	if err != nil {
+		return AccessDefinition{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, def := range s.cache[tenant] {
+		if def.ResourceServer != rsID {
+			continue
+		}
+		if got, err := json.Marshal(def.Access); err == nil && bytes.Equal(got, want) {
+			return def, nil
+		}
+	}
+
+	def := AccessDefinition{
+		Tenant:         tenant,
+		Name:           randHex(16),
+		Access:         access,
+		ResourceServer: rsID,
+		CreatedAt:      time.Now().UTC(),
+	}
+	if err := ValidateAccessDefinition(def); err != nil {
+		return AccessDefinition{}, err
+	}
+	if err := s.put(def); err != nil {
+		return AccessDefinition{}, err
+	}
+	return def, nil
+}
+
+// GetDefinition returns tenant's definition of name. A nil registry knows none.
+func (s *AccessDefinitions) GetDefinition(ctx context.Context, tenant, name string) (AccessDefinition, bool) {
+	if s == nil {
+		return AccessDefinition{}, false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	def, ok := s.cache[tenant][name]
+	return def, ok
+}
+
+// Expand replaces the references in access with the items tenant's definitions name,
+// leaving access itself untouched. It also returns each reference used with the items
+// it expanded to. An unknown reference is an invalid_request.
+func (s *AccessDefinitions) Expand(ctx context.Context, tenant string, access types.AccessTokenRequest) (types.AccessTokenRequest, map[string][]types.AccessItem, error) {
+	var refs map[string][]types.AccessItem
+	out := make(types.AccessTokenRequest, len(access))
+	for i, tok := range access {
+		out[i] = tok
+		out[i].Access = nil
+		for _, item := range tok.Access {
+			if item.Ref == "" {
+				out[i].Access = append(out[i].Access, item)
+				continue
+			}
+			def, ok := s.GetDefinition(ctx, tenant, item.Ref)
+			if !ok {
+				return nil, nil, NewError(CodeInvalidRequest, fmt.Sprintf("unknown access reference %q", item.Ref))
+			}
+			out[i].Access = append(out[i].Access, def.Access...)
+			if refs == nil {
+				refs = map[string][]types.AccessItem{}
+			}
+			refs[item.Ref] = def.Access
+		}
+	}
+	return out, refs, nil
+}
+
+// put caches and saves def. Callers hold s.mu.
+func (s *AccessDefinitions) put(def AccessDefinition) error {
+	path := s.path(def.Tenant, def.Name)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(def, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return err
+	}
+	if _, ok := s.cache[def.Tenant]; !ok {
+		s.cache[def.Tenant] = make(map[string]AccessDefinition)
+	}
+	s.cache[def.Tenant][def.Name] = def
+	return nil
+}
+
+func (s *AccessDefinitions) path(tenant, name string) string {
+	return filepath.Join(s.dataDir, "access", tenant, name+".json")
+}
+
+func (s *AccessDefinitions) loadFromDisk() error {
+	baseDir := filepath.Join(s.dataDir, "access")
+	tenants, err := os.ReadDir(baseDir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, tenantEntry := range tenants {
+		if !tenantEntry.IsDir() || !ValidTenant(tenantEntry.Name()) {
+			continue
+		}
+		tenant := tenantEntry.Name()
+		files, err := os.ReadDir(filepath.Join(baseDir, tenant))
+		if err != nil {
+			continue
+		}
+		for _, file := range files {
+			if file.IsDir() || filepath.Ext(file.Name()) != ".json" {
+				continue
+			}
+			path := filepath.Join(baseDir, tenant, file.Name())
+			data, err := os.ReadFile(path)
+			if err != nil {
+				continue
+			}
+			var def AccessDefinition
+			if err := json.Unmarshal(data, &def); err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			def.Tenant = tenant
+			if def.Name == "" {
+				def.Name = strings.TrimSuffix(file.Name(), ".json")
+			}
+			// A broken definition would fail every grant that names it, so refuse to start
+			if err := ValidateAccessDefinition(def); err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			if _, ok := s.cache[tenant]; !ok {
+				s.cache[tenant] = make(map[string]AccessDefinition)
+			}
+			s.cache[tenant][def.Name] = def
+		}
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package gnap
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/TwigBush/gnap-go/internal/types"
+)
+
+func TestAccessDefinitions_Expand(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	tenantDir := filepath.Join(dir, "access", DefaultTenant)
+	if err := os.MkdirAll(tenantDir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	def := `{"access": [{"type": "orders", "actions": ["read", "list"]}, {"type": "invoices", "actions": ["read"]}]}`
+	if err := os.WriteFile(filepath.Join(tenantDir, "read-orders.json"), []byte(def), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	defs, err := NewAccessDefinitions(dir)
+	if err != nil {
+		t.Fatalf("NewAccessDefinitions: %v", err)
+	}
+
+	var access types.AccessTokenRequest
+	if err := json.Unmarshal([]byte(`{"access": ["read-orders", {"type": "photo-api", "actions": ["read"]}]}`), &access); err != nil {
+		t.Fatalf("unmarshal mixed access: %v", err)
+	}
+	if got := access[0].Access[0].Ref; got != "read-orders" {
+		t.Fatalf("Ref = %q, want read-orders", got)
+	}
+
+	expanded, refs, err := defs.Expand(ctx, DefaultTenant, access)
+	if err != nil {
+		t.Fatalf("Expand: %v", err)
+	}
+	var gotTypes []string
+	for _, item := range expanded[0].Access {
+		gotTypes = append(gotTypes, item.Type)
+	}
+	if want := []string{"orders", "invoices", "photo-api"}; !slices.Equal(gotTypes, want) {
+		t.Fatalf("expanded types = %v, want %v", gotTypes, want)
+	}
+	if len(refs) != 1 || len(refs["read-orders"]) != 2 {
+		t.Fatalf("refs = %v, want read-orders with 2 items", refs)
+	}
+	if access[0].Access[0].Ref != "read-orders" {
+		t.Fatalf("Expand modified its input")
+	}
+
+	if _, _, err := defs.Expand(ctx, "other", access); !errors.Is(err, ErrInvalidRequest) {
+		t.Fatalf("Expand in a tenant without the definition error = %v, want invalid_request", err)
+	}
+	var none *AccessDefinitions
+	if _, _, err := none.Expand(ctx, DefaultTenant, access); !errors.Is(err, ErrInvalidRequest) {
+		t.Fatalf("Expand without a registry error = %v, want invalid_request", err)
+	}
+}
+
+func TestAccessDefinitions_RegisterResourceSet(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	defs, err := NewAccessDefinitions(dir)
+	if err != nil {
+		t.Fatalf("NewAccessDefinitions: %v", err)
+	}
+	access := []types.AccessItem{{Type: "orders", Actions: []string{"read"}}}
+
+	def, err := defs.RegisterResourceSet(ctx, DefaultTenant, "rs-1", access)
+	if err != nil || def.Name == "" {
+		t.Fatalf("RegisterResourceSet() = %v, %v, want a reference", def, err)
+	}
+	if again, _ := defs.RegisterResourceSet(ctx, DefaultTenant, "rs-1", access); again.Name != def.Name {
+		t.Fatalf("registering the same access again = %q, want %q", again.Name, def.Name)
+	}
+	if other, _ := defs.RegisterResourceSet(ctx, DefaultTenant, "rs-2", access); other.Name == def.Name {
+		t.Fatalf("another RS got the same reference %q", other.Name)
+	}
+	for _, bad := range [][]types.AccessItem{nil, {{Ref: "read-orders"}}, {{Actions: []string{"read"}}}} {
+		if _, err := defs.RegisterResourceSet(ctx, DefaultTenant, "rs-1", bad); !errors.Is(err, ErrInvalidAccessDefinition) {
+			t.Fatalf("RegisterResourceSet(%v) error = %v, want %v", bad, err, ErrInvalidAccessDefinition)
+		}
+	}
+
+	// Registered definitions survive a restart
+	reloaded, err := NewAccessDefinitions(dir)
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if got, ok := reloaded.GetDefinition(ctx, DefaultTenant, def.Name); !ok || got.ResourceServer != "rs-1" {
+		t.Fatalf("reloaded definition = %v, %v, want rs-1's", got, ok)
+	}
+}
@@ -0,0 +1,94 @@
+package gnap
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/TwigBush/gnap-go/internal/types"
+)
+
+func TestAccessCovered(t *testing.T) {
+	approved := types.AccessTokenRequest{{Access: []types.AccessItem{
+		{Type: "photo-api", Actions: []string{"read", "write"}, Locations: []string{"https://rs.example"}},
+	}}}
+	req := func(items ...types.AccessItem) types.AccessTokenRequest {
+		return types.AccessTokenRequest{{Access: items}}
+	}
+
+	tests := []struct {
+		name      string
+		requested types.AccessTokenRequest
+		want      bool
+	}{
+		{"same access", approved, true},
+		{"fewer actions", req(types.AccessItem{Type: "photo-api", Actions: []string{"read"}}), true},
+		{"extra action", req(types.AccessItem{Type: "photo-api", Actions: []string{"delete"}}), false},
+		{"other location", req(types.AccessItem{Type: "photo-api", Locations: []string{"https://other.example"}}), false},
+		{"other type", req(types.AccessItem{Type: "billing-api"}), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := AccessCovered(tt.requested, approved); got != tt.want {
+				t.Fatalf("AccessCovered() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateFlags(t *testing.T) {
+	tests := []struct {
+		name  string
+		flags []string
+		ok    bool
+	}{
+		{"none", nil, true},
+		{"bearer and durable", []string{types.FlagBearer, types.FlagDurable}, true},
+		{"unknown", []string{"split"}, false},
+		{"repeated", []string{types.FlagDurable, types.FlagDurable}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			access := types.AccessTokenRequest{{Access: []types.AccessItem{{Type: "photo-api"}}, Flags: tt.flags}}
+			err := ValidateFlags(access)
+			if tt.ok && err != nil {
+				t.Fatalf("ValidateFlags() = %v, want nil", err)
+			}
+			if !tt.ok && !errors.Is(err, ErrInvalidFlag) {
+				t.Fatalf("ValidateFlags() = %v, want invalid_flag", err)
+			}
+		})
+	}
+}
+
+func TestValidateLabels(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		ok   bool
+	}{
+		{"single object", `{"access_token": {"access": [{"type": "photo-api"}]}}`, true},
+		{"labeled array", `{"access_token": [{"label": "a", "access": ["read"]}, {"label": "b", "access": ["write"]}]}`, true},
+		{"array of one needs a label", `{"access_token": [{"access": [{"type": "photo-api"}]}]}`, false},
+		{"missing label", `{"access_token": [{"label": "a", "access": ["read"]}, {"access": ["write"]}]}`, false},
+		{"duplicate label", `{"access_token": [{"label": "a", "access": ["read"]}, {"label": "a", "access": ["write"]}]}`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req types.GrantRequest
+			if err := json.Unmarshal([]byte(tt.body), &req); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			err := ValidateLabels(req.AccessToken, req.MultipleTokens)
+			if tt.ok && err != nil {
+				t.Fatalf("ValidateLabels() = %v, want nil", err)
+			}
+			if !tt.ok && !errors.Is(err, ErrInvalidRequest) {
+				t.Fatalf("ValidateLabels() = %v, want invalid_request", err)
+			}
+		})
+	}
+}
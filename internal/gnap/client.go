@@ -0,0 +1,27 @@
+package gnap
+
+import (
+	"net/url"
+
+	"github.com/TwigBush/gnap-go/internal/types"
+)
+
+var ErrInvalidClientDisplay = Err("client display uri and logo_uri must be absolute http(s) URIs")
+
+// ValidateClientDisplay checks the display information a client sent about itself
+// (RFC 9635 §2.3.2). It is shown to the user, so its links must be web links.
+func ValidateClientDisplay(d *types.ClientDisplay) error {
+	if d == nil {
+		return nil
+	}
+	for _, v := range []string{d.URI, d.LogoURI} {
+		if v == "" {
+			continue
+		}
+		u, err := url.Parse(v)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return ErrInvalidClientDisplay
+		}
+	}
+	return nil
+}
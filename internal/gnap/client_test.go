@@ -0,0 +1,31 @@
+package gnap
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/TwigBush/gnap-go/internal/types"
+)
+
+func TestValidateClientDisplay(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      *types.ClientDisplay
+		wantErr error
+	}{
+		{"no display", nil, nil},
+		{"name only", &types.ClientDisplay{Name: "Soup Planner"}, nil},
+		{"web links", &types.ClientDisplay{Name: "Soup Planner", URI: "https://soup.example", LogoURI: "http://soup.example/logo.png"}, nil},
+		{"script uri", &types.ClientDisplay{URI: "javascript:alert(1)"}, ErrInvalidClientDisplay},
+		{"relative logo", &types.ClientDisplay{LogoURI: "/logo.png"}, ErrInvalidClientDisplay},
+		{"data logo", &types.ClientDisplay{LogoURI: "data:image/png;base64,AAAA"}, ErrInvalidClientDisplay},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateClientDisplay(tt.in); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ValidateClientDisplay() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package gnap
+
+import (
+	"errors"
+	"net/http"
+)
+
+// ErrorCode is a GNAP error code (RFC 9635 §3.6).
+type ErrorCode string
+
+const (
+	CodeInvalidRequest          ErrorCode = "invalid_request"
+	CodeInvalidClient           ErrorCode = "invalid_client"
+	CodeInvalidInteraction      ErrorCode = "invalid_interaction"
+	CodeInvalidFlag             ErrorCode = "invalid_flag"
+	CodeInvalidRotation         ErrorCode = "invalid_rotation"
+	CodeKeyRotationNotSupported ErrorCode = "key_rotation_not_supported"
+	CodeInvalidContinuation     ErrorCode = "invalid_continuation"
+	CodeUserDenied              ErrorCode = "user_denied"
+	CodeRequestDenied           ErrorCode = "request_denied"
+	CodeUnknownUser             ErrorCode = "unknown_user"
+	CodeUnknownInteraction      ErrorCode = "unknown_interaction"
+	CodeTooFast                 ErrorCode = "too_fast"
+	CodeTooManyAttempts         ErrorCode = "too_many_attempts"
+)
+
+// errorStatus is the HTTP status each code is returned with. Codes not listed use 400.
+var errorStatus = map[ErrorCode]int{
+	CodeInvalidClient:      http.StatusUnauthorized,
+	CodeUserDenied:         http.StatusForbidden,
+	CodeRequestDenied:      http.StatusForbidden,
+	CodeUnknownInteraction: http.StatusNotFound,
+	CodeTooFast:            http.StatusTooManyRequests,
+	CodeTooManyAttempts:    http.StatusTooManyRequests,
+}
+
+// Status is the HTTP status the code is returned with.
+func (c ErrorCode) Status() int {
+	if s, ok := errorStatus[c]; ok {
+		return s
+	}
+	return http.StatusBadRequest
+}
+
+// Error is a GNAP error response: a code from the catalog and an optional
+// human-readable description. It marshals to the object form of the "error" field.
+type Error struct {
+	Code        ErrorCode `json:"code"`
+	Description string    `json:"description,omitempty"`
+
+	cause error
+}
+
+// NewError returns an error with the given code and description.
+func NewError(code ErrorCode, description string) *Error {
+	return &Error{Code: code, Description: description}
+}
+
+// WrapError reports err under code, using its message as the description.
+// errors.Is and errors.As still see err.
+func WrapError(code ErrorCode, err error) *Error {
+	return &Error{Code: code, Description: err.Error(), cause: err}
+}
+
+func (e *Error) Error() string {
+	if e.Description == "" {
+		return string(e.Code)
+	}
+	return string(e.Code) + ": " + e.Description
+}
+
+func (e *Error) Unwrap() error { return e.cause }
+
+// Is matches another *Error with the same code. A target with a description
+// must match that too, so a bare code like ErrTooFast matches any too_fast error.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	if !ok || t.Code != e.Code {
+		return false
+	}
+	return t.Description == "" || t.Description == e.Description
+}
+
+// Status is the HTTP status the error is returned with.
+func (e *Error) Status() int { return e.Code.Status() }
+
+// AsError finds the GNAP error in err's chain. Errors from outside the catalog
+// are reported as invalid_request.
+func AsError(err error) *Error {
+	var ge *Error
+	if errors.As(err, &ge) {
+		return ge
+	}
+	return WrapError(CodeInvalidRequest, err)
+}
+
+// Bare codes, for errors.Is.
+var (
+	ErrInvalidRequest          = NewError(CodeInvalidRequest, "")
+	ErrInvalidClient           = NewError(CodeInvalidClient, "")
+	ErrInvalidInteraction      = NewError(CodeInvalidInteraction, "")
+	ErrInvalidFlag             = NewError(CodeInvalidFlag, "")
+	ErrInvalidRotation         = NewError(CodeInvalidRotation, "")
+	ErrKeyRotationNotSupported = NewError(CodeKeyRotationNotSupported, "")
+	ErrInvalidContinuation     = NewError(CodeInvalidContinuation, "")
+	ErrUserDenied              = NewError(CodeUserDenied, "")
+	ErrRequestDenied           = NewError(CodeRequestDenied, "")
+	ErrUnknownUser             = NewError(CodeUnknownUser, "")
+	ErrUnknownInteraction      = NewError(CodeUnknownInteraction, "")
+	ErrTooFast                 = NewError(CodeTooFast, "")
+	ErrTooManyAttempts         = NewError(CodeTooManyAttempts, "")
+)
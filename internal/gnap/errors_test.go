@@ -0,0 +1,36 @@
+package gnap
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestAsError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantCode   ErrorCode
+		wantStatus int
+	}{
+		{"catalog error", NewError(CodeTooFast, "slow down"), CodeTooFast, http.StatusTooManyRequests},
+		{"wrapped catalog error", fmt.Errorf("poll: %w", ErrGrantNotFound), CodeInvalidContinuation, http.StatusBadRequest},
+		{"plain error", errors.New("boom"), CodeInvalidRequest, http.StatusBadRequest},
+		{"invalid client", NewError(CodeInvalidClient, ""), CodeInvalidClient, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := AsError(tt.err)
+			if got.Code != tt.wantCode || got.Status() != tt.wantStatus {
+				t.Fatalf("AsError() = %s (%d), want %s (%d)", got.Code, got.Status(), tt.wantCode, tt.wantStatus)
+			}
+		})
+	}
+
+	err := WrapError(CodeInvalidContinuation, fmt.Errorf("%w: finalized", ErrInvalidTransition))
+	if !errors.Is(err, ErrInvalidContinuation) || !errors.Is(err, ErrInvalidTransition) || errors.Is(err, ErrGrantNotFound) {
+		t.Fatalf("errors.Is on %v matched the wrong errors", err)
+	}
+}
@@ -0,0 +1,112 @@
+package gnap
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"hash"
+	"net/netip"
+	"net/url"
+	"strings"
+
+	"github.com/TwigBush/gnap-go/internal/types"
+)
+
+// Interaction hash methods (RFC 9635 §4.2.3, named per the IANA hash algorithm registry)
+const (
+	HashSHA256 = "sha-256"
+	HashSHA512 = "sha-512"
+)
+
+var (
+	ErrUnsupportedStart      = Err("unsupported interaction start mode")
+	ErrUnsupportedFinish     = Err("unsupported interaction finish method")
+	ErrInvalidFinish         = Err("interaction finish requires an absolute uri and a nonce")
+	ErrUnsafePushFinish      = Err("push finish uri must be https on a public host, without userinfo or fragment")
+	ErrUnsupportedHashMethod = Err("unsupported interaction hash method")
+)
+
+// ValidateInteract checks a client's interact request against the start modes
+// and finish methods this AS supports.
+func ValidateInteract(in *types.Interact, starts, finishes []string) error {
+	if in == nil {
+		return nil
+	}
+	for _, m := range in.Start {
+		if !contains(starts, m) {
+			return ErrUnsupportedStart
+		}
+	}
+	if in.Finish == nil {
+		return nil
+	}
+	if !contains(finishes, in.Finish.Method) {
+		return ErrUnsupportedFinish
+	}
+	u, err := url.Parse(in.Finish.URI)
+	if err != nil || !u.IsAbs() || u.Host == "" || in.Finish.Nonce == "" {
+		return ErrInvalidFinish
+	}
+	// The AS itself posts to push finish URIs, so they must not reach into its network
+	if in.Finish.Method == types.InteractFinishPush && !safePushURI(u, in.Finish.URI) {
+		return ErrUnsafePushFinish
+	}
+	if _, err := newHash(in.Finish.HashMethod); err != nil {
+		return err
+	}
+	return nil
+}
+
+func safePushURI(u *url.URL, raw string) bool {
+	if u.Scheme != "https" || u.User != nil || strings.Contains(raw, "#") {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return false
+	}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return PublicAddr(ip)
+	}
+	return true
+}
+
+// PublicAddr reports whether ip is a public unicast address, one the AS may deliver
+// push finishes to. Loopback, link-local, private and unspecified addresses are not.
+func PublicAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsGlobalUnicast() && !ip.IsPrivate()
+}
+
+// InteractHash computes the interaction finish hash the client uses to check that
+// the interact_ref came from this AS for this request:
+//
+//	base64url(HASH(client_nonce "\n" as_nonce "\n" interact_ref "\n" grant_endpoint_uri))
+func InteractHash(method, clientNonce, asNonce, interactRef, grantURI string) (string, error) {
+	h, err := newHash(method)
+	if err != nil {
+		return "", err
+	}
+	h.Write([]byte(clientNonce + "\n" + asNonce + "\n" + interactRef + "\n" + grantURI))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+func newHash(method string) (hash.Hash, error) {
+	switch method {
+	case "", HashSHA256:
+		return sha256.New(), nil
+	case HashSHA512:
+		return sha512.New(), nil
+	default:
+		return nil, ErrUnsupportedHashMethod
+	}
+}
+
+func contains(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
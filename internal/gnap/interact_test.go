@@ -0,0 +1,64 @@
+package gnap
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"github.com/TwigBush/gnap-go/internal/types"
+)
+
+func TestValidateInteract(t *testing.T) {
+	starts := []string{types.InteractStartRedirect, types.InteractStartUserCode}
+	finishes := []string{types.InteractFinishRedirect, types.InteractFinishPush}
+	finish := func(method, uri, nonce, hash string) *types.InteractFinish {
+		return &types.InteractFinish{Method: method, URI: uri, Nonce: nonce, HashMethod: hash}
+	}
+
+	tests := []struct {
+		name    string
+		in      *types.Interact
+		wantErr error
+	}{
+		{"no interaction", nil, nil},
+		{"redirect with finish", &types.Interact{Start: []string{"redirect"}, Finish: finish("redirect", "https://client.example/cb", "n1", "")}, nil},
+		{"sha-512", &types.Interact{Start: []string{"redirect"}, Finish: finish("redirect", "https://client.example/cb", "n1", HashSHA512)}, nil},
+		{"unknown start", &types.Interact{Start: []string{"carrier_pigeon"}}, ErrUnsupportedStart},
+		{"unknown finish", &types.Interact{Start: []string{"redirect"}, Finish: finish("fax", "https://client.example/cb", "n1", "")}, ErrUnsupportedFinish},
+		{"relative uri", &types.Interact{Start: []string{"redirect"}, Finish: finish("redirect", "/cb", "n1", "")}, ErrInvalidFinish},
+		{"missing nonce", &types.Interact{Start: []string{"redirect"}, Finish: finish("redirect", "https://client.example/cb", "", "")}, ErrInvalidFinish},
+		{"push", &types.Interact{Start: []string{"redirect"}, Finish: finish("push", "https://client.example/push", "n1", "")}, nil},
+		{"push over http", &types.Interact{Start: []string{"redirect"}, Finish: finish("push", "http://client.example/push", "n1", "")}, ErrUnsafePushFinish},
+		{"push with fragment", &types.Interact{Start: []string{"redirect"}, Finish: finish("push", "https://client.example/push#x", "n1", "")}, ErrUnsafePushFinish},
+		{"push with userinfo", &types.Interact{Start: []string{"redirect"}, Finish: finish("push", "https://user:pw@client.example/push", "n1", "")}, ErrUnsafePushFinish},
+		{"push to localhost", &types.Interact{Start: []string{"redirect"}, Finish: finish("push", "https://localhost/push", "n1", "")}, ErrUnsafePushFinish},
+		{"push to loopback", &types.Interact{Start: []string{"redirect"}, Finish: finish("push", "https://127.0.0.1:8443/push", "n1", "")}, ErrUnsafePushFinish},
+		{"push to IPv6 loopback", &types.Interact{Start: []string{"redirect"}, Finish: finish("push", "https://[::1]/push", "n1", "")}, ErrUnsafePushFinish},
+		{"push to link-local", &types.Interact{Start: []string{"redirect"}, Finish: finish("push", "https://169.254.169.254/latest", "n1", "")}, ErrUnsafePushFinish},
+		{"push to private", &types.Interact{Start: []string{"redirect"}, Finish: finish("push", "https://10.0.0.5/push", "n1", "")}, ErrUnsafePushFinish},
+		{"push to mapped private", &types.Interact{Start: []string{"redirect"}, Finish: finish("push", "https://[::ffff:192.168.1.1]/push", "n1", "")}, ErrUnsafePushFinish},
+		{"redirect to localhost", &types.Interact{Start: []string{"redirect"}, Finish: finish("redirect", "http://localhost:3000/cb", "n1", "")}, nil},
+		{"unknown hash", &types.Interact{Start: []string{"redirect"}, Finish: finish("redirect", "https://client.example/cb", "n1", "md5")}, ErrUnsupportedHashMethod},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateInteract(tt.in, starts, finishes)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ValidateInteract() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestInteractHash(t *testing.T) {
+	got, err := InteractHash("", "VJLO6A4CATR0KRO", "MBDOFXG4Y5CVJCX821LH", "4IFWWIKYB2PQ6U56NL1", "https://server.example.com/tx")
+	if err != nil {
+		t.Fatalf("InteractHash() error = %v", err)
+	}
+	sum := sha256.Sum256([]byte("VJLO6A4CATR0KRO\nMBDOFXG4Y5CVJCX821LH\n4IFWWIKYB2PQ6U56NL1\nhttps://server.example.com/tx"))
+	if want := base64.RawURLEncoding.EncodeToString(sum[:]); got != want {
+		t.Fatalf("InteractHash() = %q, want %q", got, want)
+	}
+}
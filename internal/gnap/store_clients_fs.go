@@ -0,0 +1,281 @@
+package gnap
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/TwigBush/gnap-go/internal/types"
+)
+
+// ClientRecord is a pre-registered client instance. Requests may name it by
+// InstanceID instead of sending the key, and may only ask for AllowedAccess types.
+type ClientRecord struct {
+	Tenant        string               `json:"tenant"`
+	InstanceID    string               `json:"instance_id"`
+	Key           types.ClientKey      `json:"key"`
+	Thumbprint    string               `json:"thumbprint"` // of Key, to recognize the client when it sends its key
+	Display       *types.ClientDisplay `json:"display,omitempty"`
+	AllowedAccess []string             `json:"allowed_access,omitempty"` // access types; empty allows any
+	CreatedAt     time.Time            `json:"created_at"`
+	UpdatedAt     time.Time            `json:"updated_at"`
+}
+
+// AccessTypesWithin reports whether every access item requested is of one of types.
+func AccessTypesWithin(access types.AccessTokenRequest, allowed []string) bool {
+	for _, tok := range access {
+		for _, item := range tok.Access {
+			if !contains(allowed, item.Type) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+var (
+	ErrClientNotFound = Err("client not found")
+	ErrClientKeyInUse = Err("key already registered to another client")
+	ErrNoClientStore  = Err("no client registry is configured")
+)
+
+// ClientStore is the registry of pre-registered clients, kept per tenant under
+// <dataDir>/clients/<tenant>/<instance_id>.json.
+type ClientStore struct {
+	mu      sync.RWMutex
+	dataDir string
+	cache   map[string]map[string]ClientRecord // tenant -> instance_id -> record
+}
+
+func NewClientStore(dataDir string) (*ClientStore, error) {
+	if err := os.MkdirAll(dataDir, 0700); err != nil {
+		return nil, fmt.Errorf("create data dir: %w", err)
+	}
+	s := &ClientStore{
+		dataDir: dataDir,
+		cache:   make(map[string]map[string]ClientRecord),
+	}
+	if err := s.loadFromDisk(); err != nil {
+		return nil, fmt.Errorf("load from disk: %w", err)
+	}
+	return s, nil
+}
+
+// RegisterClient adds a client to tenant, assigning its instance_id. A key may belong to
+// only one client in a tenant.
+func (s *ClientStore) RegisterClient(ctx context.Context, tenant string, rec ClientRecord) (ClientRecord, error) {
+	if s == nil {
+		return ClientRecord{}, ErrNoClientStore
+	}
+	now := time.Now().UTC()
+	rec.Tenant = tenant
+	rec.InstanceID = randHex(16)
+	rec.CreatedAt, rec.UpdatedAt = now, now
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.keyInUse(tenant, rec.Thumbprint, rec.InstanceID) {
+		return ClientRecord{}, ErrClientKeyInUse
+	}
+	if err := s.put(rec); err != nil {
+		return ClientRecord{}, err
+	}
+	return rec, nil
+}
+
+// UpdateClient replaces the key, display and allowed access of a registered client.
+// The key may not belong to another client in the tenant.
+func (s *ClientStore) UpdateClient(ctx context.Context, tenant string, rec ClientRecord) (ClientRecord, error) {
+	if s == nil {
+		return ClientRecord{}, ErrClientNotFound
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.cache[tenant][rec.InstanceID]
+	if !ok {
+		return ClientRecord{}, ErrClientNotFound
+	}
+	if s.keyInUse(tenant, rec.Thumbprint, rec.InstanceID) {
+		return ClientRecord{}, ErrClientKeyInUse
+	}
+	rec.Tenant = tenant
+	rec.CreatedAt = existing.CreatedAt
+	rec.UpdatedAt = time.Now().UTC()
+	if err := s.put(rec); err != nil {
+		return ClientRecord{}, err
+	}
+	return rec, nil
+}
+
+// GetClient returns the registered client. A nil store knows no clients.
+func (s *ClientStore) GetClient(ctx context.Context, tenant, instanceID string) (ClientRecord, bool) {
+	if s == nil {
+		return ClientRecord{}, false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rec, ok := s.cache[tenant][instanceID]
+	return rec, ok
+}
+
+// FindClientByThumbprint finds the registered client holding the key with thumbprint.
+func (s *ClientStore) FindClientByThumbprint(ctx context.Context, tenant, thumbprint string) (ClientRecord, bool) {
+	if s == nil {
+		return ClientRecord{}, false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, rec := range s.cache[tenant] {
+		if rec.Thumbprint == thumbprint {
+			return rec, true
+		}
+	}
+	return ClientRecord{}, false
+}
+
+func (s *ClientStore) ListClients(ctx context.Context, tenant string) []ClientRecord {
+	clients := []ClientRecord{}
+	if s == nil {
+		return clients
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, rec := range s.cache[tenant] {
+		clients = append(clients, rec)
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].CreatedAt.Before(clients[j].CreatedAt) })
+	return clients
+}
+
+func (s *ClientStore) DeleteClient(ctx context.Context, tenant, instanceID string) error {
+	if s == nil {
+		return ErrClientNotFound
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.cache[tenant][instanceID]; !ok {
+		return ErrClientNotFound
+	}
+	if err := os.Remove(s.path(tenant, instanceID)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	delete(s.cache[tenant], instanceID)
+	return nil
+}
+
+// keyInUse reports whether a client other than instanceID holds the key with thumbprint.
+// Callers hold s.mu.
+func (s *ClientStore) keyInUse(tenant, thumbprint, instanceID string) bool {
+	for _, rec := range s.cache[tenant] {
+		if rec.Thumbprint == thumbprint && rec.InstanceID != instanceID {
+			return true
+		}
+	}
+	return false
+}
+
+// put caches and saves rec. Callers hold s.mu.
+func (s *ClientStore) put(rec ClientRecord) error {
+	path := s.path(rec.Tenant, rec.InstanceID)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return err
+	}
+	if _, ok := s.cache[rec.Tenant]; !ok {
+		s.cache[rec.Tenant] = make(map[string]ClientRecord)
+	}
+	s.cache[rec.Tenant][rec.InstanceID] = rec
+	return nil
+}
+
+func (s *ClientStore) path(tenant, instanceID string) string {
+	return filepath.Join(s.dataDir, "clients", tenant, instanceID+".json")
+}
+
+func (s *ClientStore) loadFromDisk() error {
+	baseDir := filepath.Join(s.dataDir, "clients")
+	tenants, err := os.ReadDir(baseDir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, tenantEntry := range tenants {
+		if !tenantEntry.IsDir() {
+			continue
+		}
+		tenant := tenantEntry.Name()
+		files, err := os.ReadDir(filepath.Join(baseDir, tenant))
+		if err != nil {
+			continue
+		}
+		for _, file := range files {
+			if file.IsDir() || filepath.Ext(file.Name()) != ".json" {
+				continue
+			}
+			data, err := os.ReadFile(filepath.Join(baseDir, tenant, file.Name()))
+			if err != nil {
+				continue
+			}
+			var rec ClientRecord
+			if err := json.Unmarshal(data, &rec); err != nil {
+				continue
+			}
+			if _, ok := s.cache[tenant]; !ok {
+				s.cache[tenant] = make(map[string]ClientRecord)
+			}
+			s.cache[tenant][rec.InstanceID] = rec
+		}
+	}
+	return nil
+}
+
+// What clients that are not pre-registered may do.
+const (
+	UnknownClientsAllow = "allow"
+	UnknownClientsLimit = "limit" // only the policy's AllowedAccess types
+	UnknownClientsDeny  = "deny"
+)
+
+// UnknownClientPolicy limits grants from clients that are not in the registry. The
+// zero value allows them anything.
+type UnknownClientPolicy struct {
+	Mode          string
+	AllowedAccess []string
+}
+
+// CheckAccess reports whether an unregistered client may request access.
+func (p UnknownClientPolicy) CheckAccess(access types.AccessTokenRequest) error {
+	switch p.Mode {
+	case UnknownClientsDeny:
+		return NewError(CodeInvalidClient, "client is not registered")
+	case UnknownClientsLimit:
+		if !AccessTypesWithin(access, p.AllowedAccess) {
+			return NewError(CodeRequestDenied, "access not allowed for unregistered clients")
+		}
+	}
+	return nil
+}
+
+// CheckAccess reports whether the client may request access.
+func (c *ClientRecord) CheckAccess(access types.AccessTokenRequest) error {
+	if len(c.AllowedAccess) > 0 && !AccessTypesWithin(access, c.AllowedAccess) {
+		return NewError(CodeRequestDenied, "access not allowed for this client")
+	}
+	return nil
+}
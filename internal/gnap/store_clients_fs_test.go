@@ -0,0 +1,130 @@
+package gnap
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/TwigBush/gnap-go/internal/types"
+)
+
+func TestClientStore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store, err := NewClientStore(dir)
+	if err != nil {
+		t.Fatalf("NewClientStore: %v", err)
+	}
+
+	rec, err := store.RegisterClient(ctx, "acme", ClientRecord{
+		Key:        types.ClientKey{Proof: "httpsig", JWK: types.JWK{Kty: "OKP", Crv: "Ed25519", X: "abc"}},
+		Thumbprint: "thumb-1",
+		Display:    &types.ClientDisplay{Name: "Photo App"},
+	})
+	if err != nil || rec.InstanceID == "" {
+		t.Fatalf("RegisterClient() = %+v, %v", rec, err)
+	}
+
+	// The registry survives a restart and is per tenant
+	store, err = NewClientStore(dir)
+	if err != nil {
+		t.Fatalf("NewClientStore: %v", err)
+	}
+	if got, ok := store.GetClient(ctx, "acme", rec.InstanceID); !ok || got.Display.Name != "Photo App" {
+		t.Fatalf("GetClient() after reload = %+v, %v", got, ok)
+	}
+	if _, ok := store.GetClient(ctx, DefaultTenant, rec.InstanceID); ok {
+		t.Fatalf("GetClient() found the client in another tenant")
+	}
+	if got, ok := store.FindClientByThumbprint(ctx, "acme", "thumb-1"); !ok || got.InstanceID != rec.InstanceID {
+		t.Fatalf("FindClientByThumbprint() = %+v, %v", got, ok)
+	}
+
+	// A key belongs to one client per tenant
+	if _, err := store.RegisterClient(ctx, "acme", ClientRecord{Thumbprint: "thumb-1"}); !errors.Is(err, ErrClientKeyInUse) {
+		t.Fatalf("RegisterClient(same key) error = %v, want %v", err, ErrClientKeyInUse)
+	}
+	other, err := store.RegisterClient(ctx, "acme", ClientRecord{Thumbprint: "thumb-3"})
+	if err != nil {
+		t.Fatalf("RegisterClient: %v", err)
+	}
+	if _, err := store.UpdateClient(ctx, "acme", ClientRecord{InstanceID: other.InstanceID, Thumbprint: "thumb-1"}); !errors.Is(err, ErrClientKeyInUse) {
+		t.Fatalf("UpdateClient(taken key) error = %v, want %v", err, ErrClientKeyInUse)
+	}
+	if _, err := store.RegisterClient(ctx, DefaultTenant, ClientRecord{Thumbprint: "thumb-1"}); err != nil {
+		t.Fatalf("RegisterClient(other tenant): %v", err)
+	}
+	if err := store.DeleteClient(ctx, "acme", other.InstanceID); err != nil {
+		t.Fatalf("DeleteClient: %v", err)
+	}
+
+	updated, err := store.UpdateClient(ctx, "acme", ClientRecord{InstanceID: rec.InstanceID, Thumbprint: "thumb-2", AllowedAccess: []string{"photo-api"}})
+	if err != nil || !updated.CreatedAt.Equal(rec.CreatedAt) || updated.Thumbprint != "thumb-2" {
+		t.Fatalf("UpdateClient() = %+v, %v", updated, err)
+	}
+	if _, err := store.UpdateClient(ctx, "acme", ClientRecord{InstanceID: "missing"}); !errors.Is(err, ErrClientNotFound) {
+		t.Fatalf("UpdateClient(missing) error = %v, want %v", err, ErrClientNotFound)
+	}
+
+	if err := store.DeleteClient(ctx, "acme", rec.InstanceID); err != nil {
+		t.Fatalf("DeleteClient: %v", err)
+	}
+	if got := store.ListClients(ctx, "acme"); len(got) != 0 {
+		t.Fatalf("ListClients() after delete = %+v", got)
+	}
+	if err := store.DeleteClient(ctx, "acme", rec.InstanceID); !errors.Is(err, ErrClientNotFound) {
+		t.Fatalf("second DeleteClient error = %v, want %v", err, ErrClientNotFound)
+	}
+
+	var none *ClientStore
+	if _, err := none.RegisterClient(ctx, "acme", rec); !errors.Is(err, ErrNoClientStore) {
+		t.Fatalf("nil store RegisterClient error = %v, want %v", err, ErrNoClientStore)
+	}
+	if _, ok := none.GetClient(ctx, "acme", rec.InstanceID); ok {
+		t.Fatalf("nil store found a client")
+	}
+	if got := none.ListClients(ctx, "acme"); len(got) != 0 {
+		t.Fatalf("nil store listed %+v", got)
+	}
+	if _, err := none.UpdateClient(ctx, "acme", rec); !errors.Is(err, ErrClientNotFound) {
+		t.Fatalf("nil store UpdateClient error = %v, want %v", err, ErrClientNotFound)
+	}
+	if err := none.DeleteClient(ctx, "acme", rec.InstanceID); !errors.Is(err, ErrClientNotFound) {
+		t.Fatalf("nil store DeleteClient error = %v, want %v", err, ErrClientNotFound)
+	}
+}
+
+func TestClientAccessPolicy(t *testing.T) {
+	access := func(typs ...string) types.AccessTokenRequest {
+		var tok types.AccessToken
+		for _, typ := range typs {
+			tok.Access = append(tok.Access, types.AccessItem{Type: typ})
+		}
+		return types.AccessTokenRequest{tok}
+	}
+	registered := &ClientRecord{AllowedAccess: []string{"photo-api"}}
+
+	tests := []struct {
+		name    string
+		check   func(types.AccessTokenRequest) error
+		access  types.AccessTokenRequest
+		wantErr error
+	}{
+		{"registered, allowed", registered.CheckAccess, access("photo-api"), nil},
+		{"registered, not allowed", registered.CheckAccess, access("photo-api", "payments"), ErrRequestDenied},
+		{"registered, any access", (&ClientRecord{}).CheckAccess, access("payments"), nil},
+		{"unknown, allow", UnknownClientPolicy{}.CheckAccess, access("payments"), nil},
+		{"unknown, deny", UnknownClientPolicy{Mode: UnknownClientsDeny}.CheckAccess, access("photo-api"), ErrInvalidClient},
+		{"unknown, limit allowed", UnknownClientPolicy{Mode: UnknownClientsLimit, AllowedAccess: []string{"photo-api"}}.CheckAccess, access("photo-api"), nil},
+		{"unknown, limit denied", UnknownClientPolicy{Mode: UnknownClientsLimit, AllowedAccess: []string{"photo-api"}}.CheckAccess, access("payments"), ErrRequestDenied},
+		{"unknown, limit subject only", UnknownClientPolicy{Mode: UnknownClientsLimit}.CheckAccess, nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.check(tt.access); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CheckAccess() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
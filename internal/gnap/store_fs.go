@@ -3,6 +3,7 @@ package gnap
 import (
 	"context"
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
@@ -61,7 +62,7 @@ func (fileStore *FileStore) readGrant(id string) (*types.GrantState, error) {
 	bytes, err := os.ReadFile(path)
 	if err != nil {
 		if errors.Is(err, fs.ErrNotExist) {
-			return nil, errors.New("grant not found")
+			return nil, ErrGrantNotFound
 		}
 		return nil, err
 	}
@@ -96,38 +97,92 @@ func randHex(n int) string {
 	return hex.EncodeToString(b)
 }
 
-// ---------- interface implementation ----------
+var (
+	ErrGrantNotFound   = NewError(CodeInvalidContinuation, "grant not found")
+	ErrGrantNotPending = NewError(CodeInvalidInteraction, "grant not pending")
 
-func (fileStore *FileStore) CreateGrant(ctx context.Context, req types.GrantRequest) (*types.GrantState, error) {
-	now := time.Now().UTC()
-	expiration := now.Add(time.Duration(fileStore.cfg.GrantTTLSeconds) * time.Second)
+	ErrInvalidContinuationToken = NewError(CodeInvalidContinuation, "invalid continuation token")
+	ErrStaleClientKey           = NewError(CodeInvalidRotation, "client key is not the grant's current key")
 
-	continueToken := randHex(16) // 32 hex chars
+	// ErrInvalidTransition is wrapped in invalid_continuation errors for moves the
+	// grant state machine does not allow.
+	ErrInvalidTransition = Err("grant state does not allow this")
+)
+
+// transition moves grant to status to, enforcing the grant state machine.
+func transition(grant *types.GrantState, to types.GrantStatus) error {
+	if !grant.Status.CanTransition(to) {
+		return WrapError(CodeInvalidContinuation, fmt.Errorf("%w: %s grant cannot become %s", ErrInvalidTransition, grant.Status, to))
+	}
+	grant.Status = to
+	grant.UpdatedAt = time.Now().UTC()
+	return nil
+}
 
-	// Collect locations as JSON, same as memory store
+// accessLocations collects the RS locations named by the requested access.
+func accessLocations(access types.AccessTokenRequest) []string {
 	var locations []string
-	for _, accessToken := range req.AccessToken {
+	for _, accessToken := range access {
 		for _, accessItem := range accessToken.Access {
 			if len(accessItem.Locations) > 0 {
 				locations = append(locations, accessItem.Locations...)
 			}
 		}
 	}
+	return locations
+}
 
-	uc := RandUserCode()
+// startInteraction mints only what the requested start modes need: a user code for the
+// device flow, an unguessable interaction URI for redirect and app, and an AS nonce for finish.
+func startInteraction(g *types.GrantState, in *types.Interact) {
+	g.Interact = in
+	g.UserCode = nil
+	g.InteractID, g.InteractNonce, g.InteractRef = "", "", ""
+	g.CodeVerified = false
+
+	if in.HasStart(types.InteractStartUserCode) || in.HasStart(types.InteractStartUserCodeURI) {
+		uc := RandUserCode()
+		g.UserCode = &uc
+	}
+	if in.HasStart(types.InteractStartRedirect) || in.HasStart(types.InteractStartApp) {
+		g.InteractID = randHex(16)
+	}
+	if in.FinishMethod() != "" {
+		g.InteractNonce = randHex(16)
+	}
+}
+
+// ---------- interface implementation ----------
+
+func (fileStore *FileStore) CreateGrant(ctx context.Context, tenant string, req types.GrantRequest, user *types.UserHint) (*types.GrantState, error) {
+	now := time.Now().UTC()
+	expiration := now.Add(time.Duration(fileStore.cfg.GrantTTLSeconds) * time.Second)
+
+	continueToken := randHex(16) // 32 hex chars
 
 	grantState := &types.GrantState{
 		ID:                uuid.NewString(),
-		Status:            types.GrantStatusPending,
+		Status:            types.GrantStatusProcessing,
+		Tenant:            tenant,
 		Client:            req.Client,
 		RequestedAccess:   req.AccessToken,
+		AccessRefs:        req.AccessRefs,
+		MultipleTokens:    req.MultipleTokens,
+		SubjectRequest:    req.Subject,
+		User:              user,
 		ContinuationToken: continueToken,
 		TokenFormat:       req.TokenFormat,
 		CreatedAt:         now,
 		UpdatedAt:         now,
+		PolledAt:          now,
 		ExpiresAt:         expiration,
-		Locations:         locations,
-		UserCode:          &uc,
+		Locations:         accessLocations(req.AccessToken),
+	}
+	startInteraction(grantState, req.Interact)
+
+	// Nothing is granted without the user, so the request waits on interaction
+	if err := transition(grantState, types.GrantStatusPending); err != nil {
+		return nil, err
 	}
 
 	fileStore.mu.Lock()
@@ -138,6 +193,47 @@ func (fileStore *FileStore) CreateGrant(ctx context.Context, req types.GrantRequ
 	return grantState, nil
 }
 
+func (fileStore *FileStore) ModifyGrant(ctx context.Context, id string, access types.AccessTokenRequest, refs map[string][]types.AccessItem, multiple bool, interact *types.Interact, keepApproval bool) (*types.GrantState, error) {
+	fileStore.mu.Lock()
+	defer fileStore.mu.Unlock()
+
+	grant, err := fileStore.readGrant(id)
+	if err != nil {
+		return nil, err
+	}
+	if keepApproval && grant.Status != types.GrantStatusApproved && grant.Status != types.GrantStatusFinalized {
+		return nil, WrapError(CodeInvalidContinuation, fmt.Errorf("%w: %s grant has no approval to keep", ErrInvalidTransition, grant.Status))
+	}
+
+	now := time.Now().UTC()
+	if keepApproval {
+		if err := transition(grant, types.GrantStatusApproved); err != nil {
+			return nil, err
+		}
+		grant.ApprovedAccess = access
+	} else {
+		// Back through interaction for the new request
+		if err := transition(grant, types.GrantStatusPending); err != nil {
+			return nil, err
+		}
+		grant.ApprovedAccess = nil
+		grant.Subject = nil
+		grant.SubjectShared = false
+		startInteraction(grant, interact)
+	}
+	grant.ExpiresAt = now.Add(time.Duration(fileStore.cfg.GrantTTLSeconds) * time.Second)
+	grant.RequestedAccess = access
+	grant.AccessRefs = refs
+	grant.MultipleTokens = multiple
+	grant.Locations = accessLocations(access)
+	grant.PolledAt = now
+
+	if err := fileStore.writeGrant(grant); err != nil {
+		return nil, err
+	}
+	return grant, nil
+}
+
 func (fileStore *FileStore) MarkCodeVerified(ctx context.Context, id string) error {
 	fileStore.mu.Lock()
 	defer fileStore.mu.Unlock()
@@ -147,7 +243,7 @@ func (fileStore *FileStore) MarkCodeVerified(ctx context.Context, id string) err
 		return err
 	}
 	if grant.Status != types.GrantStatusPending {
-		return fmt.Errorf("grant not pending")
+		return ErrGrantNotPending
 	}
 	grant.CodeVerified = true
 	grant.UpdatedAt = time.Now().UTC()
@@ -163,10 +259,8 @@ func (fileStore *FileStore) GetGrant(ctx context.Context, id string) (*types.Gra
 		return nil, false
 	}
 
-	now := time.Now().UTC()
-	if now.After(grant.ExpiresAt) && grant.Status != types.GrantStatusExpired {
-		grant.Status = types.GrantStatusExpired
-		grant.UpdatedAt = now
+	// Grants expire until they are finalized; issued tokens carry their own expiry
+	if time.Now().UTC().After(grant.ExpiresAt) && transition(grant, types.GrantStatusExpired) == nil {
 		_ = fileStore.writeGrant(grant)
 	}
 	return grant, true
@@ -206,7 +300,145 @@ func (fileStore *FileStore) FindGrantByUserCodePending(ctx context.Context, code
 	return nil, false
 }
 
-func (fileStore *FileStore) ApproveGrant(ctx context.Context, id string, approved types.AccessTokenRequest, subject string) (*types.GrantState, error) {
+func (fileStore *FileStore) FindGrantByInteractID(ctx context.Context, interactID string) (*types.GrantState, bool) {
+	if interactID == "" {
+		return nil, false
+	}
+
+	fileStore.mu.RLock()
+	files, err := fileStore.listGrantFiles()
+	fileStore.mu.RUnlock()
+	if err != nil {
+		return nil, false
+	}
+
+	for _, p := range files {
+		fileStore.mu.RLock()
+		b, err := os.ReadFile(p)
+		fileStore.mu.RUnlock()
+		if err != nil {
+			continue
+		}
+		var grantState types.GrantState
+		if err := json.Unmarshal(b, &grantState); err != nil {
+			continue
+		}
+		if grantState.InteractID == interactID {
+			return &grantState, true
+		}
+	}
+	return nil, false
+}
+
+func (fileStore *FileStore) FinishInteraction(ctx context.Context, id string) (*types.GrantState, error) {
+	fileStore.mu.Lock()
+	defer fileStore.mu.Unlock()
+
+	grant, err := fileStore.readGrant(id)
+	if err != nil {
+		return nil, err
+	}
+	if grant.Interact.FinishMethod() == "" {
+		return nil, NewError(CodeInvalidInteraction, "grant has no interaction finish method")
+	}
+	// The reference is issued once; repeated finishes hand back the same one
+	if grant.InteractRef == "" {
+		grant.InteractRef = randHex(16)
+		grant.UpdatedAt = time.Now().UTC()
+		if err := fileStore.writeGrant(grant); err != nil {
+			return nil, err
+		}
+	}
+	return grant, nil
+}
+
+func (fileStore *FileStore) RecordPushDelivery(ctx context.Context, id string, d types.PushDelivery) error {
+	fileStore.mu.Lock()
+	defer fileStore.mu.Unlock()
+
+	grant, err := fileStore.readGrant(id)
+	if err != nil {
+		return err
+	}
+	grant.PushDeliveries = append(grant.PushDeliveries, d)
+	grant.UpdatedAt = time.Now().UTC()
+	return fileStore.writeGrant(grant)
+}
+
+func (fileStore *FileStore) RotateContinuationToken(ctx context.Context, id, current string) (*types.GrantState, error) {
+	fileStore.mu.Lock()
+	defer fileStore.mu.Unlock()
+
+	grant, err := fileStore.readGrant(id)
+	if err != nil {
+		return nil, err
+	}
+	// Of two requests presenting the same token, only the first gets a new one
+	if subtle.ConstantTimeCompare([]byte(current), []byte(grant.ContinuationToken)) != 1 {
+		return nil, ErrInvalidContinuationToken
+	}
+	grant.ContinuationToken = randHex(16)
+	if err := fileStore.writeGrant(grant); err != nil {
+		return nil, err
+	}
+	return grant, nil
+}
+
+func (fileStore *FileStore) RotateClientKey(ctx context.Context, id string, from, to types.ClientKey) (*types.GrantState, error) {
+	fileStore.mu.Lock()
+	defer fileStore.mu.Unlock()
+
+	grant, err := fileStore.readGrant(id)
+	if err != nil {
+		return nil, ErrGrantNotFound
+	}
+	// Of two rotations away from the same key, only the first applies
+	if grant.Client.Key != from {
+		return nil, ErrStaleClientKey
+	}
+	grant.Client.Key = to
+	grant.UpdatedAt = time.Now().UTC()
+	if err := fileStore.writeGrant(grant); err != nil {
+		return nil, err
+	}
+	return grant, nil
+}
+
+// maxPollWait caps how far RecordPoll raises the wait for clients that poll too early.
+const maxPollWait = 60
+
+func (fileStore *FileStore) RecordPoll(ctx context.Context, id string, wait int) (*types.GrantState, error) {
+	fileStore.mu.Lock()
+	defer fileStore.mu.Unlock()
+
+	grant, err := fileStore.readGrant(id)
+	if err != nil {
+		return nil, err
+	}
+	if grant.PollWait < wait {
+		grant.PollWait = wait
+	}
+
+	// An early poll restarts the wait, so a client in a busy loop stays rejected. One
+	// that honored the wait has backed off, and is back to the usual wait.
+	now := time.Now().UTC()
+	early := now.Before(grant.PolledAt.Add(time.Duration(grant.PollWait) * time.Second))
+	if early {
+		grant.PollWait = min(grant.PollWait+wait, maxPollWait)
+	} else {
+		grant.PollWait = wait
+	}
+	grant.PolledAt = now
+	if err := fileStore.writeGrant(grant); err != nil {
+		return nil, err
+	}
+	if early {
+		return grant, NewError(CodeTooFast, fmt.Sprintf("poll no more than every %d seconds", grant.PollWait))
+	}
+	return grant, nil
+}
+
+func (fileStore *FileStore) ApproveGrant(ctx context.Context, id string, approved types.AccessTokenRequest, subject string, shareSubject bool) (*types.GrantState, error) {
 	fileStore.mu.Lock()
 	defer fileStore.mu.Unlock()
 
@@ -215,19 +447,21 @@ func (fileStore *FileStore) ApproveGrant(ctx context.Context, id string, approve
 		return nil, err
 	}
 	if grant.Status != types.GrantStatusPending {
-		return nil, fmt.Errorf("grant not pending")
+		return nil, ErrGrantNotPending
 	}
 	if !grant.CodeVerified {
-		return nil, fmt.Errorf("code not verified")
+		return nil, NewError(CodeInvalidInteraction, "code not verified")
 	}
 	if len(approved) == 0 {
 		approved = grant.RequestedAccess
 	}
 
-	grant.Status = types.GrantStatusApproved
+	if err := transition(grant, types.GrantStatusApproved); err != nil {
+		return nil, err
+	}
 	grant.ApprovedAccess = approved
 	grant.Subject = &subject
-	grant.UpdatedAt = time.Now().UTC()
+	grant.SubjectShared = shareSubject && grant.SubjectRequest != nil
 
 	if err := fileStore.writeGrant(grant); err != nil {
 		return nil, err
@@ -241,19 +475,70 @@ func (fileStore *FileStore) DenyGrant(ctx context.Context, id string) (*types.Gr
 
 	grant, err := fileStore.readGrant(id)
 	if err != nil {
-		return nil, errors.New("grant not found")
+		return nil, ErrGrantNotFound
 	}
 
 	now := time.Now().UTC()
-	if now.After(grant.ExpiresAt) {
-		grant.Status = types.GrantStatusExpired
-		grant.UpdatedAt = now
+	if now.After(grant.ExpiresAt) && transition(grant, types.GrantStatusExpired) == nil {
 		_ = fileStore.writeGrant(grant)
-		return nil, errors.New("grant expired")
+		return nil, NewError(CodeInvalidInteraction, "grant expired")
+	}
+
+	if err := transition(grant, types.GrantStatusDenied); err != nil {
+		return nil, err
+	}
+	if err := fileStore.writeGrant(grant); err != nil {
+		return nil, err
+	}
+	return grant, nil
+}
+
+func (fileStore *FileStore) FinalizeGrant(ctx context.Context, id string, issue func(*types.GrantState) error) (*types.GrantState, error) {
+	fileStore.mu.Lock()
+	defer fileStore.mu.Unlock()
+
+	grant, err := fileStore.readGrant(id)
+	if err != nil {
+		return nil, err
+	}
+	// Only an approved grant can be finalized, so a second caller finds it finalized
+	if grant.Status != types.GrantStatusApproved {
+		return nil, WrapError(CodeInvalidContinuation, fmt.Errorf("%w: %s grant cannot become %s", ErrInvalidTransition, grant.Status, types.GrantStatusFinalized))
+	}
+	// The finalized state is stored before any token exists, so a failed write cannot
+	// leave tokens behind a grant that would issue them again
+	if err := transition(grant, types.GrantStatusFinalized); err != nil {
+		return nil, err
+	}
+	if err := fileStore.writeGrant(grant); err != nil {
+		return nil, err
+	}
+	if err := issue(grant); err != nil {
+		if terr := transition(grant, types.GrantStatusApproved); terr == nil {
+			if werr := fileStore.writeGrant(grant); werr != nil {
+				return nil, errors.Join(err, werr)
+			}
+		}
+		return nil, err
 	}
+	return grant, nil
+}
+
+func (fileStore *FileStore) RevokeGrant(ctx context.Context, id string) (*types.GrantState, error) {
+	fileStore.mu.Lock()
+	defer fileStore.mu.Unlock()
 
-	grant.Status = types.GrantStatusDenied
-	grant.UpdatedAt = now
+	grant, err := fileStore.readGrant(id)
+	if err != nil {
+		return nil, ErrGrantNotFound
+	}
+	if grant.Status.Terminal() {
+		return grant, nil
+	}
+
+	if err := transition(grant, types.GrantStatusRevoked); err != nil {
+		return nil, err
+	}
 	if err := fileStore.writeGrant(grant); err != nil {
 		return nil, err
 	}
@@ -0,0 +1,162 @@
+package gnap
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/TwigBush/gnap-go/internal/types"
+)
+
+func TestFileStore_FinalizeGrant(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewFileStore(t.TempDir(), types.Config{GrantTTLSeconds: 60})
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	access := types.AccessTokenRequest{{Access: []types.AccessItem{{Type: "photo-api"}}}}
+	g, err := store.CreateGrant(ctx, DefaultTenant, types.GrantRequest{AccessToken: access}, nil)
+	if err != nil {
+		t.Fatalf("CreateGrant: %v", err)
+	}
+	if g.Status != types.GrantStatusPending {
+		t.Fatalf("new grant status = %s, want pending", g.Status)
+	}
+
+	issued := 0
+	issue := func(*types.GrantState) error { issued++; return nil }
+
+	if _, err := store.FinalizeGrant(ctx, g.ID, issue); !errors.Is(err, ErrInvalidTransition) {
+		t.Fatalf("finalizing a pending grant error = %v, want %v", err, ErrInvalidTransition)
+	}
+
+	_ = store.MarkCodeVerified(ctx, g.ID)
+	if _, err := store.ApproveGrant(ctx, g.ID, nil, "alice", false); err != nil {
+		t.Fatalf("ApproveGrant: %v", err)
+	}
+	if _, err := store.FinalizeGrant(ctx, g.ID, func(*types.GrantState) error { return Err("boom") }); err == nil {
+		t.Fatalf("FinalizeGrant with failing issue: want error")
+	}
+	if got, _ := store.GetGrant(ctx, g.ID); got.Status != types.GrantStatusApproved {
+		t.Fatalf("status after failed issue = %s, want approved", got.Status)
+	}
+
+	// Nothing is issued unless the finalized grant is stored first
+	blocker := store.grantPath(g.ID) + ".tempFilePath"
+	if err := os.Mkdir(blocker, 0o700); err != nil {
+		t.Fatalf("Mkdir: %v", err)
+	}
+	if _, err := store.FinalizeGrant(ctx, g.ID, issue); err == nil || issued != 0 {
+		t.Fatalf("FinalizeGrant with failing write = %v, issued %d, want error and nothing issued", err, issued)
+	}
+	if err := os.Remove(blocker); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+
+	g, err = store.FinalizeGrant(ctx, g.ID, issue)
+	if err != nil || g.Status != types.GrantStatusFinalized {
+		t.Fatalf("FinalizeGrant() = %v, %v, want finalized", g, err)
+	}
+	if _, err := store.FinalizeGrant(ctx, g.ID, issue); !errors.Is(err, ErrInvalidTransition) {
+		t.Fatalf("second FinalizeGrant error = %v, want %v", err, ErrInvalidTransition)
+	}
+	if issued != 1 {
+		t.Fatalf("issued %d times, want 1", issued)
+	}
+
+	if _, err := store.DenyGrant(ctx, g.ID); !errors.Is(err, ErrInvalidTransition) {
+		t.Fatalf("denying a finalized grant error = %v, want %v", err, ErrInvalidTransition)
+	}
+	expires := g.ExpiresAt
+	time.Sleep(10 * time.Millisecond)
+	if g, err = store.ModifyGrant(ctx, g.ID, access, nil, false, nil, true); err != nil || g.Status != types.GrantStatusApproved {
+		t.Fatalf("ModifyGrant(keepApproval) = %v, %v, want approved", g, err)
+	}
+	if !g.ExpiresAt.After(expires) {
+		t.Fatalf("ExpiresAt after modification = %v, want later than %v", g.ExpiresAt, expires)
+	}
+}
+
+func TestFileStore_RecordPoll(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewFileStore(t.TempDir(), types.Config{GrantTTLSeconds: 60})
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	g, err := store.CreateGrant(ctx, DefaultTenant, types.GrantRequest{}, nil)
+	if err != nil {
+		t.Fatalf("CreateGrant: %v", err)
+	}
+
+	// Polling right after the grant response is too early, and each early poll raises the wait
+	for _, wantWait := range []int{10, 15} {
+		got, err := store.RecordPoll(ctx, g.ID, 5)
+		if !errors.Is(err, ErrTooFast) || got.PollWait != wantWait {
+			t.Fatalf("RecordPoll() = wait %d, %v, want %d, too_fast", got.PollWait, err, wantWait)
+		}
+	}
+
+	// A client that waited long enough gets through
+	g, _ = store.GetGrant(ctx, g.ID)
+	g.PolledAt = g.PolledAt.Add(-time.Minute)
+	if err := store.writeGrant(g); err != nil {
+		t.Fatalf("writeGrant: %v", err)
+	}
+	if got, err := store.RecordPoll(ctx, g.ID, 5); err != nil || got.PollWait != 5 {
+		t.Fatalf("RecordPoll() after waiting = %v, %v, want the wait back to 5", got, err)
+	}
+
+	if got, err := store.RecordPoll(ctx, g.ID, 5); !errors.Is(err, ErrTooFast) || got.PollWait != 10 {
+		t.Fatalf("RecordPoll() = wait %d, %v, want 10, too_fast", got.PollWait, err)
+	}
+}
+
+func TestFileStore_RotateContinuationToken(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewFileStore(t.TempDir(), types.Config{GrantTTLSeconds: 60})
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	g, err := store.CreateGrant(ctx, DefaultTenant, types.GrantRequest{}, nil)
+	if err != nil {
+		t.Fatalf("CreateGrant: %v", err)
+	}
+	old := g.ContinuationToken
+
+	rotated, err := store.RotateContinuationToken(ctx, g.ID, old)
+	if err != nil || rotated.ContinuationToken == "" || rotated.ContinuationToken == old {
+		t.Fatalf("RotateContinuationToken() = %v, %v, want a new token", rotated, err)
+	}
+	if _, err := store.RotateContinuationToken(ctx, g.ID, old); !errors.Is(err, ErrInvalidContinuationToken) {
+		t.Fatalf("rotating with the old token error = %v, want %v", err, ErrInvalidContinuationToken)
+	}
+	if got, _ := store.GetGrant(ctx, g.ID); got.ContinuationToken != rotated.ContinuationToken {
+		t.Fatalf("stored token = %q, want %q", got.ContinuationToken, rotated.ContinuationToken)
+	}
+}
+
+func TestFileStore_RotateClientKey(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewFileStore(t.TempDir(), types.Config{GrantTTLSeconds: 60})
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	oldKey := types.ClientKey{Proof: "httpsig", JWK: types.JWK{Kty: "OKP", Crv: "Ed25519", X: "old"}}
+	newKey := types.ClientKey{Proof: "httpsig", JWK: types.JWK{Kty: "OKP", Crv: "Ed25519", X: "new"}}
+	g, err := store.CreateGrant(ctx, DefaultTenant, types.GrantRequest{Client: types.Client{Key: oldKey}}, nil)
+	if err != nil {
+		t.Fatalf("CreateGrant: %v", err)
+	}
+
+	if got, err := store.RotateClientKey(ctx, g.ID, oldKey, newKey); err != nil || got.Client.Key != newKey {
+		t.Fatalf("RotateClientKey() = %v, %v, want the new key", got, err)
+	}
+	if _, err := store.RotateClientKey(ctx, g.ID, oldKey, newKey); !errors.Is(err, ErrStaleClientKey) {
+		t.Fatalf("rotating from the old key again error = %v, want %v", err, ErrStaleClientKey)
+	}
+	if got, _ := store.GetGrant(ctx, g.ID); got.Client.Key != newKey {
+		t.Fatalf("stored key = %+v, want %+v", got.Client.Key, newKey)
+	}
+}
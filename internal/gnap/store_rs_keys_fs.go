@@ -313,3 +313,25 @@ func (s *RSKeyStore) LookupRSPublicKeyByThumbprint(tenant, thumb256 string) (cry
 		return nil, fmt.Errorf("unsupported key type %T", k)
 	}
 }
+
+// LookupRSKeyByPublicKey finds the active RS key whose RFC 7638 thumbprint matches pub,
+// searching all tenants. This identifies an RS by the key in its mTLS client certificate.
+func (s *RSKeyStore) LookupRSKeyByPublicKey(pub crypto.PublicKey) (RSKeyRecord, error) {
+	k, err := jwk.Import(pub)
+	if err != nil {
+		return RSKeyRecord{}, fmt.Errorf("import public key: %w", err)
+	}
+	thumb, err := computeThumb256(k)
+	if err != nil {
+		return RSKeyRecord{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, keys := range s.cache {
+		if rec, ok := keys[thumb]; ok && rec.Active {
+			return rec, nil
+		}
+	}
+	return RSKeyRecord{}, fmt.Errorf("no active RS key for thumbprint %s", thumb)
+}
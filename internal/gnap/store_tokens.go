@@ -6,6 +6,7 @@ import (
 
 	"os"
 	"path/filepath"
+	"slices"
 	"sync"
 
 	"github.com/TwigBush/gnap-go/internal/types"
@@ -19,8 +20,11 @@ type TokenRecord struct {
 	Iss        string
 	Access     []types.AccessItem
 	Aud        []string
-	Sub        string
+	Sub        string // the account, stored raw; introspection returns a pairwise id
+	Tenant     string
 	InstanceID string
+	Label      string
+	Flags      []string // the token's flags, e.g. bearer or durable
 	Exp        int64
 	Iat        int64
 	Nbf        int64
@@ -29,13 +33,19 @@ type TokenRecord struct {
 	// Binding
 	BoundProof string    // e.g., "httpsig", "dpop", "mtls"
 	BoundKey   *BoundKey // if bound, one of JWK or Ref populated
+
+	// Management (RFC 9635 §6): the token's manage URI path and the hash of the
+	// token management access token. Both carry over when the token is rotated.
+	ManageID        string
+	ManageTokenHash string
 }
 
 // AS → RS response when active
 type BoundKey struct {
-	Proof string          `json:"proof"`
-	JWK   json.RawMessage `json:"jwk,omitempty"` // by value
-	Ref   string          `json:"ref,omitempty"` // or by reference
+	Proof    string          `json:"proof"`
+	JWK      json.RawMessage `json:"jwk,omitempty"`       // by value
+	CertS256 string          `json:"cert#S256,omitempty"` // or by certificate thumbprint (mtls)
+	Ref      string          `json:"ref,omitempty"`       // or by reference
 }
 
 type TokenStoreContainer struct {
@@ -133,12 +143,101 @@ func (s *TokenStoreContainer) Revoke(ctx context.Context, hashB64 string) error
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	// Get existing record
-	record, err := s.GetByHash(ctx, hashB64)
-	if err != nil || record == nil {
-		return err
+	// Get existing record (cache is loaded from disk at startup and kept current by Put)
+	record, ok := s.cache[hashB64]
+	if !ok {
+		return nil
+	}
+	return s.revokeLocked(hashB64, record)
+}
+
+// GetByManageID returns the live (unrevoked) token behind a token management URI.
+func (s *TokenStoreContainer) GetByManageID(ctx context.Context, manageID string) (*TokenRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if manageID == "" {
+		return nil, nil
+	}
+	for _, record := range s.cache {
+		if record.ManageID == manageID && !record.Revoked {
+			return &record, nil
+		}
+	}
+	return nil, nil
+}
+
+// RevokeByInstanceID revokes every token issued for the grant instanceID and reports how
+// many were newly revoked.
+func (s *TokenStoreContainer) RevokeByInstanceID(ctx context.Context, instanceID string) (int, error) {
+	return s.revokeInstance(instanceID, false)
+}
+
+// RevokeNonDurableByInstanceID revokes the tokens issued for the grant instanceID that
+// were not issued durable, as when the grant's access changed, and reports how many were
+// newly revoked.
+func (s *TokenStoreContainer) RevokeNonDurableByInstanceID(ctx context.Context, instanceID string) (int, error) {
+	return s.revokeInstance(instanceID, true)
+}
+
+func (s *TokenStoreContainer) revokeInstance(instanceID string, keepDurable bool) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for hashB64, record := range s.cache {
+		if record.InstanceID != instanceID || record.Revoked {
+			continue
+		}
+		if keepDurable && slices.Contains(record.Flags, types.FlagDurable) {
+			continue
+		}
+		if err := s.revokeLocked(hashB64, record); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// RebindInstanceID moves the live bound tokens issued for the grant instanceID to key,
+// after the client rotated its key, and reports how many were rebound. Only keep, the
+// token whose management request rotated the key, and tokens the client asked to be
+// durable are rebound; the grant's other bound tokens are revoked with the old key.
+// Bearer tokens are left alone.
+func (s *TokenStoreContainer) RebindInstanceID(ctx context.Context, instanceID string, key BoundKey, keep string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for hashB64, record := range s.cache {
+		if record.InstanceID != instanceID || record.Revoked || record.BoundKey == nil {
+			continue
+		}
+		if hashB64 != keep && !slices.Contains(record.Flags, types.FlagDurable) {
+			if err := s.revokeLocked(hashB64, record); err != nil {
+				return n, err
+			}
+			continue
+		}
+		bound := key
+		record.BoundProof = bound.Proof
+		record.BoundKey = &bound
+		data, err := json.MarshalIndent(record, "", "  ")
+		if err != nil {
+			return n, err
+		}
+		if err := os.WriteFile(filepath.Join(s.dataDir, hashB64+".json"), data, 0600); err != nil {
+			return n, err
+		}
+		s.cache[hashB64] = record
+		n++
 	}
+	return n, nil
+}
 
+// revokeLocked marks record revoked on disk and in the cache. Callers hold s.mu.
+func (s *TokenStoreContainer) revokeLocked(hashB64 string, record TokenRecord) error {
 	// Mark as revoked
 	record.Revoked = true
 
@@ -154,7 +253,7 @@ func (s *TokenStoreContainer) Revoke(ctx context.Context, hashB64 string) error
 	}
 
 	// Update cache
-	s.cache[hashB64] = *record
+	s.cache[hashB64] = record
 
 	return nil
 }
@@ -0,0 +1,110 @@
+package gnap
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/TwigBush/gnap-go/internal/types"
+)
+
+func TestTokenStore_RevokeByInstanceID(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s, err := NewTokenStore(dir)
+	if err != nil {
+		t.Fatalf("NewTokenStore: %v", err)
+	}
+	for hash, instance := range map[string]string{"a": "g1", "b": "g1", "c": "g2"} {
+		if err := s.Put(ctx, hash, &TokenRecord{InstanceID: instance}); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+
+	n, err := s.RevokeByInstanceID(ctx, "g1")
+	if err != nil || n != 2 {
+		t.Fatalf("RevokeByInstanceID() = %d, %v; want 2, nil", n, err)
+	}
+	for hash, want := range map[string]bool{"a": true, "b": true, "c": false} {
+		rec, _ := s.GetByHash(ctx, hash)
+		if rec.Revoked != want {
+			t.Fatalf("token %s revoked = %v, want %v", hash, rec.Revoked, want)
+		}
+	}
+
+	// Revocations are persisted, not just cached
+	reloaded, err := NewTokenStore(dir)
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if rec, _ := reloaded.GetByHash(ctx, "a"); rec == nil || !rec.Revoked {
+		t.Fatalf("reloaded token a = %+v, want revoked", rec)
+	}
+
+	if err := s.Put(ctx, "d", &TokenRecord{InstanceID: "g2", Flags: []string{types.FlagDurable}}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := s.Put(ctx, "e", &TokenRecord{InstanceID: "g2"}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if n, err := s.RevokeNonDurableByInstanceID(ctx, "g2"); err != nil || n != 2 {
+		t.Fatalf("RevokeNonDurableByInstanceID() = %d, %v; want 2, nil", n, err)
+	}
+	for hash, want := range map[string]bool{"c": true, "d": false, "e": true} {
+		if rec, _ := s.GetByHash(ctx, hash); rec.Revoked != want {
+			t.Fatalf("token %s revoked = %v, want %v", hash, rec.Revoked, want)
+		}
+	}
+
+	if err := s.Revoke(ctx, "d"); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	if rec, _ := s.GetByHash(ctx, "d"); !rec.Revoked {
+		t.Fatalf("token d not revoked")
+	}
+}
+
+func TestTokenStore_RebindInstanceID(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewTokenStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewTokenStore: %v", err)
+	}
+	old := &BoundKey{Proof: "httpsig", JWK: []byte(`{"kty":"OKP","crv":"Ed25519","x":"old"}`)}
+	records := map[string]*TokenRecord{
+		"managed": {InstanceID: "g1", BoundProof: "httpsig", BoundKey: old},
+		"durable": {InstanceID: "g1", BoundProof: "httpsig", BoundKey: old, Flags: []string{types.FlagDurable}},
+		"bound":   {InstanceID: "g1", BoundProof: "httpsig", BoundKey: old},
+		"bearer":  {InstanceID: "g1"},
+		"revoked": {InstanceID: "g1", BoundProof: "httpsig", BoundKey: old, Revoked: true},
+		"other":   {InstanceID: "g2", BoundProof: "httpsig", BoundKey: old},
+	}
+	for hash, rec := range records {
+		if err := s.Put(ctx, hash, rec); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+
+	// The managed and durable tokens move to the new key; other bound tokens die with the old one
+	key := BoundKey{Proof: "httpsig", JWK: []byte(`{"kty":"OKP","crv":"Ed25519","x":"new"}`)}
+	if n, err := s.RebindInstanceID(ctx, "g1", key, "managed"); err != nil || n != 2 {
+		t.Fatalf("RebindInstanceID() = %d, %v; want 2, nil", n, err)
+	}
+	for hash, want := range map[string]string{"managed": "new", "durable": "new", "revoked": "old", "other": "old"} {
+		rec, _ := s.GetByHash(ctx, hash)
+		if !strings.Contains(string(rec.BoundKey.JWK), `"`+want+`"`) {
+			t.Fatalf("token %s bound to %s, want %s", hash, rec.BoundKey.JWK, want)
+		}
+	}
+	for _, hash := range []string{"managed", "durable"} {
+		if rec, _ := s.GetByHash(ctx, hash); rec.Revoked {
+			t.Fatalf("token %s revoked by the key rotation", hash)
+		}
+	}
+	if rec, _ := s.GetByHash(ctx, "bound"); !rec.Revoked || !strings.Contains(string(rec.BoundKey.JWK), `"old"`) {
+		t.Fatalf("token without the durable flag = %+v, want revoked under the old key", rec)
+	}
+	if rec, _ := s.GetByHash(ctx, "bearer"); rec.BoundKey != nil || rec.Revoked {
+		t.Fatalf("bearer token was bound or revoked: %+v", rec)
+	}
+}
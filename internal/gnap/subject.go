@@ -0,0 +1,246 @@
+package gnap
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TwigBush/gnap-go/internal/types"
+)
+
+var (
+	ErrUnsupportedSubIDFormat     = Err("unsupported sub_id format")
+	ErrUnsupportedAssertionFormat = Err("unsupported assertion format")
+	ErrEmptySubjectRequest        = Err("subject request names no sub_id or assertion formats")
+)
+
+// ValidateSubject checks a client's subject request against the sub_id and assertion
+// formats this AS supports.
+func ValidateSubject(req *types.SubjectRequest, formats, assertionFormats []string) error {
+	if req == nil {
+		return nil
+	}
+	if len(req.SubIDFormats) == 0 && len(req.AssertionFormats) == 0 {
+		return ErrEmptySubjectRequest
+	}
+	for _, f := range req.SubIDFormats {
+		if !contains(formats, f) {
+			return fmt.Errorf("%w: %q", ErrUnsupportedSubIDFormat, f)
+		}
+	}
+	for _, f := range req.AssertionFormats {
+		if !contains(assertionFormats, f) {
+			return fmt.Errorf("%w: %q", ErrUnsupportedAssertionFormat, f)
+		}
+	}
+	return nil
+}
+
+// SubjectIDs returns identifiers for account in each requested format the AS can fill
+// in. email is only available for accounts that are email addresses, iss_sub only when
+// the AS has an issuer URL, and pairwise only when the caller derived pairwiseID for
+// the client.
+func SubjectIDs(account string, formats []string, issuer, pairwiseID string) []types.SubID {
+	var ids []types.SubID
+	for _, f := range formats {
+		switch f {
+		case types.SubIDFormatOpaque, types.SubIDFormatPublic:
+			ids = append(ids, types.SubID{Format: f, ID: account})
+		case types.SubIDFormatEmail:
+			if addr, err := mail.ParseAddress(account); err == nil && addr.Address == account {
+				ids = append(ids, types.SubID{Format: f, Email: account})
+			}
+		case types.SubIDFormatIssSub:
+			if issuer != "" {
+				ids = append(ids, types.SubID{Format: f, Iss: issuer, Sub: account})
+			}
+		case types.SubIDFormatPairwise:
+			if pairwiseID != "" {
+				ids = append(ids, types.SubID{Format: f, ID: pairwiseID})
+			}
+		}
+	}
+	return ids
+}
+
+// SubIDAccount returns the account an identifier names, in the form the AS keeps
+// accounts in. Pairwise ids name no account by themselves.
+func SubIDAccount(id types.SubID) string {
+	switch id.Format {
+	case types.SubIDFormatOpaque, types.SubIDFormatPublic:
+		return id.ID
+	case types.SubIDFormatEmail:
+		return id.Email
+	case types.SubIDFormatIssSub:
+		return id.Sub
+	}
+	return ""
+}
+
+// MatchesSubIDs reports whether ids include one of the identifiers the client said it
+// expects. With no expectations any ids match.
+func MatchesSubIDs(ids, expected []types.SubID) bool {
+	if len(expected) == 0 {
+		return true
+	}
+	for _, e := range expected {
+		for _, id := range ids {
+			if id == e {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// PairwiseSubjects derives pairwise subject identifiers: an account gets a different,
+// stable id in each sector (a client or an RS), and without the tenant's secret the ids
+// cannot be linked to each other or to the account. Every id handed out is recorded so
+// an admin can map it back to the account.
+//
+// Secrets and records live under <dataDir>/pairwise/<tenant>/.
+type PairwiseSubjects struct {
+	mu      sync.Mutex
+	dataDir string
+	secrets map[string][]byte // tenant -> secret
+}
+
+// PairwiseRecord is the reverse mapping of one pairwise id.
+type PairwiseRecord struct {
+	ID        string    `json:"id"`
+	Tenant    string    `json:"tenant"`
+	Sector    string    `json:"sector"`
+	Account   string    `json:"account"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Pairwise sectors are prefixed with the kind of party, so a client and an RS never
+// share one.
+func RSSector(rsID string) string { return "rs:" + rsID }
+
+// ClientSector is a client's pairwise sector: its instance_id when it has one, so its
+// pairwise ids survive key rotation, and otherwise the thumbprint of its key.
+func ClientSector(instanceID, thumbprint string) string {
+	if instanceID != "" {
+		return "client:id:" + instanceID
+	}
+	return "client:" + thumbprint
+}
+
+var ErrPairwiseIDNotFound = Err("pairwise id not found")
+
+const pairwiseSecretFile = "secret"
+
+func NewPairwiseSubjects(dataDir string) (*PairwiseSubjects, error) {
+	if err := os.MkdirAll(filepath.Join(dataDir, "pairwise"), 0o700); err != nil {
+		return nil, fmt.Errorf("create pairwise dir: %w", err)
+	}
+	return &PairwiseSubjects{dataDir: dataDir, secrets: map[string][]byte{}}, nil
+}
+
+// ID returns the pairwise id of account in sector, recording it for Lookup.
+func (p *PairwiseSubjects) ID(tenant, account, sector string) (string, error) {
+	if !ValidTenant(tenant) {
+		return "", fmt.Errorf("invalid tenant %q", tenant)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	secret, err := p.secret(tenant)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(sector + "\n" + account))
+	id := base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+
+	path := p.recordPath(tenant, id)
+	if _, err := os.Stat(path); err == nil {
+		return id, nil
+	}
+	rec := PairwiseRecord{ID: id, Tenant: tenant, Sector: sector, Account: account, CreatedAt: time.Now().UTC()}
+	b, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		return "", fmt.Errorf("record pairwise id: %w", err)
+	}
+	return id, nil
+}
+
+// Lookup maps a pairwise id handed out in tenant back to its account and sector.
+func (p *PairwiseSubjects) Lookup(tenant, id string) (*PairwiseRecord, error) {
+	if !ValidTenant(tenant) {
+		return nil, fmt.Errorf("invalid tenant %q", tenant)
+	}
+	// ids are base64url, so anything else cannot name a record
+	if _, err := base64.RawURLEncoding.DecodeString(id); err != nil || id == "" {
+		return nil, ErrPairwiseIDNotFound
+	}
+
+	b, err := os.ReadFile(p.recordPath(tenant, id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrPairwiseIDNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rec PairwiseRecord
+	if err := json.Unmarshal(b, &rec); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+func (p *PairwiseSubjects) recordPath(tenant, id string) string {
+	return filepath.Join(p.dataDir, "pairwise", tenant, "ids", id+".json")
+}
+
+// secret loads the tenant's secret, creating it on first use. Callers hold p.mu.
+func (p *PairwiseSubjects) secret(tenant string) ([]byte, error) {
+	if s, ok := p.secrets[tenant]; ok {
+		return s, nil
+	}
+
+	dir := filepath.Join(p.dataDir, "pairwise", tenant)
+	path := filepath.Join(dir, pairwiseSecretFile)
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return nil, fmt.Errorf("create tenant dir: %w", err)
+		}
+		b = []byte(base64.RawURLEncoding.EncodeToString(secret))
+		if err := os.WriteFile(path, b, 0o600); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	secret, err := base64.RawURLEncoding.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil || len(secret) < 16 {
+		return nil, fmt.Errorf("invalid pairwise secret in %s", path)
+	}
+	p.secrets[tenant] = secret
+	return secret, nil
+}
@@ -0,0 +1,116 @@
+package gnap
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/TwigBush/gnap-go/internal/types"
+)
+
+func TestSubjectIDs(t *testing.T) {
+	pairwise, err := NewPairwiseSubjects(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewPairwiseSubjects: %v", err)
+	}
+	const iss = "https://as.example"
+	pairwiseID, err := pairwise.ID(DefaultTenant, "user:device", ClientSector("", "client-1"))
+	if err != nil {
+		t.Fatalf("ID: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		account string
+		formats []string
+		want    []types.SubID
+	}{
+		{"opaque", "user:device", []string{"opaque"}, []types.SubID{{Format: "opaque", ID: "user:device"}}},
+		{"email for email account", "alice@example.com", []string{"email"}, []types.SubID{{Format: "email", Email: "alice@example.com"}}},
+		{"no email for other accounts", "user:device", []string{"email"}, nil},
+		{"iss_sub", "user:device", []string{"iss_sub"}, []types.SubID{{Format: "iss_sub", Iss: iss, Sub: "user:device"}}},
+		{"pairwise", "user:device", []string{"pairwise"}, []types.SubID{{Format: "pairwise", ID: pairwiseID}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := SubjectIDs(tt.account, tt.formats, iss, pairwiseID)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("SubjectIDs() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPairwiseSubjects(t *testing.T) {
+	dir := t.TempDir()
+	pairwise, err := NewPairwiseSubjects(dir)
+	if err != nil {
+		t.Fatalf("NewPairwiseSubjects: %v", err)
+	}
+	id := func(tenant, sector string) string {
+		t.Helper()
+		v, err := pairwise.ID(tenant, "user:device", sector)
+		if err != nil {
+			t.Fatalf("ID(%s, %s): %v", tenant, sector, err)
+		}
+		return v
+	}
+
+	client := id("default", ClientSector("", "client-1"))
+	if client != id("default", ClientSector("", "client-1")) {
+		t.Fatalf("pairwise id is not stable")
+	}
+	for _, other := range []string{
+		id("default", ClientSector("", "client-2")),
+		id("default", RSSector("client-1")),
+		id("acme", ClientSector("", "client-1")),
+	} {
+		if other == client {
+			t.Fatalf("pairwise id %s shared across sectors or tenants", client)
+		}
+	}
+
+	// Ids survive a restart, and map back to the account only within their tenant
+	reloaded, err := NewPairwiseSubjects(dir)
+	if err != nil {
+		t.Fatalf("NewPairwiseSubjects: %v", err)
+	}
+	if v, _ := reloaded.ID("default", "user:device", ClientSector("", "client-1")); v != client {
+		t.Fatalf("id after reload = %s, want %s", v, client)
+	}
+	rec, err := reloaded.Lookup("default", client)
+	if err != nil || rec.Account != "user:device" || rec.Sector != ClientSector("", "client-1") {
+		t.Fatalf("Lookup() = %+v, %v", rec, err)
+	}
+	if _, err := reloaded.Lookup("acme", client); !errors.Is(err, ErrPairwiseIDNotFound) {
+		t.Fatalf("Lookup() in another tenant error = %v, want %v", err, ErrPairwiseIDNotFound)
+	}
+	if _, err := pairwise.ID("../x", "user:device", "s"); err == nil {
+		t.Fatalf("ID() with invalid tenant: want error")
+	}
+}
+
+func TestValidateSubject(t *testing.T) {
+	formats, assertionFormats := []string{"opaque", "email"}, []string{"jwt"}
+	tests := []struct {
+		name    string
+		req     *types.SubjectRequest
+		wantErr error
+	}{
+		{"no subject", nil, nil},
+		{"supported", &types.SubjectRequest{SubIDFormats: []string{"email"}}, nil},
+		{"unsupported", &types.SubjectRequest{SubIDFormats: []string{"phone_number"}}, ErrUnsupportedSubIDFormat},
+		{"assertion", &types.SubjectRequest{AssertionFormats: []string{"jwt"}}, nil},
+		{"unsupported assertion", &types.SubjectRequest{AssertionFormats: []string{"saml2"}}, ErrUnsupportedAssertionFormat},
+		{"empty", &types.SubjectRequest{}, ErrEmptySubjectRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateSubject(tt.req, formats, assertionFormats); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ValidateSubject() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package gnap
+
+import "regexp"
+
+// Requests name their tenant in the X-Tenant-ID header; without one they belong to
+// the default tenant.
+const (
+	TenantHeader  = "X-Tenant-ID"
+	DefaultTenant = "default"
+)
+
+var tenantPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// ValidTenant reports whether tenant is a usable tenant id. Tenant ids name
+// directories in the data dir, so they are kept to a safe character set.
+func ValidTenant(tenant string) bool {
+	return tenantPattern.MatchString(tenant)
+}
+
+// TenantOrDefault returns tenant, or DefaultTenant for records made before grants and
+// tokens carried one.
+func TenantOrDefault(tenant string) string {
+	if tenant == "" {
+		return DefaultTenant
+	}
+	return tenant
+}
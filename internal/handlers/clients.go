@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/TwigBush/gnap-go/internal/gnap"
+	"github.com/TwigBush/gnap-go/internal/httpx"
+	"github.com/TwigBush/gnap-go/internal/sign"
+	"github.com/TwigBush/gnap-go/internal/types"
+	"github.com/go-chi/chi/v5"
+)
+
+// ClientsHandler manages the registry of pre-registered clients.
+type ClientsHandler struct {
+	store *gnap.ClientStore
+}
+
+func NewClientsHandler(store *gnap.ClientStore) *ClientsHandler {
+	return &ClientsHandler{store: store}
+}
+
+// clientIn is the body of a register or update request.
+type clientIn struct {
+	Key           types.ClientKey      `json:"key"`
+	Display       *types.ClientDisplay `json:"display,omitempty"`
+	AllowedAccess []string             `json:"allowed_access,omitempty"`
+}
+
+// POST /admin/tenants/{tenant}/clients
+func (h *ClientsHandler) RegisterClient(w http.ResponseWriter, r *http.Request) {
+	tenant, rec, ok := h.readClient(w, r)
+	if !ok {
+		return
+	}
+	rec, err := h.store.RegisterClient(r.Context(), tenant, rec)
+	if errors.Is(err, gnap.ErrClientKeyInUse) {
+		httpx.WriteError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		httpx.WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	httpx.WriteJSON(w, http.StatusCreated, rec)
+}
+
+// GET /admin/tenants/{tenant}/clients
+func (h *ClientsHandler) ListClients(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := adminTenant(w, r)
+	if !ok {
+		return
+	}
+	httpx.WriteJSON(w, http.StatusOK, map[string]any{
+		"clients": h.store.ListClients(r.Context(), tenant),
+	})
+}
+
+// GET /admin/tenants/{tenant}/clients/{instanceId}
+func (h *ClientsHandler) GetClient(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := adminTenant(w, r)
+	if !ok {
+		return
+	}
+	rec, ok := h.store.GetClient(r.Context(), tenant, chi.URLParam(r, "instanceId"))
+	if !ok {
+		httpx.WriteError(w, http.StatusNotFound, "client not found")
+		return
+	}
+	httpx.WriteJSON(w, http.StatusOK, rec)
+}
+
+// PUT /admin/tenants/{tenant}/clients/{instanceId}
+func (h *ClientsHandler) UpdateClient(w http.ResponseWriter, r *http.Request) {
+	instanceID := chi.URLParam(r, "instanceId")
+	tenant, rec, ok := h.readClient(w, r)
+	if !ok {
+		return
+	}
+	rec.InstanceID = instanceID
+	rec, err := h.store.UpdateClient(r.Context(), tenant, rec)
+	if errors.Is(err, gnap.ErrClientNotFound) {
+		httpx.WriteError(w, http.StatusNotFound, "client not found")
+		return
+	}
+	if errors.Is(err, gnap.ErrClientKeyInUse) {
+		httpx.WriteError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		httpx.WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	httpx.WriteJSON(w, http.StatusOK, rec)
+}
+
+// DELETE /admin/tenants/{tenant}/clients/{instanceId}
+func (h *ClientsHandler) DeleteClient(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := adminTenant(w, r)
+	if !ok {
+		return
+	}
+	err := h.store.DeleteClient(r.Context(), tenant, chi.URLParam(r, "instanceId"))
+	if errors.Is(err, gnap.ErrClientNotFound) {
+		httpx.WriteError(w, http.StatusNotFound, "client not found")
+		return
+	}
+	if err != nil {
+		httpx.WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// readClient decodes and checks a client registration.
+func (h *ClientsHandler) readClient(w http.ResponseWriter, r *http.Request) (string, gnap.ClientRecord, bool) {
+	tenant, ok := adminTenant(w, r)
+	if !ok {
+		return "", gnap.ClientRecord{}, false
+	}
+
+	var in clientIn
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		httpx.WriteGNAPError(w, gnap.NewError(gnap.CodeInvalidRequest, "invalid JSON"))
+		return "", gnap.ClientRecord{}, false
+	}
+	if in.Key.Proof == "" || !in.Key.HasKeyMaterial() {
+		httpx.WriteGNAPError(w, gnap.NewError(gnap.CodeInvalidRequest, "key with proof and jwk or cert required"))
+		return "", gnap.ClientRecord{}, false
+	}
+	thumb, err := sign.KeyThumbprint(in.Key)
+	if err != nil {
+		httpx.WriteGNAPError(w, gnap.NewError(gnap.CodeInvalidRequest, "invalid key"))
+		return "", gnap.ClientRecord{}, false
+	}
+	if err := gnap.ValidateClientDisplay(in.Display); err != nil {
+		httpx.WriteGNAPError(w, err)
+		return "", gnap.ClientRecord{}, false
+	}
+
+	return tenant, gnap.ClientRecord{
+		Key:           in.Key,
+		Thumbprint:    thumb,
+		Display:       in.Display,
+		AllowedAccess: in.AllowedAccess,
+	}, true
+}
+
+// adminTenant is the tenant an admin route is for.
+func adminTenant(w http.ResponseWriter, r *http.Request) (string, bool) {
+	tenant := chi.URLParam(r, "tenant")
+	if tenant == "" {
+		tenant = gnap.DefaultTenant
+	}
+	if !gnap.ValidTenant(tenant) {
+		httpx.WriteGNAPError(w, gnap.NewError(gnap.CodeInvalidRequest, "invalid tenant"))
+		return "", false
+	}
+	return tenant, true
+}
@@ -2,107 +2,503 @@ package handlers
 
 import (
 	"context"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"net/http"
+	"slices"
+	"strconv"
+	"time"
 
 	"github.com/TwigBush/gnap-go/internal/gnap"
 	"github.com/TwigBush/gnap-go/internal/types"
 	"github.com/go-chi/chi/v5"
 
 	"github.com/TwigBush/gnap-go/internal/httpx"
+	"github.com/TwigBush/gnap-go/internal/jwks"
+	"github.com/TwigBush/gnap-go/internal/sign"
 	"github.com/TwigBush/gnap-go/internal/token"
 )
 
+// errGrantFinalized rejects continuing a grant whose tokens were already issued.
+var errGrantFinalized = gnap.NewError(gnap.CodeInvalidContinuation, "grant already finalized")
+
+// errRevokeFailed is returned when a grant or its tokens could not be revoked; the cause is only logged.
+var errRevokeFailed = gnap.NewError(gnap.CodeRequestDenied, "grant could not be revoked")
+
 type ContinueHandler struct {
-	Store       types.GrantStore
-	TokenStore  *gnap.TokenStoreContainer
-	WaitSeconds int // how long the client should wait before polling /continue
+	Store         types.GrantStore
+	TokenStore    *gnap.TokenStoreContainer
+	WaitSeconds   int      // how long the client should wait before polling /continue; earlier polls get too_fast
+	StartModes    []string // interaction start modes a modification may request
+	FinishMethods []string // interaction finish methods a modification may request
+	AppLaunchURI  string   // base of app start mode URIs; defaults to the web interaction page
+
+	// With LongPollSeconds set, a poll on a pending grant is held open until consent
+	// wakes it through Waiters or the time passes.
+	LongPollSeconds int
+	Waiters         *GrantWaiters
+
+	Pairwise *gnap.PairwiseSubjects // derives pairwise sub_ids per client; nil leaves them out
+	Keys     *jwks.KeySet           // signs identity assertions; nil leaves them out
+	Issuer   string                 // the AS's configured URL, iss of its assertions; empty leaves them out
+
+	// Modified access is limited like a new grant's: by the client's registration, or
+	// for clients not in Clients, by UnknownClients.
+	Clients        *gnap.ClientStore
+	UnknownClients gnap.UnknownClientPolicy
+
+	// AccessDefinitions expands access requested by reference in modifications.
+	AccessDefinitions *gnap.AccessDefinitions
 }
 
 func NewContinueHandler(store types.GrantStore, tokenStore *gnap.TokenStoreContainer) *ContinueHandler {
-	return &ContinueHandler{Store: store, TokenStore: tokenStore, WaitSeconds: 5}
+	return &ContinueHandler{
+		Store:         store,
+		TokenStore:    tokenStore,
+		WaitSeconds:   5,
+		StartModes:    defaultStartModes,
+		FinishMethods: defaultFinishMethods,
+	}
 }
 
 func (h *ContinueHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	// GNAP continuation MUST be a POST with Authorization: GNAP <token>
-	if r.Method != http.MethodPost {
-		w.Header().Set("Allow", http.MethodPost)
+	// GNAP continuation is a POST (continue), PATCH (modify) or DELETE (revoke)
+	// with Authorization: GNAP <token>
+	if r.Method != http.MethodPost && r.Method != http.MethodPatch && r.Method != http.MethodDelete {
+		w.Header().Set("Allow", "POST, PATCH, DELETE")
 		httpx.WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
 	// Responses should not be cached
 	w.Header().Set("Cache-Control", "no-store")
 
+	grant, creq, ok := h.authorize(w, r)
+	if !ok {
+		return
+	}
+	// The token this request presented; it is rotated by whichever response continues the grant
+	current := grant.ContinuationToken
+
+	switch r.Method {
+	case http.MethodPatch:
+		h.modify(w, r, grant, creq, current)
+		return
+	case http.MethodDelete:
+		h.revoke(w, r, grant)
+		return
+	}
+
+	// With an interaction finish method the client continues only with the interact_ref it was handed
+	if grant.Interact.FinishMethod() != "" && (grant.Status == types.GrantStatusPending || grant.Status == types.GrantStatusApproved) {
+		if creq.InteractRef == "" {
+			httpx.WriteGNAPError(w, gnap.NewError(gnap.CodeInvalidInteraction, "missing interact_ref"))
+			return
+		}
+		if grant.InteractRef == "" || subtle.ConstantTimeCompare([]byte(creq.InteractRef), []byte(grant.InteractRef)) != 1 {
+			httpx.WriteGNAPError(w, gnap.NewError(gnap.CodeInvalidInteraction, "invalid interact_ref"))
+			return
+		}
+	}
+
+	// Without a finish method the client polls a waiting grant, and must honor the
+	// wait it was given. A grant approved without interaction has nothing to wait for.
+	if grant.Interact.FinishMethod() == "" && isWaiting(grant.Status) {
+		polled, err := h.Store.RecordPoll(r.Context(), grant.ID, h.WaitSeconds)
+		if err != nil {
+			if polled != nil {
+				w.Header().Set("Retry-After", strconv.Itoa(polled.PollWait))
+			}
+			httpx.WriteGNAPError(w, err)
+			return
+		}
+		grant = polled
+		if h.LongPollSeconds > 0 && isWaiting(grant.Status) {
+			grant = h.awaitChange(r, grant)
+		}
+	}
+
+	switch grant.Status {
+	case types.GrantStatusProcessing, types.GrantStatusPending:
+		// Still pending: instruct client to poll again
+		cont, err := h.nextContinue(r, grant.ID, current, h.pollWait(grant))
+		if err != nil {
+			httpx.WriteGNAPError(w, err)
+			return
+		}
+		httpx.WriteJSON(w, http.StatusOK, map[string]any{"continue": cont})
+		return
+
+	case types.GrantStatusApproved:
+		h.writeTokens(w, r, grant, current)
+		return
+
+	case types.GrantStatusFinalized:
+		// Tokens are issued once; further access goes through modification
+		httpx.WriteGNAPError(w, errGrantFinalized)
+		return
+
+	case types.GrantStatusDenied:
+		httpx.WriteGNAPError(w, gnap.NewError(gnap.CodeUserDenied, "grant denied by user"))
+		return
+
+	case types.GrantStatusExpired:
+		httpx.WriteGNAPError(w, gnap.NewError(gnap.CodeInvalidContinuation, "grant expired"))
+		return
+
+	case types.GrantStatusRevoked:
+		httpx.WriteGNAPError(w, gnap.NewError(gnap.CodeInvalidContinuation, "grant revoked"))
+		return
+
+	default:
+		httpx.WriteGNAPError(w, gnap.NewError(gnap.CodeInvalidContinuation, "unknown grant status"))
+		return
+	}
+}
+
+// authorize checks the continuation token and the client's key proof, and decodes the
+// request body. On failure it writes the error response and returns ok=false.
+func (h *ContinueHandler) authorize(w http.ResponseWriter, r *http.Request) (*types.GrantState, types.ContinueRequest, bool) {
+	var creq types.ContinueRequest
 	grantID := chi.URLParam(r, "grantId")
 
 	authz := r.Header.Get("Authorization")
 	contToken, ok := httpx.ExtractGNAPToken(authz)
 	if !ok || contToken == "" {
-		httpx.WriteError(w, http.StatusUnauthorized, "missing continuation token")
-		return
+		httpx.WriteGNAPError(w, gnap.NewError(gnap.CodeInvalidContinuation, "missing continuation token"))
+		return nil, creq, false
 	}
 
 	grant, found := h.Store.GetGrant(r.Context(), grantID)
 	if !found || grant == nil {
-		httpx.WriteError(w, http.StatusNotFound, "grant not found")
-		return
+		httpx.WriteGNAPError(w, gnap.ErrGrantNotFound)
+		return nil, creq, false
 	}
 
-	// Simple token equality check (opaque token)
-	if contToken != grant.ContinuationToken {
-		httpx.WriteError(w, http.StatusUnauthorized, "invalid continuation token")
-		return
+	if subtle.ConstantTimeCompare([]byte(contToken), []byte(grant.ContinuationToken)) != 1 {
+		httpx.WriteGNAPError(w, gnap.ErrInvalidContinuationToken)
+		return nil, creq, false
 	}
 
-	switch grant.Status {
-	case types.GrantStatusPending:
-		// Still pending: instruct client to poll again
-		resp := map[string]any{
-			"continue": map[string]any{
-				"access_token": contToken,
-				"uri":          baseURL(r) + "/continue/" + grantID,
-				"wait":         h.WaitSeconds,
-			},
+	// The continuation token is bound to the client key: requests must be signed with
+	// the grant's key, and httpsig and jws proofs cover the token itself
+	// (the authorization component and the ath header)
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestBytes))
+	if err != nil {
+		httpx.WriteGNAPError(w, gnap.NewError(gnap.CodeInvalidRequest, "invalid body"))
+		return nil, creq, false
+	}
+	if err := sign.VerifyRequestProof(r, body, grant.Client.Key); err != nil {
+		log.Printf("continue: key proof rejected: %v", err)
+		httpx.WriteGNAPError(w, errInvalidKeyProof)
+		return nil, creq, false
+	}
+
+	payload, err := sign.RequestPayload(r, body)
+	if err != nil {
+		httpx.WriteGNAPError(w, gnap.NewError(gnap.CodeInvalidRequest, "invalid JWS"))
+		return nil, creq, false
+	}
+	if len(payload) > 0 {
+		if err := json.Unmarshal(payload, &creq); err != nil {
+			httpx.WriteGNAPError(w, gnap.NewError(gnap.CodeInvalidRequest, "invalid JSON"))
+			return nil, creq, false
 		}
-		httpx.WriteJSON(w, http.StatusOK, resp)
+	}
+	return grant, creq, true
+}
+
+// modify applies a grant modification (RFC 9635 §5.3). Access already covered by the
+// user's approval is issued right away; anything more sends the grant back through
+// interaction.
+func (h *ContinueHandler) modify(w http.ResponseWriter, r *http.Request, grant *types.GrantState, creq types.ContinueRequest, current string) {
+	if !grant.Status.CanTransition(types.GrantStatusPending) {
+		httpx.WriteGNAPError(w, gnap.NewError(gnap.CodeInvalidContinuation, "grant cannot be modified"))
+		return
+	}
+	if err := gnap.ValidateInteract(creq.Interact, h.StartModes, h.FinishMethods); err != nil {
+		httpx.WriteGNAPError(w, err)
 		return
+	}
 
-	case types.GrantStatusApproved:
-		// Issue the final access token. Bind to client key if your IssueToken supports it.
-		issuer := baseURL(r)
-		fmt.Println("grant ", grant)
-		tok, err := token.IssueToken(r.Context(), h.TokenStore, grant, token.IssueConfig{
-			Issuer:          issuer,
-			Audience:        grant.Locations,
-			TokenTTLSeconds: grantTokenTTL(r.Context(), h.Store),
-		})
+	access, refs, multiple := creq.AccessToken, grant.AccessRefs, grant.MultipleTokens
+	if len(access) == 0 {
+		access = grant.RequestedAccess
+	} else {
+		var err error
+		access, refs, err = h.AccessDefinitions.Expand(r.Context(), gnap.TenantOrDefault(grant.Tenant), access)
 		if err != nil {
-			httpx.WriteError(w, http.StatusBadRequest, err.Error())
+			httpx.WriteGNAPError(w, err)
+			return
+		}
+		multiple = creq.MultipleTokens
+		if err := gnap.ValidateLabels(access, multiple); err != nil {
+			httpx.WriteGNAPError(w, err)
+			return
+		}
+		if err := gnap.ValidateFlags(access); err != nil {
+			httpx.WriteGNAPError(w, err)
 			return
 		}
+	}
+	interact := creq.Interact
+	if interact == nil {
+		interact = grant.Interact
+	}
+	var registered *gnap.ClientRecord
+	if rec, ok := h.Clients.GetClient(r.Context(), gnap.TenantOrDefault(grant.Tenant), grant.Client.InstanceID); ok {
+		registered = &rec
+	}
+	if err := checkClientAccess(registered, h.UnknownClients, access); err != nil {
+		httpx.WriteGNAPError(w, err)
+		return
+	}
 
-		resp := map[string]any{
-			"access_token": tok,
-			"instance_id":  grant.ID,
+	approved := grant.Status == types.GrantStatusApproved || grant.Status == types.GrantStatusFinalized
+	keepApproval := approved && gnap.AccessCovered(access, grant.ApprovedAccess)
+
+	updated, err := h.Store.ModifyGrant(r.Context(), grant.ID, access, refs, multiple, interact, keepApproval)
+	if err != nil {
+		httpx.WriteGNAPError(w, err)
+		return
+	}
+	if grant.Status == types.GrantStatusFinalized {
+		// Tokens issued before may carry more access than the modified grant. With the
+		// approval kept, new tokens replace all but the durable ones; without it, the
+		// user has yet to agree again and every earlier token goes.
+		revoke := h.TokenStore.RevokeByInstanceID
+		if keepApproval {
+			revoke = h.TokenStore.RevokeNonDurableByInstanceID
 		}
-		if grant.Subject != nil {
-			resp["subject"] = map[string]any{"sub_ids": []string{*grant.Subject}}
+		n, err := revoke(r.Context(), grant.ID)
+		if err != nil {
+			log.Printf("continue: revoke earlier tokens of grant %s: %v", grant.ID, err)
+			httpx.WriteGNAPError(w, errRevokeFailed)
+			return
 		}
-		httpx.WriteJSON(w, http.StatusOK, resp)
+		log.Printf("continue: grant %s modified, revoked %d earlier access token(s)", grant.ID, n)
+	}
+	if keepApproval {
+		h.writeTokens(w, r, updated, current)
 		return
+	}
 
-	case types.GrantStatusDenied:
-		httpx.WriteError(w, http.StatusForbidden, "grant denied by user")
+	cont, err := h.nextContinue(r, updated.ID, current, h.pollWait(updated))
+	if err != nil {
+		httpx.WriteGNAPError(w, err)
 		return
+	}
+	httpx.WriteJSON(w, http.StatusOK, types.GrantResponse{
+		Continue: *cont,
+		Interact: interactOut(updated, httpx.BaseURL(r), h.AppLaunchURI),
+	})
+}
 
-	case types.GrantStatusExpired:
-		httpx.WriteError(w, http.StatusBadRequest, "grant expired")
+// nextContinue rotates the continuation token the request presented and returns the
+// continue field for the response (RFC 9635 §5). The presented token stops working.
+func (h *ContinueHandler) nextContinue(r *http.Request, grantID, current string, wait int) (*types.Continue, error) {
+	grant, err := h.Store.RotateContinuationToken(r.Context(), grantID, current)
+	if err != nil {
+		return nil, err
+	}
+	return &types.Continue{
+		AccessToken: grant.ContinuationToken,
+		URI:         httpx.BaseURL(r) + "/continue/" + grant.ID,
+		Wait:        wait,
+	}, nil
+}
+
+// awaitChange holds a poll on a waiting grant until it changes state, LongPollSeconds
+// pass or the client goes away, and returns the grant as it then stands.
+func (h *ContinueHandler) awaitChange(r *http.Request, grant *types.GrantState) *types.GrantState {
+	wake, stop := h.Waiters.Wait(grant.ID)
+	defer stop()
+
+	// Re-read after subscribing so a consent that just happened is not missed
+	if g, ok := h.Store.GetGrant(r.Context(), grant.ID); ok && !isWaiting(g.Status) {
+		return g
+	}
+
+	timer := time.NewTimer(time.Duration(h.LongPollSeconds) * time.Second)
+	defer timer.Stop()
+	select {
+	case <-wake:
+	case <-timer.C:
+	case <-r.Context().Done():
+	}
+	if g, ok := h.Store.GetGrant(r.Context(), grant.ID); ok {
+		return g
+	}
+	return grant
+}
+
+func isWaiting(s types.GrantStatus) bool {
+	return s == types.GrantStatusProcessing || s == types.GrantStatusPending
+}
+
+// pollWait is the wait the client must honor before polling grant again.
+func (h *ContinueHandler) pollWait(grant *types.GrantState) int {
+	return max(grant.PollWait, h.WaitSeconds)
+}
+
+// revoke ends the grant at the client's request (RFC 9635 §5.4) along with every
+// access token issued under it. A grant that has already ended, whether denied,
+// expired or revoked, is answered the same way.
+func (h *ContinueHandler) revoke(w http.ResponseWriter, r *http.Request, grant *types.GrantState) {
+	if _, err := h.Store.RevokeGrant(r.Context(), grant.ID); err != nil {
+		log.Printf("continue: revoke grant %s: %v", grant.ID, err)
+		httpx.WriteGNAPError(w, errRevokeFailed)
+		return
+	}
+	n, err := h.TokenStore.RevokeByInstanceID(r.Context(), grant.ID)
+	if err != nil {
+		log.Printf("continue: revoke tokens of grant %s: %v", grant.ID, err)
+		httpx.WriteGNAPError(w, errRevokeFailed)
 		return
+	}
+	log.Printf("continue: grant %s revoked with %d access token(s)", grant.ID, n)
+	w.WriteHeader(http.StatusNoContent)
+}
 
-	default:
-		httpx.WriteError(w, http.StatusBadRequest, "unknown grant status")
+// writeTokens issues the approved access tokens, bound to the client key unless it asked
+// for bearer, and finalizes the grant. Issuance runs under the store's transition to
+// finalized, so concurrent continuations cannot issue twice. The response carries a
+// rotated continuation token for later modification of the grant.
+func (h *ContinueHandler) writeTokens(w http.ResponseWriter, r *http.Request, grant *types.GrantState, current string) {
+	issuer := baseURL(r)
+	fmt.Println("grant ", grant)
+	var tok []*token.Token
+	grant, err := h.Store.FinalizeGrant(r.Context(), grant.ID, func(g *types.GrantState) error {
+		if len(g.ApprovedAccess) == 0 {
+			return nil // a subject-only grant
+		}
+		cfg := token.IssueConfig{
+			Issuer:          issuer,
+			Audience:        g.Locations,
+			TokenTTLSeconds: grantTokenTTL(r.Context(), h.Store),
+		}
+		cfg.BoundProof, cfg.ClientJWK, cfg.ClientCertS256 = clientKeyBinding(g.Client.Key)
+		var err error
+		tok, err = token.IssueToken(r.Context(), h.TokenStore, g, cfg)
+		return err
+	})
+	if errors.Is(err, gnap.ErrInvalidTransition) {
+		httpx.WriteGNAPError(w, errGrantFinalized)
+		return
+	}
+	if err != nil {
+		httpx.WriteGNAPError(w, err)
 		return
 	}
+
+	resp := map[string]any{}
+	if grant.Client.InstanceID != "" {
+		resp["instance_id"] = grant.Client.InstanceID
+	}
+	// One token is returned as an object, several as the labeled array the client asked for (RFC 9635 §3.2)
+	switch {
+	case len(tok) == 0:
+	case grant.MultipleTokens:
+		resp["access_token"] = tok
+	default:
+		resp["access_token"] = tok[0]
+	}
+	if subject := h.subjectInfo(r, grant); subject != nil {
+		resp["subject"] = subject
+	}
+	// The tokens are issued either way; without a continue field the client just cannot modify the grant
+	if cont, err := h.nextContinue(r, grant.ID, current, 0); err == nil {
+		resp["continue"] = cont
+	} else {
+		log.Printf("continue: grant %s finalized without rotating continuation token: %v", grant.ID, err)
+	}
+	httpx.WriteJSON(w, http.StatusOK, resp)
+}
+
+// subjectInfo returns the subject identifiers and assertions the client asked for, if
+// the AS authenticated the user, the user agreed to share them and they include any
+// identifier the client said it expects.
+func (h *ContinueHandler) subjectInfo(r *http.Request, grant *types.GrantState) *types.SubjectInfo {
+	if !grant.SubjectShared || !authenticatedSubject(grant) || grant.SubjectRequest == nil {
+		return nil
+	}
+	req := grant.SubjectRequest
+
+	// Assertions are meant for the client's key
+	thumb, err := sign.KeyThumbprint(grant.Client.Key)
+	if err != nil {
+		log.Printf("continue: no key thumbprint for grant %s: %v", grant.ID, err)
+	}
+	wantAssertion := h.Keys != nil && h.Issuer != "" && slices.Contains(req.AssertionFormats, types.AssertionFormatJWT)
+	var pairwiseID string
+	if h.Pairwise != nil && (thumb != "" || grant.Client.InstanceID != "") && (wantAssertion || slices.Contains(req.SubIDFormats, types.SubIDFormatPairwise)) {
+		pairwiseID = h.clientPairwiseID(grant, thumb)
+	}
+
+	ids := gnap.SubjectIDs(*grant.Subject, req.SubIDFormats, h.Issuer, pairwiseID)
+	if !gnap.MatchesSubIDs(ids, req.SubIDs) {
+		return nil
+	}
+	info := &types.SubjectInfo{SubIDs: ids}
+
+	if wantAssertion {
+		// Assertions name the user by their pairwise id too, so they do not undo it
+		sub := pairwiseID
+		if sub == "" {
+			sub = *grant.Subject
+		}
+		assertion, err := token.IssueAssertion(h.Keys, token.AssertionConfig{Issuer: h.Issuer, Subject: sub, Audience: thumb})
+		if err != nil {
+			log.Printf("continue: identity assertion for grant %s: %v", grant.ID, err)
+		} else {
+			info.Assertions = append(info.Assertions, types.Assertion{Format: types.AssertionFormatJWT, Value: assertion})
+		}
+	}
+
+	if len(info.SubIDs) == 0 && len(info.Assertions) == 0 {
+		return nil
+	}
+	return info
+}
+
+// authenticatedSubject reports whether the grant's subject is an account the AS has
+// authenticated, which is only one a trusted assertion vouched for. A user the client
+// merely named is not.
+func authenticatedSubject(grant *types.GrantState) bool {
+	return grant.Subject != nil && grant.User != nil && grant.User.Verified && *grant.Subject == grant.User.Account
+}
+
+// clientPairwiseID derives the user's pairwise id for the grant's client, known by its
+// instance_id or else by its key thumbprint thumb. It returns "" if there is none.
+func (h *ContinueHandler) clientPairwiseID(grant *types.GrantState, thumb string) string {
+	id, err := h.Pairwise.ID(gnap.TenantOrDefault(grant.Tenant), *grant.Subject, gnap.ClientSector(grant.Client.InstanceID, thumb))
+	if err != nil {
+		log.Printf("continue: pairwise id for grant %s: %v", grant.ID, err)
+		return ""
+	}
+	return id
+}
+
+// clientKeyBinding returns the key material issued tokens are bound to: the client's JWK,
+// and for mtls keys presented as a certificate, that certificate's thumbprint.
+func clientKeyBinding(key types.ClientKey) (proof string, jwk json.RawMessage, certS256 string) {
+	if key.JWK.Kty != "" {
+		jwk, _ = json.Marshal(key.JWK)
+	}
+	certS256 = key.CertS256
+	if key.Cert != "" {
+		if der, err := base64.StdEncoding.DecodeString(key.Cert); err == nil {
+			if cert, err := x509.ParseCertificate(der); err == nil {
+				certS256 = sign.CertThumbprint(cert)
+			}
+		}
+	}
+	return key.Proof, jwk, certS256
 }
 
 func baseURL(r *http.Request) string {
@@ -0,0 +1,263 @@
+package handlers
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/TwigBush/gnap-go/internal/gnap"
+	"github.com/TwigBush/gnap-go/internal/jwks"
+	mw2 "github.com/TwigBush/gnap-go/internal/mw"
+	"github.com/TwigBush/gnap-go/internal/types"
+	"github.com/lestrrat-go/jwx/v3/jws"
+)
+
+type staticRSRegistry string
+
+func (s staticRSRegistry) Resolve(context.Context, json.RawMessage) (string, error) {
+	return string(s), nil
+}
+
+func (s staticRSRegistry) GetVerificationKey(context.Context, string, *http.Request) (any, error) {
+	return nil, nil
+}
+
+func TestContinueHandler_ModifyRevokesEarlierTokens(t *testing.T) {
+	ctx := context.Background()
+	tokens, err := gnap.NewTokenStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewTokenStore: %v", err)
+	}
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	key := types.ClientKey{Proof: "httpsig", JWK: types.JWK{Kty: "OKP", Crv: "Ed25519", X: base64.RawURLEncoding.EncodeToString(pub)}}
+	read := types.AccessTokenRequest{{Access: []types.AccessItem{{Type: "photo-api", Actions: []string{"read"}}}}}
+	readWrite := types.AccessTokenRequest{{Access: []types.AccessItem{{Type: "photo-api", Actions: []string{"read", "write"}}}}}
+	readDelete := types.AccessTokenRequest{{Access: []types.AccessItem{{Type: "photo-api", Actions: []string{"read", "delete"}}}}}
+
+	intro := &IntrospectionHandler{Store: tokens, RSRegistry: staticRSRegistry("rs1"), ASGrantURL: "http://example.com"}
+	active := func(value string) bool {
+		body := `{"access_token": "` + value + `", "proof": "httpsig", "resource_server": "rs1"}`
+		r := httptest.NewRequest(http.MethodPost, "/introspect", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		intro.Introspect(rec, mw2.WithRSIdentity(r, mw2.RSIdentity{ID: "rs1"}))
+		var resp asIntroResp
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("introspection response %s: %v", rec.Body, err)
+		}
+		return resp.Active
+	}
+
+	tests := []struct {
+		name     string
+		modified types.AccessTokenRequest
+	}{
+		// Access the user already approved is issued right away, replacing the earlier token
+		{"approved access", read},
+		// Access beyond the approval goes back to the user, who denies it here
+		{"new access, then denied", readDelete},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, err := gnap.NewFileStore(t.TempDir(), types.Config{GrantTTLSeconds: 60})
+			if err != nil {
+				t.Fatalf("NewFileStore: %v", err)
+			}
+			h := NewContinueHandler(store, tokens)
+			g, err := store.CreateGrant(ctx, gnap.DefaultTenant, types.GrantRequest{AccessToken: readWrite, Client: types.Client{Key: key}}, nil)
+			if err != nil {
+				t.Fatalf("CreateGrant: %v", err)
+			}
+			_ = store.MarkCodeVerified(ctx, g.ID)
+			if _, err = store.ApproveGrant(ctx, g.ID, readWrite, "alice", false); err != nil {
+				t.Fatalf("ApproveGrant: %v", err)
+			}
+
+			// step runs a continuation step and returns the access token it issued, if any
+			step := func(fn func(http.ResponseWriter, *http.Request, *types.GrantState)) string {
+				t.Helper()
+				g, _ = store.GetGrant(ctx, g.ID)
+				rec := httptest.NewRecorder()
+				fn(rec, httptest.NewRequest(http.MethodPatch, "/continue/"+g.ID, nil), g)
+				var resp struct {
+					AccessToken struct {
+						Value string `json:"value"`
+					} `json:"access_token"`
+				}
+				if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK {
+					t.Fatalf("continuation response %d %s", rec.Code, rec.Body)
+				}
+				return resp.AccessToken.Value
+			}
+			old := step(func(w http.ResponseWriter, r *http.Request, g *types.GrantState) {
+				h.writeTokens(w, r, g, g.ContinuationToken)
+			})
+			replaced := step(func(w http.ResponseWriter, r *http.Request, g *types.GrantState) {
+				h.modify(w, r, g, types.ContinueRequest{AccessToken: tt.modified}, g.ContinuationToken)
+			})
+			if replaced == "" {
+				if g, _ = store.GetGrant(ctx, g.ID); g.Status != types.GrantStatusPending {
+					t.Fatalf("status = %s, want pending", g.Status)
+				}
+				if _, err := store.DenyGrant(ctx, g.ID); err != nil {
+					t.Fatalf("DenyGrant: %v", err)
+				}
+			}
+
+			if active(old) {
+				t.Fatalf("token issued before the modification is still active")
+			}
+			if replaced != "" && !active(replaced) {
+				t.Fatalf("token issued by the modification is not active")
+			}
+		})
+	}
+}
+
+func TestContinueHandler_SubjectInfo(t *testing.T) {
+	keys, err := jwks.Load(t.TempDir())
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	const issuer = "https://as.example"
+	h := &ContinueHandler{Keys: keys, Issuer: issuer}
+	account := "alice@example.com"
+	grant := func(user *types.UserHint) *types.GrantState {
+		return &types.GrantState{
+			ID:             "g1",
+			Subject:        &account,
+			SubjectShared:  true,
+			SubjectRequest: &types.SubjectRequest{SubIDFormats: []string{types.SubIDFormatEmail}, AssertionFormats: []string{types.AssertionFormatJWT}},
+			User:           user,
+		}
+	}
+
+	tests := []struct {
+		name string
+		user *types.UserHint
+		want bool
+	}{
+		{"no user", nil, false},
+		{"named by the client", &types.UserHint{Account: account}, false},
+		{"asserted", &types.UserHint{Account: account, Verified: true, Issuer: "https://idp.example"}, true},
+		{"asserted another account", &types.UserHint{Account: "bob@example.com", Verified: true, Issuer: "https://idp.example"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/continue/g1", nil)
+			r.Host = "attacker.example"
+			got := h.subjectInfo(r, grant(tt.user))
+			if (got != nil) != tt.want {
+				t.Fatalf("subjectInfo() = %+v, want subject info %v", got, tt.want)
+			}
+			if got != nil && (len(got.SubIDs) != 1 || got.SubIDs[0].Email != account || len(got.Assertions) != 1) {
+				t.Fatalf("subjectInfo() = %+v, want the email and an assertion", got)
+			}
+			if got != nil {
+				msg, err := jws.Parse([]byte(got.Assertions[0].Value))
+				if err != nil {
+					t.Fatalf("parse assertion: %v", err)
+				}
+				var claims jwks.Claims
+				if err := json.Unmarshal(msg.Payload(), &claims); err != nil || claims.Issuer != issuer {
+					t.Fatalf("assertion iss = %q (%v), want the configured %q", claims.Issuer, err, issuer)
+				}
+			}
+		})
+	}
+}
+
+func TestContinueHandler_RevokeEndedGrant(t *testing.T) {
+	ctx := context.Background()
+	tokens, err := gnap.NewTokenStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewTokenStore: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		ttl  int64
+		want types.GrantStatus
+	}{
+		{"denied", 60, types.GrantStatusDenied},
+		{"expired", -60, types.GrantStatusExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, err := gnap.NewFileStore(t.TempDir(), types.Config{GrantTTLSeconds: tt.ttl})
+			if err != nil {
+				t.Fatalf("NewFileStore: %v", err)
+			}
+			h := NewContinueHandler(store, tokens)
+			g, err := store.CreateGrant(ctx, gnap.DefaultTenant, types.GrantRequest{}, nil)
+			if err != nil {
+				t.Fatalf("CreateGrant: %v", err)
+			}
+			_, _ = store.DenyGrant(ctx, g.ID) // expires the grant instead once its time has passed
+
+			// Revoking an ended grant succeeds, again and again, and leaves it as it was
+			for i := 0; i < 2; i++ {
+				g, _ = store.GetGrant(ctx, g.ID)
+				rec := httptest.NewRecorder()
+				h.revoke(rec, httptest.NewRequest(http.MethodDelete, "/continue/"+g.ID, nil), g)
+				if rec.Code != http.StatusNoContent {
+					t.Fatalf("revoke %d: HTTP %d %s, want 204", i+1, rec.Code, rec.Body)
+				}
+			}
+			if g, _ = store.GetGrant(ctx, g.ID); g.Status != tt.want {
+				t.Fatalf("status = %s, want %s", g.Status, tt.want)
+			}
+		})
+	}
+}
+
+func TestContinueHandler_PairwiseSurvivesKeyRotation(t *testing.T) {
+	pairwise, err := gnap.NewPairwiseSubjects(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewPairwiseSubjects: %v", err)
+	}
+	h := &ContinueHandler{Pairwise: pairwise}
+	newKey := func() types.ClientKey {
+		pub, _, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("GenerateKey: %v", err)
+		}
+		return types.ClientKey{Proof: "httpsig", JWK: types.JWK{Kty: "OKP", Crv: "Ed25519", X: base64.RawURLEncoding.EncodeToString(pub)}}
+	}
+	account := "alice@example.com"
+	pairwiseID := func(instanceID string, key types.ClientKey) string {
+		t.Helper()
+		got := h.subjectInfo(httptest.NewRequest(http.MethodPost, "/continue/g1", nil), &types.GrantState{
+			ID:             "g1",
+			Client:         types.Client{InstanceID: instanceID, Key: key},
+			Subject:        &account,
+			SubjectShared:  true,
+			SubjectRequest: &types.SubjectRequest{SubIDFormats: []string{types.SubIDFormatPairwise}},
+			User:           &types.UserHint{Account: account, Verified: true},
+		})
+		if got == nil || len(got.SubIDs) != 1 || got.SubIDs[0].ID == "" {
+			t.Fatalf("subjectInfo() = %+v, want a pairwise id", got)
+		}
+		return got.SubIDs[0].ID
+	}
+	before, after := newKey(), newKey()
+
+	if pairwiseID("client-1", before) != pairwiseID("client-1", after) {
+		t.Fatalf("a registered client's pairwise id changed with its key")
+	}
+	if pairwiseID("client-1", before) == pairwiseID("client-2", before) {
+		t.Fatalf("two registered clients share a pairwise id")
+	}
+	// Unregistered clients are only known by their key
+	if pairwiseID("", before) == pairwiseID("", after) {
+		t.Fatalf("unregistered clients with different keys share a pairwise id")
+	}
+}
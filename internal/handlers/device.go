@@ -6,20 +6,27 @@ import (
 	"log"
 	"net/http"
 	"regexp"
+	"strings"
 	"time"
 
+	"github.com/TwigBush/gnap-go/internal/gnap"
 	"github.com/TwigBush/gnap-go/internal/httpx"
+	"github.com/TwigBush/gnap-go/internal/sign"
 	"github.com/TwigBush/gnap-go/internal/types"
+	"github.com/go-chi/chi/v5"
+	"github.com/skip2/go-qrcode"
 )
 
 var userCodeRe = regexp.MustCompile(`^[A-Z0-9]{4}-[A-Z0-9]{4}$`)
 
 type DeviceHandler struct {
-	Store types.GrantStore
+	Store   types.GrantStore
+	Pusher  *InteractPusher
+	Waiters *GrantWaiters // woken on consent; nil disables
 }
 
 func NewDeviceHandler(store types.GrantStore) *DeviceHandler {
-	return &DeviceHandler{Store: store}
+	return &DeviceHandler{Store: store, Pusher: NewInteractPusher(store)}
 }
 
 type verifyRequest struct {
@@ -29,19 +36,22 @@ type verifyRequest struct {
 // ---------- JSON verify: POST /device/verify (application/json) → JSON (Java DTO shape) ----------
 
 type GrantStateJSON struct {
-	ID                string                   `json:"id"`
-	Client            types.Client             `json:"client"`
-	RequestedAccess   types.AccessTokenRequest `json:"requested_access"`
-	Status            types.GrantStatus        `json:"status"`
-	ContinuationToken string                   `json:"continuation_token"`
-	CreatedAt         string                   `json:"created_at"`
-	UpdatedAt         string                   `json:"updated_at"`
-	ExpiresAt         string                   `json:"expires_at"`
-	InteractionNonce  string                   `json:"interaction_nonce"`
-	UserCode          string                   `json:"user_code"`
-	Subject           string                   `json:"subject"`
-	ApprovedAccess    types.AccessTokenRequest `json:"approved_access"`
-	Locations         []string                 `json:"locations"`
+	ID                string                        `json:"id"`
+	Client            types.Client                  `json:"client"`
+	ClientThumbprint  string                        `json:"client_thumbprint,omitempty"`
+	ClientVerified    bool                          `json:"client_verified"` // pre-registered, so its display is not self-asserted
+	RequestedAccess   types.AccessTokenRequest      `json:"requested_access"`
+	AccessRefs        map[string][]types.AccessItem `json:"access_refs,omitempty"` // access requested by name, as expanded
+	Status            types.GrantStatus             `json:"status"`
+	ContinuationToken string                        `json:"continuation_token"`
+	CreatedAt         string                        `json:"created_at"`
+	UpdatedAt         string                        `json:"updated_at"`
+	ExpiresAt         string                        `json:"expires_at"`
+	InteractionNonce  string                        `json:"interaction_nonce"`
+	UserCode          string                        `json:"user_code"`
+	Subject           string                        `json:"subject"`
+	ApprovedAccess    types.AccessTokenRequest      `json:"approved_access"`
+	Locations         []string                      `json:"locations"`
 }
 
 func (h *DeviceHandler) VerifyJSON(w http.ResponseWriter, r *http.Request) {
@@ -49,23 +59,23 @@ func (h *DeviceHandler) VerifyJSON(w http.ResponseWriter, r *http.Request) {
 	log.Printf("device.verify: %s", r.Body)
 	var req verifyRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		httpx.WriteError(w, http.StatusBadRequest, "invalid JSON")
+		httpx.WriteGNAPError(w, gnap.NewError(gnap.CodeInvalidRequest, "invalid JSON"))
 		return
 	}
 	if !userCodeRe.MatchString(req.UserCode) {
-		httpx.WriteError(w, http.StatusBadRequest, "invalid user_code format")
+		httpx.WriteGNAPError(w, gnap.NewError(gnap.CodeInvalidRequest, "invalid user_code format"))
 		return
 	}
 
 	grant, ok := h.Store.FindGrantByUserCodePending(r.Context(), req.UserCode)
 	if !ok || grant == nil {
-		httpx.WriteError(w, http.StatusBadRequest, "Invalid or expired code. Please try again.")
+		httpx.WriteGNAPError(w, gnap.NewError(gnap.CodeUnknownInteraction, "Invalid or expired code. Please try again."))
 		return
 	}
 
 	// mark verified (atomic transition in the store)
 	if err := h.Store.MarkCodeVerified(r.Context(), grant.ID); err != nil {
-		httpx.WriteError(w, http.StatusInternalServerError, err.Error())
+		httpx.WriteGNAPError(w, err)
 		return
 	}
 
@@ -74,20 +84,24 @@ func (h *DeviceHandler) VerifyJSON(w http.ResponseWriter, r *http.Request) {
 }
 
 func mapGrantStateToJSON(g *types.GrantState) GrantStateJSON {
+	client := newConsentClient(g.Client)
 	return GrantStateJSON{
 		ID:                g.ID,
 		Client:            g.Client,
+		ClientThumbprint:  client.Thumbprint,
+		ClientVerified:    client.Verified,
 		RequestedAccess:   g.RequestedAccess,
+		AccessRefs:        g.AccessRefs,
 		Status:            g.Status,
 		ContinuationToken: g.ContinuationToken,
 		CreatedAt:         formatRFC3339(g.CreatedAt),
 		UpdatedAt:         formatRFC3339(g.UpdatedAt),
 		ExpiresAt:         formatRFC3339(g.ExpiresAt),
-		// InteractionNonce: if you add it to GrantState, plumb it here
-		UserCode:       deref(g.UserCode),
-		Subject:        deref(g.Subject),
-		ApprovedAccess: g.ApprovedAccess,
-		Locations:      g.Locations,
+		InteractionNonce:  g.InteractNonce,
+		UserCode:          deref(g.UserCode),
+		Subject:           deref(g.Subject),
+		ApprovedAccess:    g.ApprovedAccess,
+		Locations:         g.Locations,
 	}
 }
 
@@ -121,12 +135,17 @@ func (h *DeviceHandler) ConsentForm(w http.ResponseWriter, r *http.Request) {
 
 	switch decision {
 	case "approve":
-		// Approve with requested_access and a subject "user:device"
-		_, err := h.Store.ApproveGrant(r.Context(), grantID, g.ApprovedAccess, "user:device")
+		// Approve with requested_access, as the asserted user or "user:device"
+		subject, share := consentSubject(r, g, "user:device")
+		_, err := h.Store.ApproveGrant(r.Context(), grantID, g.ApprovedAccess, subject, share)
 		if err != nil {
 			deviceError(w, httpx.SafeErrMsg(err))
 			return
 		}
+		h.Waiters.Notify(grantID)
+		if finishInteraction(w, r, h.Store, h.Pusher, g) {
+			return
+		}
 		deviceSuccess(w)
 	default:
 		_, err := h.Store.DenyGrant(r.Context(), grantID)
@@ -134,6 +153,10 @@ func (h *DeviceHandler) ConsentForm(w http.ResponseWriter, r *http.Request) {
 			deviceError(w, httpx.SafeErrMsg(err))
 			return
 		}
+		h.Waiters.Notify(grantID)
+		if finishInteraction(w, r, h.Store, h.Pusher, g) {
+			return
+		}
 		deviceDenied(w)
 	}
 }
@@ -166,16 +189,64 @@ func (h *DeviceHandler) VerifyForm(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Render the consent screen for this grant
-	consentScreen(w, grant)
+	consentScreen(w, grant, "/device/consent", deref(grant.UserCode))
 }
 
-// ---------- HTML page: GET /device ----------
+// ---------- HTML page: GET /device[?user_code=...] ----------
 
 func (h *DeviceHandler) Page(w http.ResponseWriter, r *http.Request) {
+	devicePage(w, normalizeUserCode(r.URL.Query().Get("user_code")))
+}
+
+// ---------- user_code_uri: GET /d/{code} → device page with the code filled in ----------
+
+func (h *DeviceHandler) ShortLink(w http.ResponseWriter, r *http.Request) {
+	devicePage(w, normalizeUserCode(chi.URLParam(r, "code")))
+}
+
+// ---------- QR code: GET /device/qr/{code} → PNG of the code's short URI ----------
+
+func (h *DeviceHandler) QR(w http.ResponseWriter, r *http.Request) {
+	code := normalizeUserCode(chi.URLParam(r, "code"))
+	if code == "" {
+		httpx.WriteGNAPError(w, gnap.NewError(gnap.CodeInvalidRequest, "invalid user_code format"))
+		return
+	}
+	png, err := qrcode.Encode(httpx.BaseURL(r)+ShortCodePath(code), qrcode.Medium, 256)
+	if err != nil {
+		httpx.WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "image/png")
+	_, _ = w.Write(png)
+}
+
+// ShortCodePath is the path of the user_code_uri for code: short enough to type,
+// and the device page opens with the code already entered.
+func ShortCodePath(code string) string {
+	return "/d/" + strings.ReplaceAll(code, "-", "")
+}
+
+// normalizeUserCode accepts a user code with or without its dash, in any case,
+// and returns it in ABCD-1234 form, or "" if it is not a user code.
+func normalizeUserCode(v string) string {
+	v = strings.ToUpper(strings.ReplaceAll(v, "-", ""))
+	if len(v) != 8 {
+		return ""
+	}
+	v = v[:4] + "-" + v[4:]
+	if !userCodeRe.MatchString(v) {
+		return ""
+	}
+	return v
+}
+
+func devicePage(w http.ResponseWriter, code string) {
 	w.Header().Set("Cache-Control", "no-store")
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
-	if err := devicePageTmpl.Execute(w, nil); err != nil {
+	if err := devicePageTmpl.Execute(w, struct{ Code string }{Code: code}); err != nil {
 		http.Error(w, "template render error: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -213,6 +284,8 @@ var devicePageTmpl = template.Must(template.New("devicePage").Parse(`
   button:active{transform:translateY(1px)}
   .footer{margin-top:10px;font-size:12px;color:#94a3b8}
   .errbox{display:none;margin:-6px 0 4px;font-size:12px;color:var(--error)}
+  .qr{margin-top:18px;display:grid;justify-items:center;gap:8px}
+  .qr img{background:#fff;border-radius:12px;padding:8px}
 </style>
 </head>
 <body>
@@ -225,7 +298,7 @@ var devicePageTmpl = template.Must(template.New("devicePage").Parse(`
         <label for="user_code">User code</label>
         <div class="row">
           <input id="user_code" name="user_code" type="text" inputmode="latin"
-                 autocomplete="one-time-code" placeholder="ABCD-1234"
+                 autocomplete="one-time-code" placeholder="ABCD-1234" value="{{ .Code }}"
                  maxlength="9" pattern="^[A-Z0-9]{4}-[A-Z0-9]{4}$" required>
           <button id="submitBtn" type="submit" disabled>Verify</button>
         </div>
@@ -233,6 +306,13 @@ var devicePageTmpl = template.Must(template.New("devicePage").Parse(`
         <div id="err" class="errbox">Invalid code. Please use the format ABCD-1234.</div>
       </form>
 
+      {{ if .Code }}
+      <div class="qr">
+        <img src="/device/qr/{{ .Code }}" width="160" height="160" alt="QR code for this sign-in link">
+        <div class="hint">Or scan to continue on your phone.</div>
+      </div>
+      {{ end }}
+
       <div class="footer">Powered by TwigBush GNAP</div>
     </div>
   </div>
@@ -269,6 +349,7 @@ var devicePageTmpl = template.Must(template.New("devicePage").Parse(`
     if (!ok) { e.preventDefault(); err.style.display = 'block'; return; }
     btn.disabled = true; btn.textContent = 'Verifying…';
   });
+  if (input.value) validate(input.value);
   input.focus();
 })();
 </script>
@@ -451,13 +532,56 @@ var consentScreenTmpl = template.Must(template.New("consent").Parse(`
   button:active{transform:translateY(1px)}
   .deny{ background:linear-gradient(135deg,#374151,#111827); color:#e5e7eb; border:1px solid #374151; box-shadow:none; }
   .meta{font-size:12px;color:#94a3b8;margin-top:6px}
+  .client{display:flex; gap:14px; align-items:flex-start; margin-bottom:18px; border:1px solid #1f2937; border-radius:12px; padding:16px; background:rgba(15,23,42,.5)}
+  .client img{width:48px; height:48px; border-radius:10px; background:#fff; object-fit:contain}
+  .client-name{font-weight:700; font-size:16px; display:flex; gap:8px; align-items:center; flex-wrap:wrap}
+  .client a{color:var(--accent-2); font-size:13px; overflow-wrap:anywhere}
+  .client .chip{overflow-wrap:anywhere}
+  .unverified{ font:700 11px/1 ui-sans-serif,system-ui,sans-serif; text-transform:uppercase; letter-spacing:.5px; padding:5px 8px; border-radius:999px; color:#fde68a; background:rgba(245,158,11,.12); border:1px solid rgba(245,158,11,.35) }
 </style>
 </head>
 <body>
   <div class="wrap">
     <div class="card">
       <h1>Grant Consent</h1>
+      {{ if .UserCode }}
       <p>Device <strong>{{ .UserCode }}</strong> is requesting access. Review and approve or deny.</p>
+      {{ else }}
+      <p>An application is requesting access. Review and approve or deny.</p>
+      {{ end }}
+
+      {{ with .Client }}
+      <div class="client">
+        {{ if .LogoURI }}<img src="{{ .LogoURI }}" alt="" referrerpolicy="no-referrer">{{ end }}
+        <div>
+          <div class="client-name">
+            {{ if .Name }}{{ .Name }}{{ else }}Unnamed application{{ end }}
+            {{ if not .Verified }}<span class="unverified">unverified</span>{{ end }}
+          </div>
+          {{ if .URI }}<a href="{{ .URI }}" target="_blank" rel="noopener noreferrer">{{ .URI }}</a>{{ end }}
+          <div class="kv" style="margin-top:8px"><b>Key thumbprint</b><span class="chip">{{ .Thumbprint }}</span></div>
+          {{ if not .Verified }}<div class="meta">This application is not registered with this server. Its name and links are its own claims.</div>{{ end }}
+        </div>
+      </div>
+      {{ end }}
+
+      {{ if .AccessRefs }}
+        <div class="token-section">
+          <div class="token-label">Named access</div>
+          <div class="meta">The application asked for access by name. Each name stands for the access listed below it.</div>
+          <ul class="list">
+            {{ range $name, $items := .AccessRefs }}
+            <li class="item">
+              <div class="kv"><b>{{ $name }}</b></div>
+              {{ range $items }}
+                <div class="kv"><b>Type</b><span>{{ .Type }}</span></div>
+                {{ if .Actions }}<div class="chips">{{ range .Actions }}<span class="chip">{{ . }}</span>{{ end }}</div>{{ end }}
+              {{ end }}
+            </li>
+            {{ end }}
+          </ul>
+        </div>
+      {{ end }}
 
       {{ if .Requested }}
         {{ range $tokenIndex, $token := .Requested }}
@@ -500,10 +624,41 @@ var consentScreenTmpl = template.Must(template.New("consent").Parse(`
         {{ end }}
       {{ end }}
 
-      <form method="post" action="/device/consent" class="actions">
+      <form method="post" action="{{ .Action }}">
         <input type="hidden" name="grant_id" value="{{ .GrantID }}">
-        <button type="submit" name="decision" value="approve">Approve</button>
-        <button class="deny" type="submit" name="decision" value="deny">Deny</button>
+        {{ if or .Subject .User }}
+          <div class="token-section">
+            <div class="token-label">Your identity</div>
+            {{ with .User }}
+              {{ if .Verified }}
+                <div class="kv"><b>Continuing as</b> {{ .Account }} <span class="chip">verified by {{ .Issuer }}</span></div>
+              {{ else }}
+                <div class="kv"><b>The application says you are</b> {{ .Account }} <span class="chip">not verified</span></div>
+              {{ end }}
+            {{ end }}
+            {{ with .Subject }}
+              {{ if .SubIDFormats }}
+                <div class="kv"><b>Identifiers</b></div>
+                <div class="chips">{{ range .SubIDFormats }}<span class="chip">{{ . }}</span>{{ end }}</div>
+              {{ end }}
+              {{ if .AssertionFormats }}
+                <div class="kv"><b>Signed assertions</b></div>
+                <div class="chips">{{ range .AssertionFormats }}<span class="chip">{{ . }}</span>{{ end }}</div>
+              {{ end }}
+            {{ end }}
+            {{ if .Subject }}
+              {{ if and .User .User.Verified }}
+                <div class="kv" style="margin-top:8px"><label><input type="checkbox" name="share_subject" value="yes" checked> Share who I am with this application</label></div>
+              {{ else }}
+                <div class="meta">This server has not verified who you are, so nothing about you is shared.</div>
+              {{ end }}
+            {{ end }}
+          </div>
+        {{ end }}
+        <div class="actions">
+          <button type="submit" name="decision" value="approve">Approve</button>
+          <button class="deny" type="submit" name="decision" value="deny">Deny</button>
+        </div>
       </form>
 
       <div class="meta">Instance: {{ .GrantID }}</div>
@@ -513,16 +668,56 @@ var consentScreenTmpl = template.Must(template.New("consent").Parse(`
 </html>
 `))
 
-func consentScreen(w http.ResponseWriter, g *types.GrantState) {
+// consentSubject returns the account the user approved as and whether they agreed to
+// share it with the client. The AS has no login of its own, so the only account it
+// knows is one a trusted assertion vouched for; otherwise it is the placeholder account.
+func consentSubject(r *http.Request, g *types.GrantState, account string) (string, bool) {
+	if g.User != nil && g.User.Verified && g.User.Account != "" {
+		account = g.User.Account
+	}
+	return account, r.Form.Get("share_subject") != ""
+}
+
+// consentClient is who the user is told is asking. Clients that are not pre-registered
+// are shown as unverified, since their display information is self-asserted. A grant
+// only carries an instance_id its client matched in the registry, which only an admin
+// can write to.
+type consentClient struct {
+	types.ClientDisplay
+	Thumbprint string
+	Verified   bool
+}
+
+func newConsentClient(c types.Client) consentClient {
+	out := consentClient{Verified: c.InstanceID != ""}
+	if c.Display != nil {
+		out.ClientDisplay = *c.Display
+	}
+	out.Thumbprint, _ = sign.KeyThumbprint(c.Key)
+	return out
+}
+
+// consentScreen renders the consent form for g, posting the decision to action.
+func consentScreen(w http.ResponseWriter, g *types.GrantState, action string, userCode string) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
 	_ = consentScreenTmpl.Execute(w, struct {
-		GrantID   string
-		UserCode  string
-		Requested types.AccessTokenRequest
+		GrantID    string
+		UserCode   string
+		Client     consentClient
+		Requested  types.AccessTokenRequest
+		AccessRefs map[string][]types.AccessItem
+		Subject    *types.SubjectRequest
+		User       *types.UserHint
+		Action     string
 	}{
-		GrantID:   g.ID,
-		UserCode:  deref(g.UserCode),
-		Requested: g.RequestedAccess,
+		GrantID:    g.ID,
+		UserCode:   userCode,
+		Client:     newConsentClient(g.Client),
+		Requested:  g.RequestedAccess,
+		AccessRefs: g.AccessRefs,
+		Subject:    g.SubjectRequest,
+		User:       g.User,
+		Action:     action,
 	})
 }
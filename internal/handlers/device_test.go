@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/TwigBush/gnap-go/internal/gnap"
+	"github.com/TwigBush/gnap-go/internal/types"
+)
+
+func TestConsentScreen_Client(t *testing.T) {
+	key := types.ClientKey{Proof: "httpsig", JWK: types.JWK{Kty: "OKP", Crv: "Ed25519", X: "11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"}}
+	display := &types.ClientDisplay{Name: "Soup <Planner>", URI: "https://soup.example"}
+
+	tests := []struct {
+		name           string
+		client         types.Client
+		want           []string
+		wantUnverified bool
+	}{
+		{"self-asserted", types.Client{Key: key, Display: display},
+			[]string{"Soup &lt;Planner&gt;", `href="https://soup.example"`, "kPrK_qmxVWaYVA9wwBF6Iuo3vVzz7TxHCTwXBygrS4k"}, true},
+		{"registered", types.Client{Key: key, Display: display, InstanceID: "abc"}, []string{"Soup &lt;Planner&gt;"}, false},
+		{"no display", types.Client{Key: key}, []string{"Unnamed application"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			consentScreen(rec, &types.GrantState{ID: "g1", Client: tt.client}, "/device/consent", "ABCD-1234")
+			body := rec.Body.String()
+			for _, s := range tt.want {
+				if !strings.Contains(body, s) {
+					t.Fatalf("consent page is missing %q", s)
+				}
+			}
+			if got := strings.Contains(body, `class="unverified"`); got != tt.wantUnverified {
+				t.Fatalf("unverified badge shown = %v, want %v", got, tt.wantUnverified)
+			}
+		})
+	}
+}
+
+func TestConsentScreen_AccessRefs(t *testing.T) {
+	items := []types.AccessItem{{Type: "orders", Actions: []string{"list"}}}
+	g := &types.GrantState{
+		ID:              "g1",
+		RequestedAccess: types.AccessTokenRequest{{Access: items}},
+		AccessRefs:      map[string][]types.AccessItem{"read-orders": items},
+	}
+	rec := httptest.NewRecorder()
+	consentScreen(rec, g, "/device/consent", "ABCD-1234")
+	body := rec.Body.String()
+	for _, s := range []string{"Named access", "<b>read-orders</b>", "orders", `<span class="chip">list</span>`} {
+		if !strings.Contains(body, s) {
+			t.Fatalf("consent page is missing %q", s)
+		}
+	}
+}
+
+func TestConsentSubject(t *testing.T) {
+	tests := []struct {
+		name string
+		user *types.UserHint
+		want string
+	}{
+		{"no user", nil, "user:device"},
+		{"named by the client", &types.UserHint{Account: "alice@example.com"}, "user:device"},
+		{"asserted", &types.UserHint{Account: "alice", Verified: true, Issuer: "https://idp.example"}, "alice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A typed email is no proof of who the user is
+			r := httptest.NewRequest(http.MethodPost, "/device/consent", strings.NewReader("email=mallory%40example.com&share_subject=yes"))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			_ = r.ParseForm()
+			got, share := consentSubject(r, &types.GrantState{User: tt.user}, "user:device")
+			if got != tt.want || !share {
+				t.Fatalf("consentSubject() = %q, %v, want %q, true", got, share, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeviceFlow_Subject(t *testing.T) {
+	ctx := context.Background()
+	store, err := gnap.NewFileStore(t.TempDir(), types.Config{GrantTTLSeconds: 60})
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	tokens, err := gnap.NewTokenStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewTokenStore: %v", err)
+	}
+	device, cont := NewDeviceHandler(store), NewContinueHandler(store, tokens)
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	key := types.ClientKey{Proof: "httpsig", JWK: types.JWK{Kty: "OKP", Crv: "Ed25519", X: base64.RawURLEncoding.EncodeToString(pub)}}
+	subject := &types.SubjectRequest{SubIDFormats: []string{types.SubIDFormatEmail}}
+	asserted := &types.UserHint{Account: "alice@example.com", Verified: true, Issuer: "https://idp.example"}
+
+	// A user the AS cannot vouch for is refused up front rather than silently left out
+	for _, user := range []*types.UserHint{nil, {Account: "alice@example.com"}} {
+		if err := checkSubjectUser(subject, user); !errors.Is(err, gnap.ErrUnknownUser) {
+			t.Fatalf("checkSubjectUser(%+v) error = %v, want %v", user, err, gnap.ErrUnknownUser)
+		}
+	}
+	if err := checkSubjectUser(subject, asserted); err != nil {
+		t.Fatalf("checkSubjectUser(asserted) error = %v", err)
+	}
+
+	g, err := store.CreateGrant(ctx, gnap.DefaultTenant, types.GrantRequest{
+		Client:  types.Client{Key: key},
+		Subject: subject,
+	}, asserted)
+	if err != nil {
+		t.Fatalf("CreateGrant: %v", err)
+	}
+	_ = store.MarkCodeVerified(ctx, g.ID)
+
+	form := "grant_id=" + g.ID + "&decision=approve&share_subject=yes"
+	r := httptest.NewRequest(http.MethodPost, "/device/consent", strings.NewReader(form))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	device.ConsentForm(httptest.NewRecorder(), r)
+
+	g, _ = store.GetGrant(ctx, g.ID)
+	rec := httptest.NewRecorder()
+	cont.writeTokens(rec, httptest.NewRequest(http.MethodPost, "/continue/"+g.ID, nil), g, g.ContinuationToken)
+	var resp struct {
+		Subject *types.SubjectInfo `json:"subject"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Subject == nil {
+		t.Fatalf("continue response %d %s, want the subject", rec.Code, rec.Body)
+	}
+	if ids := resp.Subject.SubIDs; len(ids) != 1 || ids[0].Email != asserted.Account {
+		t.Fatalf("subject = %+v, want %s", resp.Subject, asserted.Account)
+	}
+}
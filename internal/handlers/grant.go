@@ -1,46 +1,192 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"log"
 	"net/http"
+	"slices"
+	"strings"
 
 	"github.com/TwigBush/gnap-go/internal/gnap"
 	"github.com/TwigBush/gnap-go/internal/httpx"
+	"github.com/TwigBush/gnap-go/internal/jwks"
 	"github.com/TwigBush/gnap-go/internal/sign"
 	"github.com/TwigBush/gnap-go/internal/types"
 )
 
 type GrantHandler struct {
-	Store       types.GrantStore
-	TokenStore  gnap.TokenStoreContainer
-	WaitSeconds int // how long the client should wait before polling /continue
+	Store         types.GrantStore
+	TokenStore    gnap.TokenStoreContainer
+	WaitSeconds   int      // how long the client should wait before polling /continue
+	StartModes    []string // interaction start modes clients may request
+	FinishMethods []string // interaction finish methods clients may request
+	AppLaunchURI  string   // base of app start mode URIs; defaults to the web interaction page
+	SubIDFormats  []string // subject identifier formats clients may request
+
+	// AssertionFormats are the identity assertion formats clients may request. None
+	// unless the AS has keys to sign them with.
+	AssertionFormats []string
+
+	// TrustedIssuers verifies assertions clients send about the user, which must name
+	// Issuer, the AS's configured URL, as their audience. With SkipInteraction, a grant
+	// for a user vouched for that way is approved without asking them.
+	TrustedIssuers  *jwks.TrustedIssuers
+	Issuer          string
+	SkipInteraction bool
+
+	// Clients is the registry of pre-registered clients, which may send their
+	// instance_id instead of their key. Grants from other clients are limited by
+	// UnknownClients.
+	Clients        *gnap.ClientStore
+	UnknownClients gnap.UnknownClientPolicy
+
+	// AccessDefinitions expands access requested by reference. Without it only
+	// object-form access can be requested.
+	AccessDefinitions *gnap.AccessDefinitions
 }
 
+// maxRequestBytes caps how much of a request body the AS reads before verifying its proof.
+const maxRequestBytes = 1 << 20
+
+var (
+	defaultStartModes = []string{
+		types.InteractStartRedirect, types.InteractStartApp,
+		types.InteractStartUserCode, types.InteractStartUserCodeURI,
+	}
+	defaultFinishMethods = []string{types.InteractFinishRedirect, types.InteractFinishPush}
+	defaultSubIDFormats  = []string{
+		types.SubIDFormatOpaque, types.SubIDFormatEmail, types.SubIDFormatIssSub,
+		types.SubIDFormatPublic, types.SubIDFormatPairwise,
+	}
+
+	// errInvalidKeyProof is returned for any rejected key proof; the reason is only logged.
+	errInvalidKeyProof = gnap.NewError(gnap.CodeInvalidClient, "invalid key proof")
+
+	errUnknownInstance = gnap.NewError(gnap.CodeInvalidClient, "unknown instance_id")
+
+	// errInvalidUserAssertion is returned for any rejected user assertion; the reason is only logged.
+	errInvalidUserAssertion = gnap.NewError(gnap.CodeUnknownUser, "untrusted or invalid user assertion")
+
+	errSubjectUnavailable = gnap.NewError(gnap.CodeUnknownUser, "subject information requires a user assertion from a trusted issuer")
+)
+
 func NewGrantHandler(store types.GrantStore) *GrantHandler {
-	return &GrantHandler{Store: store, WaitSeconds: 5}
+	return &GrantHandler{
+		Store:         store,
+		WaitSeconds:   5,
+		StartModes:    defaultStartModes,
+		FinishMethods: defaultFinishMethods,
+		SubIDFormats:  defaultSubIDFormats,
+	}
 }
 
 func (h *GrantHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	fmt.Println("Grant called")
-	// TODO: enforce proof  when implemented
-	if err := sign.VerifyRequestProof(r); err != nil {
-		httpx.WriteError(w, http.StatusUnauthorized, "invalid proof")
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestBytes))
+	if err != nil {
+		httpx.WriteGNAPError(w, gnap.NewError(gnap.CodeInvalidRequest, "invalid body"))
+		return
+	}
+
+	// Attached JWS requests carry the JSON request as the JWS payload
+	payload, err := sign.RequestPayload(r, body)
+	if err != nil {
+		httpx.WriteGNAPError(w, gnap.NewError(gnap.CodeInvalidRequest, "invalid JWS"))
 		return
 	}
 
 	var req types.GrantRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		httpx.WriteError(w, http.StatusBadRequest, "invalid JSON")
+	if err := json.Unmarshal(payload, &req); err != nil {
+		httpx.WriteGNAPError(w, gnap.NewError(gnap.CodeInvalidRequest, "invalid JSON"))
+		return
+	}
+
+	tenant := r.Header.Get(gnap.TenantHeader)
+	if tenant == "" {
+		tenant = gnap.DefaultTenant
+	}
+	if !gnap.ValidTenant(tenant) {
+		httpx.WriteGNAPError(w, gnap.NewError(gnap.CodeInvalidRequest, "invalid tenant"))
 		return
 	}
 
-	if req.Client.Key.Proof == "" || req.Client.Key.JWK.Kty == "" || len(req.AccessToken) == 0 {
-		httpx.WriteError(w, http.StatusBadRequest, "missing client.key or access")
+	// Access requested by reference is expanded before anything looks at it
+	req.AccessToken, req.AccessRefs, err = h.AccessDefinitions.Expand(r.Context(), tenant, req.AccessToken)
+	if err != nil {
+		httpx.WriteGNAPError(w, err)
 		return
 	}
 
-	state, err := h.Store.CreateGrant(r.Context(), req)
+	// A client may send its instance_id instead of its key
+	registered, err := h.registeredClient(r.Context(), tenant, &req.Client)
+	if err != nil {
+		httpx.WriteGNAPError(w, err)
+		return
+	}
+
+	if req.Client.Key.Proof == "" || !req.Client.Key.HasKeyMaterial() {
+		httpx.WriteGNAPError(w, gnap.NewError(gnap.CodeInvalidClient, "missing client.key"))
+		return
+	}
+	if err := gnap.ValidateClientDisplay(req.Client.Display); err != nil {
+		httpx.WriteGNAPError(w, err)
+		return
+	}
+	// A client may ask only for who the user is, without any access
+	if len(req.AccessToken) == 0 && req.Subject == nil {
+		httpx.WriteGNAPError(w, gnap.NewError(gnap.CodeInvalidRequest, "missing access or subject"))
+		return
+	}
+	if err := gnap.ValidateLabels(req.AccessToken, req.MultipleTokens); err != nil {
+		httpx.WriteGNAPError(w, err)
+		return
+	}
+	if err := gnap.ValidateFlags(req.AccessToken); err != nil {
+		httpx.WriteGNAPError(w, err)
+		return
+	}
+	if err := gnap.ValidateSubject(req.Subject, h.SubIDFormats, h.AssertionFormats); err != nil {
+		httpx.WriteGNAPError(w, err)
+		return
+	}
+
+	if err := gnap.ValidateInteract(req.Interact, h.StartModes, h.FinishMethods); err != nil {
+		httpx.WriteGNAPError(w, err)
+		return
+	}
+
+	// The request must be signed by the key it presents (RFC 9635 §7.3)
+	if err := sign.VerifyRequestProof(r, body, req.Client.Key); err != nil {
+		log.Printf("grant: key proof rejected: %v", err)
+		httpx.WriteGNAPError(w, errInvalidKeyProof)
+		return
+	}
+
+	if err := checkClientAccess(registered, h.UnknownClients, req.AccessToken); err != nil {
+		httpx.WriteGNAPError(w, err)
+		return
+	}
+
+	user, err := h.userHint(req.User)
+	if err != nil {
+		httpx.WriteGNAPError(w, err)
+		return
+	}
+	if err := checkSubjectUser(req.Subject, user); err != nil {
+		httpx.WriteGNAPError(w, err)
+		return
+	}
+
+	if err := h.defaultInteract(&req, user); err != nil {
+		httpx.WriteGNAPError(w, err)
+		return
+	}
+
+	state, err := h.Store.CreateGrant(r.Context(), tenant, req, user)
 	if err != nil {
 		httpx.WriteError(w, http.StatusInternalServerError, err.Error())
 		return
@@ -48,9 +194,22 @@ func (h *GrantHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	base := httpx.BaseURL(r)
 
-	code := ""
-	if state.UserCode != nil {
-		code = *state.UserCode
+	if h.approvesAsserted(user) {
+		// The assertion stands in for the user logging in and consenting
+		state, err = h.approveAsserted(r, state)
+		if err != nil {
+			httpx.WriteGNAPError(w, err)
+			return
+		}
+		// Approved already, so the client can continue right away
+		httpx.WriteJSON(w, http.StatusOK, types.GrantResponse{
+			Continue: types.Continue{
+				AccessToken: state.ContinuationToken,
+				URI:         base + "/continue/" + state.ID,
+			},
+			InstanceID: state.Client.InstanceID,
+		})
+		return
 	}
 
 	resp := types.GrantResponse{
@@ -59,15 +218,176 @@ func (h *GrantHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			URI:         base + "/continue/" + state.ID,
 			Wait:        h.WaitSeconds,
 		},
-
-		Interact: types.InteractOut{
-			Expires: state.ExpiresAt,
-			UserCode: types.UserCode{
-				Code: code,
-				URI:  base + "/device",
-			},
-		},
+		Interact:   interactOut(state, base, h.AppLaunchURI),
+		InstanceID: state.Client.InstanceID,
 	}
 
 	httpx.WriteJSON(w, http.StatusOK, resp)
 }
+
+// registeredClient finds the client in the registry, by the instance_id it sent or by
+// its key, and fills in the registered key, instance_id and display. It returns nil for
+// clients that are not registered.
+func (h *GrantHandler) registeredClient(ctx context.Context, tenant string, client *types.Client) (*gnap.ClientRecord, error) {
+	if client.InstanceID == "" {
+		if h.Clients == nil || !client.Key.HasKeyMaterial() {
+			return nil, nil
+		}
+		thumb, err := sign.KeyThumbprint(client.Key)
+		if err != nil {
+			return nil, nil // the key proof check rejects it
+		}
+		rec, ok := h.Clients.FindClientByThumbprint(ctx, tenant, thumb)
+		if !ok {
+			return nil, nil
+		}
+		client.InstanceID = rec.InstanceID
+		useRegisteredDisplay(client, rec)
+		return &rec, nil
+	}
+
+	rec, ok := h.Clients.GetClient(ctx, tenant, client.InstanceID)
+	if !ok {
+		return nil, errUnknownInstance
+	}
+	// A key sent along with the instance_id must be the registered one
+	if client.Key.HasKeyMaterial() {
+		if thumb, err := sign.KeyThumbprint(client.Key); err != nil || thumb != rec.Thumbprint {
+			return nil, gnap.NewError(gnap.CodeInvalidClient, "key does not match instance_id")
+		}
+	}
+	client.Key = rec.Key
+	useRegisteredDisplay(client, rec)
+	return &rec, nil
+}
+
+// useRegisteredDisplay shows the user what the client was registered as, rather than
+// what it says about itself.
+func useRegisteredDisplay(client *types.Client, rec gnap.ClientRecord) {
+	if rec.Display != nil {
+		client.Display = rec.Display
+	}
+}
+
+// checkClientAccess applies the client's registration to the access it asks for, or
+// the unknown-client policy if it is not registered.
+func checkClientAccess(registered *gnap.ClientRecord, unknown gnap.UnknownClientPolicy, access types.AccessTokenRequest) error {
+	if registered != nil {
+		return registered.CheckAccess(access)
+	}
+	return unknown.CheckAccess(access)
+}
+
+// checkSubjectUser refuses subject requests the AS could never answer. It has no login
+// of its own, so consent does not tell it who the user is: only a user a trusted
+// assertion vouched for can be returned as the subject.
+func checkSubjectUser(subject *types.SubjectRequest, user *types.UserHint) error {
+	if subject != nil && (user == nil || !user.Verified) {
+		return errSubjectUnavailable
+	}
+	return nil
+}
+
+// userHint validates the client's user request (RFC 9635 §2.4) and returns who it says
+// is present. Assertions must be for this AS, naming its Issuer as their audience, verify
+// against a trusted issuer and agree on the user; sub_ids alone are an unverified hint.
+func (h *GrantHandler) userHint(req *types.UserRequest) (*types.UserHint, error) {
+	if req == nil {
+		return nil, nil
+	}
+	if req.Ref != "" {
+		return nil, gnap.NewError(gnap.CodeUnknownUser, "unknown user reference")
+	}
+
+	var hint *types.UserHint
+	for _, a := range req.Assertions {
+		if a.Format != types.AssertionFormatJWT {
+			return nil, fmt.Errorf("%w: %q", gnap.ErrUnsupportedAssertionFormat, a.Format)
+		}
+		iss, sub, err := h.TrustedIssuers.Verify(a.Value, h.Issuer)
+		if err != nil {
+			log.Printf("grant: user assertion rejected: %v", err)
+			return nil, errInvalidUserAssertion
+		}
+		if hint != nil && (hint.Issuer != iss || hint.Account != sub) {
+			return nil, gnap.NewError(gnap.CodeUnknownUser, "user assertions name different users")
+		}
+		hint = &types.UserHint{Account: sub, Verified: true, Issuer: iss}
+	}
+	if hint != nil {
+		return hint, nil
+	}
+
+	for _, id := range req.SubIDs {
+		if account := gnap.SubIDAccount(id); account != "" {
+			return &types.UserHint{Account: account}, nil
+		}
+	}
+	return nil, nil
+}
+
+// approvesAsserted reports whether a grant for user is approved without interaction,
+// because a trusted assertion vouched for them.
+func (h *GrantHandler) approvesAsserted(user *types.UserHint) bool {
+	return h.SkipInteraction && user != nil && user.Verified
+}
+
+// defaultInteract gives a request that names no interaction start mode the user_code
+// mode, since otherwise nobody could ever approve it. Grants approved through an
+// asserted user need no interaction.
+func (h *GrantHandler) defaultInteract(req *types.GrantRequest, user *types.UserHint) error {
+	if (req.Interact != nil && len(req.Interact.Start) > 0) || h.approvesAsserted(user) {
+		return nil
+	}
+	if !slices.Contains(h.StartModes, types.InteractStartUserCode) {
+		return gnap.NewError(gnap.CodeInvalidRequest, "interact.start is required")
+	}
+	in := types.Interact{}
+	if req.Interact != nil {
+		in = *req.Interact
+	}
+	in.Start = []string{types.InteractStartUserCode}
+	req.Interact = &in
+	return nil
+}
+
+// approveAsserted approves a grant for the user a trusted assertion vouched for.
+func (h *GrantHandler) approveAsserted(r *http.Request, state *types.GrantState) (*types.GrantState, error) {
+	if err := h.Store.MarkCodeVerified(r.Context(), state.ID); err != nil {
+		return nil, err
+	}
+	return h.Store.ApproveGrant(r.Context(), state.ID, nil, state.User.Account, true)
+}
+
+// interactOut describes how to start interaction, for only the modes the client requested.
+func interactOut(state *types.GrantState, base, appLaunchURI string) *types.InteractOut {
+	in := state.Interact
+	if in == nil || len(in.Start) == 0 {
+		return nil
+	}
+
+	out := &types.InteractOut{
+		Expires: state.ExpiresAt,
+		Finish:  state.InteractNonce,
+	}
+	if in.HasStart(types.InteractStartRedirect) {
+		out.Redirect = base + "/interact/" + state.InteractID
+	}
+	if in.HasStart(types.InteractStartApp) {
+		launch := appLaunchURI
+		if launch == "" {
+			launch = base + "/interact"
+		}
+		out.App = strings.TrimRight(launch, "/") + "/" + state.InteractID
+	}
+	if state.UserCode != nil {
+		code := *state.UserCode
+		if in.HasStart(types.InteractStartUserCode) {
+			out.UserCode = &types.UserCode{Code: code, URI: base + "/device"}
+		}
+		if in.HasStart(types.InteractStartUserCodeURI) {
+			out.UserCodeURI = &types.UserCode{Code: code, URI: base + ShortCodePath(code)}
+		}
+	}
+	return out
+}
@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/TwigBush/gnap-go/internal/gnap"
+	"github.com/TwigBush/gnap-go/internal/jwks"
+	"github.com/TwigBush/gnap-go/internal/sign"
+	"github.com/TwigBush/gnap-go/internal/token"
+	"github.com/TwigBush/gnap-go/internal/types"
+)
+
+func TestInteractOut(t *testing.T) {
+	code := "ABCD-1234"
+	state := func(start ...string) *types.GrantState {
+		return &types.GrantState{
+			Interact:   &types.Interact{Start: start},
+			InteractID: "ix1",
+			UserCode:   &code,
+		}
+	}
+	const base = "https://as.example"
+
+	tests := []struct {
+		name  string
+		state *types.GrantState
+		want  types.InteractOut
+	}{
+		{"redirect only", state("redirect"), types.InteractOut{Redirect: base + "/interact/ix1"}},
+		{"app only", state("app"), types.InteractOut{App: base + "/interact/ix1"}},
+		{"user_code only", state("user_code"), types.InteractOut{UserCode: &types.UserCode{Code: code, URI: base + "/device"}}},
+		{"user_code_uri only", state("user_code_uri"), types.InteractOut{UserCodeURI: &types.UserCode{Code: code, URI: base + "/d/ABCD1234"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := interactOut(tt.state, base, "")
+			if got == nil {
+				t.Fatalf("interactOut() = nil")
+			}
+			if got.Redirect != tt.want.Redirect || got.App != tt.want.App {
+				t.Fatalf("interactOut() redirect/app = %q/%q, want %q/%q", got.Redirect, got.App, tt.want.Redirect, tt.want.App)
+			}
+			if (got.UserCode == nil) != (tt.want.UserCode == nil) || (got.UserCode != nil && *got.UserCode != *tt.want.UserCode) {
+				t.Fatalf("interactOut() user_code = %+v, want %+v", got.UserCode, tt.want.UserCode)
+			}
+			if (got.UserCodeURI == nil) != (tt.want.UserCodeURI == nil) || (got.UserCodeURI != nil && *got.UserCodeURI != *tt.want.UserCodeURI) {
+				t.Fatalf("interactOut() user_code_uri = %+v, want %+v", got.UserCodeURI, tt.want.UserCodeURI)
+			}
+		})
+	}
+
+	if got := interactOut(&types.GrantState{}, base, ""); got != nil {
+		t.Fatalf("interactOut() without interact = %+v, want nil", got)
+	}
+}
+
+func TestGrantHandler_UserHint(t *testing.T) {
+	idp, err := jwks.Load(t.TempDir())
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	b, _ := json.Marshal(idp.Public())
+	path := filepath.Join(t.TempDir(), "idp.jwks.json")
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		t.Fatalf("write jwks: %v", err)
+	}
+	trusted, err := jwks.LoadTrustedIssuers(map[string]string{"https://idp.example": path})
+	if err != nil {
+		t.Fatalf("LoadTrustedIssuers: %v", err)
+	}
+	const as = "https://as.example"
+	assertFor := func(iss, sub, aud string) types.Assertion {
+		v, err := token.IssueAssertion(idp, token.AssertionConfig{Issuer: iss, Subject: sub, Audience: aud})
+		if err != nil {
+			t.Fatalf("IssueAssertion: %v", err)
+		}
+		return types.Assertion{Format: types.AssertionFormatJWT, Value: v}
+	}
+	assert := func(iss, sub string) types.Assertion { return assertFor(iss, sub, as) }
+	h := &GrantHandler{TrustedIssuers: trusted, Issuer: as}
+
+	tests := []struct {
+		name    string
+		user    *types.UserRequest
+		want    *types.UserHint
+		wantErr error
+	}{
+		{"no user", nil, nil, nil},
+		{"sub_id hint", &types.UserRequest{SubIDs: []types.SubID{{Format: "email", Email: "alice@example.com"}}},
+			&types.UserHint{Account: "alice@example.com"}, nil},
+		{"trusted assertion", &types.UserRequest{Assertions: []types.Assertion{assert("https://idp.example", "alice")}},
+			&types.UserHint{Account: "alice", Verified: true, Issuer: "https://idp.example"}, nil},
+		{"assertion for another AS", &types.UserRequest{Assertions: []types.Assertion{assertFor("https://idp.example", "alice", "https://other-as.example")}},
+			nil, gnap.ErrUnknownUser},
+		// The audience is the configured issuer, never a URL built from Host headers
+		{"assertion for a forged Host", &types.UserRequest{Assertions: []types.Assertion{assertFor("https://idp.example", "alice", "http://attacker.example")}},
+			nil, gnap.ErrUnknownUser},
+		{"untrusted assertion", &types.UserRequest{Assertions: []types.Assertion{assert("https://evil.example", "alice")}}, nil, gnap.ErrUnknownUser},
+		{"assertions disagree", &types.UserRequest{Assertions: []types.Assertion{
+			assert("https://idp.example", "alice"), assert("https://idp.example", "bob"),
+		}}, nil, gnap.ErrUnknownUser},
+		{"unsupported format", &types.UserRequest{Assertions: []types.Assertion{{Format: "saml2", Value: "x"}}}, nil, gnap.ErrUnsupportedAssertionFormat},
+		{"by reference", &types.UserRequest{Ref: "XUT2MFM1XBIKJKSDU8QM"}, nil, gnap.ErrUnknownUser},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := h.userHint(tt.user)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("userHint() error = %v, want %v", err, tt.wantErr)
+			}
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Fatalf("userHint() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGrantHandler_RegisteredClient(t *testing.T) {
+	ctx := context.Background()
+	clients, err := gnap.NewClientStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewClientStore: %v", err)
+	}
+	newKey := func() types.ClientKey {
+		pub, _, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("GenerateKey: %v", err)
+		}
+		return types.ClientKey{Proof: "httpsig", JWK: types.JWK{Kty: "OKP", Crv: "Ed25519", X: base64.RawURLEncoding.EncodeToString(pub)}}
+	}
+	key, other := newKey(), newKey()
+	thumb, _ := sign.KeyThumbprint(key)
+	rec, err := clients.RegisterClient(ctx, gnap.DefaultTenant, gnap.ClientRecord{Key: key, Thumbprint: thumb})
+	if err != nil {
+		t.Fatalf("RegisterClient: %v", err)
+	}
+	h := &GrantHandler{Clients: clients}
+
+	tests := []struct {
+		name           string
+		client         types.Client
+		wantRegistered bool
+		wantErr        error
+	}{
+		{"instance_id", types.Client{InstanceID: rec.InstanceID}, true, nil},
+		{"instance_id with its key", types.Client{InstanceID: rec.InstanceID, Key: key}, true, nil},
+		{"instance_id with another key", types.Client{InstanceID: rec.InstanceID, Key: other}, false, gnap.ErrInvalidClient},
+		{"unknown instance_id", types.Client{InstanceID: "nope"}, false, gnap.ErrInvalidClient},
+		{"registered key", types.Client{Key: key}, true, nil},
+		{"unregistered key", types.Client{Key: other}, false, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := tt.client
+			got, err := h.registeredClient(ctx, gnap.DefaultTenant, &client)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("registeredClient() error = %v, want %v", err, tt.wantErr)
+			}
+			if (got != nil) != tt.wantRegistered {
+				t.Fatalf("registeredClient() = %+v, want registered %v", got, tt.wantRegistered)
+			}
+			if tt.wantRegistered && (client.InstanceID != rec.InstanceID || client.Key != key) {
+				t.Fatalf("client = %+v, want the registered instance_id and key", client)
+			}
+		})
+	}
+}
+
+func TestGrantHandler_DefaultInteract(t *testing.T) {
+	userCode := &types.Interact{Start: []string{types.InteractStartUserCode}}
+	redirect := &types.Interact{Start: []string{types.InteractStartRedirect}}
+	verified := &types.UserHint{Account: "alice", Verified: true}
+
+	tests := []struct {
+		name       string
+		startModes []string
+		skip       bool
+		interact   *types.Interact
+		user       *types.UserHint
+		want       *types.Interact
+		wantErr    error
+	}{
+		{"no interact", defaultStartModes, false, nil, nil, userCode, nil},
+		{"empty start", defaultStartModes, false, &types.Interact{}, nil, userCode, nil},
+		{"requested mode kept", defaultStartModes, false, redirect, nil, redirect, nil},
+		{"asserted user needs none", defaultStartModes, true, nil, verified, nil, nil},
+		{"unverified user still interacts", defaultStartModes, true, nil, &types.UserHint{Account: "alice"}, userCode, nil},
+		{"user_code not offered", []string{types.InteractStartRedirect}, false, nil, nil, nil, gnap.ErrInvalidRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &GrantHandler{StartModes: tt.startModes, SkipInteraction: tt.skip}
+			req := types.GrantRequest{Interact: tt.interact}
+			err := h.defaultInteract(&req, tt.user)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("defaultInteract() error = %v, want %v", err, tt.wantErr)
+			}
+			if got, _ := json.Marshal(req.Interact); tt.wantErr == nil && string(got) != mustJSON(t, tt.want) {
+				t.Fatalf("interact = %s, want %s", got, mustJSON(t, tt.want))
+			}
+		})
+	}
+}
+
+func mustJSON(t *testing.T, v any) string {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	return string(b)
+}
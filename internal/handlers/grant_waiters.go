@@ -0,0 +1,56 @@
+package handlers
+
+import "sync"
+
+// GrantWaiters wakes long-polling continuation requests when their grant changes
+// state. Consent handlers call Notify after approving or denying a grant. A nil
+// *GrantWaiters never wakes anyone, so handlers work without long polling.
+type GrantWaiters struct {
+	mu      sync.Mutex
+	waiters map[string]map[chan struct{}]struct{}
+}
+
+func NewGrantWaiters() *GrantWaiters {
+	return &GrantWaiters{waiters: map[string]map[chan struct{}]struct{}{}}
+}
+
+// Wait returns a channel that is closed the next time grantID is notified, and a
+// func that stops waiting. Callers must call stop when done.
+func (gw *GrantWaiters) Wait(grantID string) (wake <-chan struct{}, stop func()) {
+	ch := make(chan struct{})
+	if gw == nil {
+		return ch, func() {}
+	}
+
+	gw.mu.Lock()
+	if gw.waiters[grantID] == nil {
+		gw.waiters[grantID] = map[chan struct{}]struct{}{}
+	}
+	gw.waiters[grantID][ch] = struct{}{}
+	gw.mu.Unlock()
+
+	return ch, func() {
+		gw.mu.Lock()
+		defer gw.mu.Unlock()
+		if _, ok := gw.waiters[grantID][ch]; ok {
+			delete(gw.waiters[grantID], ch)
+			if len(gw.waiters[grantID]) == 0 {
+				delete(gw.waiters, grantID)
+			}
+		}
+	}
+}
+
+// Notify wakes every request waiting on grantID.
+func (gw *GrantWaiters) Notify(grantID string) {
+	if gw == nil {
+		return
+	}
+
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	for ch := range gw.waiters[grantID] {
+		close(ch)
+	}
+	delete(gw.waiters, grantID)
+}
@@ -0,0 +1,39 @@
+package handlers
+
+import "testing"
+
+func TestGrantWaiters(t *testing.T) {
+	gw := NewGrantWaiters()
+	a, stopA := gw.Wait("g1")
+	b, stopB := gw.Wait("g1")
+	other, stopOther := gw.Wait("g2")
+	defer stopA()
+	defer stopOther()
+
+	stopB()
+	gw.Notify("g1")
+
+	select {
+	case <-a:
+	default:
+		t.Fatalf("waiter on g1 not woken")
+	}
+	select {
+	case <-b:
+		t.Fatalf("stopped waiter woken")
+	case <-other:
+		t.Fatalf("waiter on g2 woken by g1")
+	default:
+	}
+
+	// A nil GrantWaiters never wakes and never panics
+	var none *GrantWaiters
+	wake, stop := none.Wait("g1")
+	none.Notify("g1")
+	stop()
+	select {
+	case <-wake:
+		t.Fatalf("nil GrantWaiters woke a waiter")
+	default:
+	}
+}
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/TwigBush/gnap-go/internal/gnap"
+	"github.com/TwigBush/gnap-go/internal/httpx"
+	"github.com/TwigBush/gnap-go/internal/types"
+	"github.com/go-chi/chi/v5"
+)
+
+// InteractHandler serves the AS-side interaction page for the redirect start mode.
+type InteractHandler struct {
+	Store   types.GrantStore
+	Pusher  *InteractPusher
+	Waiters *GrantWaiters // woken on consent; nil disables
+}
+
+func NewInteractHandler(store types.GrantStore) *InteractHandler {
+	return &InteractHandler{Store: store, Pusher: NewInteractPusher(store)}
+}
+
+// ---------- HTML page: GET /interact/{interactId} → consent screen ----------
+
+func (h *InteractHandler) Page(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	interactID := chi.URLParam(r, "interactId")
+	grant, ok := h.pendingGrant(r, interactID)
+	if !ok {
+		deviceError(w, "This request has expired or was already handled.")
+		return
+	}
+
+	consentScreen(w, grant, "/interact/"+interactID, "")
+}
+
+// ---------- HTML consent: POST /interact/{interactId} (form-urlencoded) → finish ----------
+
+func (h *InteractHandler) Consent(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	if err := r.ParseForm(); err != nil {
+		deviceError(w, "Invalid form submission.")
+		return
+	}
+
+	// The grant comes from the interaction URI, never from the form
+	grant, ok := h.pendingGrant(r, chi.URLParam(r, "interactId"))
+	if !ok {
+		deviceError(w, "This request has expired or was already handled.")
+		return
+	}
+
+	// Reaching the interaction URI stands in for entering a user code
+	if err := h.Store.MarkCodeVerified(r.Context(), grant.ID); err != nil {
+		deviceError(w, httpx.SafeErrMsg(err))
+		return
+	}
+
+	switch r.Form.Get("decision") {
+	case "approve":
+		subject, share := consentSubject(r, grant, "user:interact")
+		if _, err := h.Store.ApproveGrant(r.Context(), grant.ID, grant.ApprovedAccess, subject, share); err != nil {
+			deviceError(w, httpx.SafeErrMsg(err))
+			return
+		}
+		h.Waiters.Notify(grant.ID)
+		if finishInteraction(w, r, h.Store, h.Pusher, grant) {
+			return
+		}
+		deviceSuccess(w)
+	default:
+		if _, err := h.Store.DenyGrant(r.Context(), grant.ID); err != nil {
+			deviceError(w, httpx.SafeErrMsg(err))
+			return
+		}
+		h.Waiters.Notify(grant.ID)
+		if finishInteraction(w, r, h.Store, h.Pusher, grant) {
+			return
+		}
+		deviceDenied(w)
+	}
+}
+
+func (h *InteractHandler) pendingGrant(r *http.Request, interactID string) (*types.GrantState, bool) {
+	grant, ok := h.Store.FindGrantByInteractID(r.Context(), interactID)
+	if !ok || grant == nil {
+		return nil, false
+	}
+	// Re-read through GetGrant so expiry is applied
+	grant, ok = h.Store.GetGrant(r.Context(), grant.ID)
+	if !ok || grant.Status != types.GrantStatusPending {
+		return nil, false
+	}
+	return grant, true
+}
+
+// finishInteraction completes the client's requested finish method once the user has
+// decided: a redirect back to the client (RFC 9635 §4.2.1), or a push to its URI in the
+// background (§4.2.2). It reports whether a response was written.
+func finishInteraction(w http.ResponseWriter, r *http.Request, store types.GrantStore, pusher *InteractPusher, g *types.GrantState) bool {
+	method := g.Interact.FinishMethod()
+	if method != types.InteractFinishRedirect && method != types.InteractFinishPush {
+		return false
+	}
+
+	g, err := store.FinishInteraction(r.Context(), g.ID)
+	if err != nil {
+		deviceError(w, httpx.SafeErrMsg(err))
+		return true
+	}
+
+	finish := g.Interact.Finish
+	hash, err := gnap.InteractHash(finish.HashMethod, finish.Nonce, g.InteractNonce, g.InteractRef, httpx.BaseURL(r)+"/grants")
+	if err != nil {
+		deviceError(w, httpx.SafeErrMsg(err))
+		return true
+	}
+
+	if method == types.InteractFinishPush {
+		// The user gets the normal result page; delivery outlives this request
+		go func(grantID, uri, ref string) {
+			if err := pusher.Deliver(context.Background(), grantID, uri, hash, ref); err != nil {
+				log.Printf("interact push: grant %s not delivered: %v", grantID, err)
+			}
+		}(g.ID, finish.URI, g.InteractRef)
+		return false
+	}
+
+	u, err := url.Parse(finish.URI)
+	if err != nil {
+		log.Printf("interact: bad finish uri for grant %s: %v", g.ID, err)
+		deviceError(w, "The application's return address is invalid.")
+		return true
+	}
+	q := u.Query()
+	q.Set("hash", hash)
+	q.Set("interact_ref", g.InteractRef)
+	u.RawQuery = q.Encode()
+
+	http.Redirect(w, r, u.String(), http.StatusSeeOther)
+	return true
+}
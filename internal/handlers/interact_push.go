@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+
+	"github.com/TwigBush/gnap-go/internal/gnap"
+	"github.com/TwigBush/gnap-go/internal/types"
+)
+
+// InteractPusher delivers push interaction finishes (RFC 9635 §4.2.2): once the user is
+// done, the AS POSTs {hash, interact_ref} to the client's finish URI so it can continue
+// without polling. Each attempt is recorded on the grant. The default Client only
+// connects to public addresses, checked after DNS resolution, and does not follow
+// redirects, so a finish URI cannot reach into the AS's own network.
+type InteractPusher struct {
+	Store       types.GrantStore
+	Client      *http.Client
+	MaxAttempts int
+	Backoff     time.Duration // wait before the first retry, doubled after each one
+}
+
+func NewInteractPusher(store types.GrantStore) *InteractPusher {
+	return &InteractPusher{
+		Store:       store,
+		Client:      newPushClient(),
+		MaxAttempts: 5,
+		Backoff:     time.Second,
+	}
+}
+
+func newPushClient() *http.Client {
+	dialer := &net.Dialer{Timeout: 5 * time.Second, Control: dialPublicOnly}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil // a proxy would resolve and connect on the AS's behalf
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+var errNonPublicAddr = errors.New("push finish to a non-public address refused")
+
+// dialPublicOnly refuses connections to addresses that are not public, whatever name
+// resolved to them.
+func dialPublicOnly(network, address string, _ syscall.RawConn) error {
+	ap, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if !gnap.PublicAddr(ap.Addr()) {
+		return fmt.Errorf("%w: %s", errNonPublicAddr, ap.Addr())
+	}
+	return nil
+}
+
+type pushFinish struct {
+	Hash        string `json:"hash"`
+	InteractRef string `json:"interact_ref"`
+}
+
+// Deliver posts the finish message to uri until the client accepts it, the client
+// rejects it outright, or attempts run out.
+func (p *InteractPusher) Deliver(ctx context.Context, grantID, uri, hash, interactRef string) error {
+	body, err := json.Marshal(pushFinish{Hash: hash, InteractRef: interactRef})
+	if err != nil {
+		return err
+	}
+
+	delay := p.Backoff
+	for attempt := 1; ; attempt++ {
+		status, err := p.post(ctx, uri, body)
+		if err == nil && (status < 200 || status > 299) {
+			err = fmt.Errorf("client returned HTTP %d", status)
+		}
+
+		d := types.PushDelivery{Attempt: attempt, At: time.Now().UTC(), Status: status, Delivered: err == nil}
+		if err != nil {
+			d.Error = err.Error()
+		}
+		if rerr := p.Store.RecordPushDelivery(ctx, grantID, d); rerr != nil {
+			log.Printf("interact push: record delivery for grant %s: %v", grantID, rerr)
+		}
+
+		if err == nil {
+			return nil
+		}
+		// Other 4xx responses will not get better by retrying
+		retryable := status == 0 && !errors.Is(err, errNonPublicAddr) || status >= 500 || status == http.StatusTooManyRequests || status == http.StatusRequestTimeout
+		if !retryable || attempt >= p.MaxAttempts {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+func (p *InteractPusher) post(ctx context.Context, uri string, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, uri, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode, nil
+}
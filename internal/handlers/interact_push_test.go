@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/TwigBush/gnap-go/internal/gnap"
+	"github.com/TwigBush/gnap-go/internal/types"
+)
+
+func TestInteractPusher_Deliver(t *testing.T) {
+	tests := []struct {
+		name          string
+		statuses      []int // client responses, in order; the last one repeats
+		wantErr       bool
+		wantAttempts  int
+		wantDelivered bool
+	}{
+		{"first try", []int{http.StatusNoContent}, false, 1, true},
+		{"retries server errors", []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusOK}, false, 3, true},
+		{"gives up after max attempts", []int{http.StatusInternalServerError}, true, 3, false},
+		{"no retry on client error", []int{http.StatusNotFound}, true, 1, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			client := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var msg pushFinish
+				if err := json.NewDecoder(r.Body).Decode(&msg); err != nil || msg.Hash != "h" || msg.InteractRef != "ref" {
+					t.Errorf("unexpected push body: %+v, %v", msg, err)
+				}
+				n := int(calls.Add(1)) - 1
+				if n >= len(tt.statuses) {
+					n = len(tt.statuses) - 1
+				}
+				w.WriteHeader(tt.statuses[n])
+			}))
+			defer client.Close()
+
+			store, err := gnap.NewFileStore(t.TempDir(), types.Config{GrantTTLSeconds: 60})
+			if err != nil {
+				t.Fatalf("NewFileStore: %v", err)
+			}
+			g, err := store.CreateGrant(context.Background(), gnap.DefaultTenant, types.GrantRequest{}, nil)
+			if err != nil {
+				t.Fatalf("CreateGrant: %v", err)
+			}
+
+			p := NewInteractPusher(store)
+			p.Client = client.Client() // the test client listens on loopback
+			p.MaxAttempts = 3
+			p.Backoff = 0
+
+			err = p.Deliver(context.Background(), g.ID, client.URL, "h", "ref")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Deliver() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			g, _ = store.GetGrant(context.Background(), g.ID)
+			if len(g.PushDeliveries) != tt.wantAttempts {
+				t.Fatalf("recorded %d deliveries, want %d", len(g.PushDeliveries), tt.wantAttempts)
+			}
+			if last := g.PushDeliveries[len(g.PushDeliveries)-1]; last.Delivered != tt.wantDelivered {
+				t.Fatalf("last delivery = %+v, want delivered %v", last, tt.wantDelivered)
+			}
+		})
+	}
+}
+
+func TestInteractPusher_RefusesNonPublicAddresses(t *testing.T) {
+	var calls atomic.Int32
+	client := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+	}))
+	defer client.Close()
+
+	store, err := gnap.NewFileStore(t.TempDir(), types.Config{GrantTTLSeconds: 60})
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	g, err := store.CreateGrant(context.Background(), gnap.DefaultTenant, types.GrantRequest{}, nil)
+	if err != nil {
+		t.Fatalf("CreateGrant: %v", err)
+	}
+
+	p := NewInteractPusher(store)
+	p.Backoff = 0
+	// A name that passes the grant's checks can still resolve to loopback
+	_, port, _ := net.SplitHostPort(client.Listener.Addr().String())
+	if err := p.Deliver(context.Background(), g.ID, "https://localhost:"+port+"/push", "h", "ref"); !errors.Is(err, errNonPublicAddr) {
+		t.Fatalf("Deliver() error = %v, want %v", err, errNonPublicAddr)
+	}
+	if calls.Load() != 0 {
+		t.Fatalf("client was called %d times", calls.Load())
+	}
+	g, _ = store.GetGrant(context.Background(), g.ID)
+	if len(g.PushDeliveries) != 1 {
+		t.Fatalf("recorded %d deliveries, want 1 without retries", len(g.PushDeliveries))
+	}
+}
@@ -5,11 +5,13 @@ import (
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
+	"log"
 	"net/http"
 	"time"
 
 	"github.com/TwigBush/gnap-go/internal/gnap"
 	mw2 "github.com/TwigBush/gnap-go/internal/mw"
+	"github.com/TwigBush/gnap-go/internal/token"
 	"github.com/TwigBush/gnap-go/internal/types"
 )
 
@@ -51,7 +53,8 @@ type RSRegistry interface {
 type IntrospectionHandler struct {
 	Store      *gnap.TokenStoreContainer
 	RSRegistry RSRegistry
-	ASGrantURL string // iss to return, for example: https://as.example.com/tx
+	ASGrantURL string                 // iss to return, for example: https://as.example.com/tx
+	Pairwise   *gnap.PairwiseSubjects // returns sub pairwise per RS; nil returns the account
 }
 
 func NewIntrospectionHandler(store *gnap.TokenStoreContainer) *IntrospectionHandler {
@@ -143,25 +146,40 @@ func (h *IntrospectionHandler) Introspect(w http.ResponseWriter, r *http.Request
 		Active:     true,
 		Iss:        tr.Iss,
 		Access:     filtered, // required, may be empty
-		Flags:      nil,      // todo (joshfischer) do we want to include tracking flags
+		Flags:      tr.Flags,
 		Exp:        tr.Exp,
 		Iat:        tr.Iat,
 		Nbf:        tr.Nbf,
 		Aud:        tr.Aud, // array form
-		Sub:        tr.Sub,
+		Sub:        h.subject(tr, rsID),
 		InstanceID: tr.InstanceID,
 	}
 	if tr.BoundKey != nil {
 		resp.Key = &gnap.BoundKey{
-			Proof: tr.BoundKey.Proof,
-			JWK:   tr.BoundKey.JWK,
-			Ref:   tr.BoundKey.Ref,
+			Proof:    tr.BoundKey.Proof,
+			JWK:      tr.BoundKey.JWK,
+			CertS256: tr.BoundKey.CertS256,
+			Ref:      tr.BoundKey.Ref,
 		}
 	}
 
 	writeJSON(w, http.StatusOK, resp)
 }
 
+// subject is the token's sub as rsID sees it: a pairwise id, so RSs cannot correlate
+// users across each other. On failure sub is left out rather than leaking the account.
+func (h *IntrospectionHandler) subject(tr *gnap.TokenRecord, rsID string) string {
+	if h.Pairwise == nil || tr.Sub == "" || tr.Sub == token.AnonymousSubject {
+		return tr.Sub
+	}
+	sub, err := h.Pairwise.ID(gnap.TenantOrDefault(tr.Tenant), tr.Sub, gnap.RSSector(rsID))
+	if err != nil {
+		log.Printf("introspect: pairwise sub for %s: %v", rsID, err)
+		return ""
+	}
+	return sub
+}
+
 func audAllows(aud []string, rsID string) bool {
 	if len(aud) == 0 {
 		return true
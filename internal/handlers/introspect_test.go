@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/TwigBush/gnap-go/internal/gnap"
+	"github.com/TwigBush/gnap-go/internal/token"
+)
+
+func TestIntrospectionHandler_Subject(t *testing.T) {
+	pairwise, err := gnap.NewPairwiseSubjects(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewPairwiseSubjects: %v", err)
+	}
+	h := &IntrospectionHandler{Pairwise: pairwise}
+	tr := &gnap.TokenRecord{Sub: "user:device", Tenant: "acme"}
+
+	photos, calendar := h.subject(tr, "photos"), h.subject(tr, "calendar")
+	if photos == "" || photos == tr.Sub || photos == calendar {
+		t.Fatalf("subject() = %q and %q, want distinct pairwise ids", photos, calendar)
+	}
+	if rec, err := pairwise.Lookup("acme", photos); err != nil || rec.Account != tr.Sub || rec.Sector != gnap.RSSector("photos") {
+		t.Fatalf("Lookup() = %+v, %v", rec, err)
+	}
+
+	if got := h.subject(&gnap.TokenRecord{Sub: token.AnonymousSubject}, "photos"); got != token.AnonymousSubject {
+		t.Fatalf("anonymous subject = %q", got)
+	}
+	if got := (&IntrospectionHandler{}).subject(tr, "photos"); got != tr.Sub {
+		t.Fatalf("subject() without pairwise = %q, want %q", got, tr.Sub)
+	}
+}
@@ -13,6 +13,7 @@ func JWKSOriginal(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNotImplemented)
 }
 
-func JWKS(w http.ResponseWriter, r *http.Request) {
-	jwks.Serve(w, r)
+// JWKS publishes the AS signing keys.
+func JWKS(keys *jwks.KeySet) http.HandlerFunc {
+	return keys.ServeHTTP
 }
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/TwigBush/gnap-go/internal/gnap"
+	"github.com/TwigBush/gnap-go/internal/httpx"
+	mw2 "github.com/TwigBush/gnap-go/internal/mw"
+	"github.com/TwigBush/gnap-go/internal/types"
+)
+
+// Resource set registration request and response (RFC 9767 §3.4)
+type registerResourceSetReq struct {
+	Access         []types.AccessItem `json:"access"`
+	ResourceServer json.RawMessage    `json:"resource_server"`
+}
+
+type registerResourceSetResp struct {
+	ResourceReference string `json:"resource_reference"`
+}
+
+// ResourceSetHandler lets an RS register access it protects, so clients can ask for
+// that access by the reference the AS returns.
+type ResourceSetHandler struct {
+	Definitions *gnap.AccessDefinitions
+
+	// RSKeys, when set, limits an RS to registering in the tenants its key is
+	// registered in.
+	RSKeys *gnap.RSKeyStore
+}
+
+func NewResourceSetHandler(defs *gnap.AccessDefinitions) *ResourceSetHandler {
+	return &ResourceSetHandler{Definitions: defs}
+}
+
+// POST /register
+func (h *ResourceSetHandler) Register(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	rs, ok := mw2.RSIdentityFromContext(r)
+	if !ok || rs.ID == "" {
+		httpx.WriteError(w, http.StatusUnauthorized, "resource server not authenticated")
+		return
+	}
+
+	tenant := r.Header.Get(gnap.TenantHeader)
+	if tenant == "" {
+		tenant = gnap.DefaultTenant
+	}
+	if !gnap.ValidTenant(tenant) {
+		httpx.WriteGNAPError(w, gnap.NewError(gnap.CodeInvalidRequest, "invalid tenant"))
+		return
+	}
+	if h.RSKeys != nil {
+		if _, err := h.RSKeys.LookupRSPublicKeyByTenant(tenant, rs.KeyID); err != nil {
+			httpx.WriteError(w, http.StatusForbidden, "resource server is not registered in this tenant")
+			return
+		}
+	}
+
+	var in registerResourceSetReq
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		httpx.WriteGNAPError(w, gnap.NewError(gnap.CodeInvalidRequest, "invalid JSON"))
+		return
+	}
+	if len(in.ResourceServer) == 0 {
+		httpx.WriteGNAPError(w, gnap.NewError(gnap.CodeInvalidRequest, "missing resource_server"))
+		return
+	}
+
+	def, err := h.Definitions.RegisterResourceSet(r.Context(), tenant, rs.ID, in.Access)
+	if errors.Is(err, gnap.ErrInvalidAccessDefinition) {
+		httpx.WriteGNAPError(w, gnap.WrapError(gnap.CodeInvalidRequest, err))
+		return
+	}
+	if err != nil {
+		log.Printf("register resource set for %s: %v", rs.ID, err)
+		httpx.WriteError(w, http.StatusInternalServerError, "could not register resource set")
+		return
+	}
+	httpx.WriteJSON(w, http.StatusOK, registerResourceSetResp{ResourceReference: def.Name})
+}
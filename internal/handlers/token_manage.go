@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/TwigBush/gnap-go/internal/gnap"
+	"github.com/TwigBush/gnap-go/internal/httpx"
+	"github.com/TwigBush/gnap-go/internal/sign"
+	"github.com/TwigBush/gnap-go/internal/token"
+	"github.com/TwigBush/gnap-go/internal/types"
+	"github.com/go-chi/chi/v5"
+)
+
+// TokenManageHandler serves token management URIs (RFC 9635 §6): POST rotates the
+// access token, or with a new key rotates the client's key too, and DELETE revokes it.
+// Calls carry the token management access token and must be signed with the key the
+// access token is bound to.
+type TokenManageHandler struct {
+	Store       types.GrantStore
+	TokenStore  *gnap.TokenStoreContainer
+	Clients     *gnap.ClientStore // registered clients follow their key rotations; nil without a registry
+	KeyRotation bool              // accept client key rotation (RFC 9635 §6.1.1)
+}
+
+// rotateRequest is the body of a token rotation request. A key asks for the token to
+// be bound to that key from now on.
+type rotateRequest struct {
+	Key *types.ClientKey `json:"key,omitempty"`
+}
+
+var (
+	errKeyRotationNotSupported = gnap.NewError(gnap.CodeKeyRotationNotSupported, "key rotation is only supported for httpsig keys")
+	errInvalidRotationProof    = gnap.NewError(gnap.CodeInvalidRotation, "key rotation must be signed with the current and the new key")
+)
+
+func NewTokenManageHandler(store types.GrantStore, tokenStore *gnap.TokenStoreContainer) *TokenManageHandler {
+	return &TokenManageHandler{Store: store, TokenStore: tokenStore}
+}
+
+// ---------- POST /token/{manageId} → rotated access token ----------
+
+func (h *TokenManageHandler) Rotate(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	rec, key, body, ok := h.resolve(w, r)
+	if !ok {
+		return
+	}
+	var req rotateRequest
+	if payload, err := sign.RequestPayload(r, body); err != nil || (len(payload) > 0 && json.Unmarshal(payload, &req) != nil) {
+		httpx.WriteGNAPError(w, gnap.NewError(gnap.CodeInvalidRequest, "invalid JSON"))
+		return
+	}
+	if req.Key != nil {
+		h.rotateKey(w, r, rec, key, *req.Key, body)
+		return
+	}
+	if !verifyManageProof(w, r, body, key) {
+		return
+	}
+
+	tok, err := token.RotateToken(r.Context(), h.TokenStore, rec)
+	if err != nil {
+		httpx.WriteGNAPError(w, err)
+		return
+	}
+	httpx.WriteJSON(w, http.StatusOK, map[string]any{"access_token": tok})
+}
+
+// ---------- DELETE /token/{manageId} → 204 ----------
+
+func (h *TokenManageHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	rec, ok := h.authorize(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.TokenStore.Revoke(r.Context(), rec.HashB64); err != nil {
+		httpx.WriteError(w, http.StatusInternalServerError, httpx.SafeErrMsg(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// rotateKey moves the client to newKey (RFC 9635 §6.1.1): the grant, the client's
+// registration and the grant's live tokens are bound to newKey, so continuation and
+// token requests must be signed with it, and the managed token is rotated under it.
+func (h *TokenManageHandler) rotateKey(w http.ResponseWriter, r *http.Request, rec *gnap.TokenRecord, oldKey, newKey types.ClientKey, body []byte) {
+	ctx := r.Context()
+	if !h.KeyRotation || oldKey.Proof != sign.ProofHTTPSig {
+		httpx.WriteGNAPError(w, errKeyRotationNotSupported)
+		return
+	}
+	if newKey.Proof != oldKey.Proof {
+		httpx.WriteGNAPError(w, gnap.NewError(gnap.CodeInvalidRotation, "new key must use the same proof method"))
+		return
+	}
+	oldThumb, _ := sign.KeyThumbprint(oldKey)
+	newThumb, err := sign.KeyThumbprint(newKey)
+	if err != nil || newThumb == oldThumb {
+		httpx.WriteGNAPError(w, gnap.NewError(gnap.CodeInvalidRotation, "key must be a new, valid key"))
+		return
+	}
+	if err := sign.VerifyRotationProof(r, body, oldKey, newKey); err != nil {
+		log.Printf("token manage: key rotation proof rejected: %v", err)
+		httpx.WriteGNAPError(w, errInvalidRotationProof)
+		return
+	}
+
+	grant, err := h.Store.RotateClientKey(ctx, rec.InstanceID, oldKey, newKey)
+	if err != nil {
+		httpx.WriteGNAPError(w, err)
+		return
+	}
+	if err := h.rotateRegisteredKey(r, grant, oldThumb, newThumb); err != nil {
+		httpx.WriteGNAPError(w, err)
+		return
+	}
+	proof, jwk, certS256 := clientKeyBinding(newKey)
+	if _, err := h.TokenStore.RebindInstanceID(ctx, grant.ID, gnap.BoundKey{Proof: proof, JWK: jwk, CertS256: certS256}, rec.HashB64); err != nil {
+		httpx.WriteError(w, http.StatusInternalServerError, httpx.SafeErrMsg(err))
+		return
+	}
+
+	// The managed token was rebound with any durable ones; rotate its value under the new key
+	rebound, err := h.TokenStore.GetByHash(ctx, rec.HashB64)
+	if err != nil || rebound == nil {
+		httpx.WriteError(w, http.StatusInternalServerError, "token not found after rotation")
+		return
+	}
+	tok, err := token.RotateToken(ctx, h.TokenStore, rebound)
+	if err != nil {
+		httpx.WriteGNAPError(w, err)
+		return
+	}
+	httpx.WriteJSON(w, http.StatusOK, map[string]any{"access_token": tok})
+}
+
+// rotateRegisteredKey moves a pre-registered client's registration to its new key, so
+// it is recognized by that key from now on.
+func (h *TokenManageHandler) rotateRegisteredKey(r *http.Request, grant *types.GrantState, oldThumb, newThumb string) error {
+	if h.Clients == nil || grant.Client.InstanceID == "" {
+		return nil
+	}
+	tenant := gnap.TenantOrDefault(grant.Tenant)
+	client, ok := h.Clients.GetClient(r.Context(), tenant, grant.Client.InstanceID)
+	if !ok || client.Thumbprint != oldThumb {
+		return nil
+	}
+	client.Key, client.Thumbprint = grant.Client.Key, newThumb
+	_, err := h.Clients.UpdateClient(r.Context(), tenant, client)
+	if errors.Is(err, gnap.ErrClientKeyInUse) {
+		return gnap.NewError(gnap.CodeInvalidRotation, "key is registered to another client")
+	}
+	return err
+}
+
+// authorize resolves the managed token and checks the management access token and key
+// proof. On failure it writes the error response and returns ok=false.
+func (h *TokenManageHandler) authorize(w http.ResponseWriter, r *http.Request) (*gnap.TokenRecord, bool) {
+	rec, key, body, ok := h.resolve(w, r)
+	if !ok || !verifyManageProof(w, r, body, key) {
+		return nil, false
+	}
+	return rec, true
+}
+
+// resolve finds the managed token, checks the management access token and reads the
+// body, returning the key the request must be signed with. On failure it writes the
+// error response and returns ok=false.
+func (h *TokenManageHandler) resolve(w http.ResponseWriter, r *http.Request) (*gnap.TokenRecord, types.ClientKey, []byte, bool) {
+	manageToken, ok := httpx.ExtractGNAPToken(r.Header.Get("Authorization"))
+	if !ok || manageToken == "" {
+		httpx.WriteGNAPError(w, gnap.NewError(gnap.CodeInvalidRequest, "missing token management access token"))
+		return nil, types.ClientKey{}, nil, false
+	}
+
+	rec, err := h.TokenStore.GetByManageID(r.Context(), chi.URLParam(r, "manageId"))
+	if err != nil || rec == nil {
+		httpx.WriteGNAPError(w, gnap.NewError(gnap.CodeInvalidRequest, "token not found"))
+		return nil, types.ClientKey{}, nil, false
+	}
+	if subtle.ConstantTimeCompare([]byte(token.Hash(manageToken)), []byte(rec.ManageTokenHash)) != 1 {
+		httpx.WriteGNAPError(w, gnap.NewError(gnap.CodeInvalidClient, "invalid token management access token"))
+		return nil, types.ClientKey{}, nil, false
+	}
+
+	key, err := h.proofKey(r, rec)
+	if err != nil {
+		log.Printf("token manage: no key for token of grant %s: %v", rec.InstanceID, err)
+		httpx.WriteGNAPError(w, errInvalidKeyProof)
+		return nil, types.ClientKey{}, nil, false
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestBytes))
+	if err != nil {
+		httpx.WriteGNAPError(w, gnap.NewError(gnap.CodeInvalidRequest, "invalid body"))
+		return nil, types.ClientKey{}, nil, false
+	}
+	return rec, key, body, true
+}
+
+// verifyManageProof checks that a management request was signed with key. On failure
+// it writes the error response and returns false.
+func verifyManageProof(w http.ResponseWriter, r *http.Request, body []byte, key types.ClientKey) bool {
+	if err := sign.VerifyRequestProof(r, body, key); err != nil {
+		log.Printf("token manage: key proof rejected: %v", err)
+		httpx.WriteGNAPError(w, errInvalidKeyProof)
+		return false
+	}
+	return true
+}
+
+// proofKey is the key management requests must be signed with: the key the token is
+// bound to, or for bearer tokens the key of the client the grant was issued to.
+func (h *TokenManageHandler) proofKey(r *http.Request, rec *gnap.TokenRecord) (types.ClientKey, error) {
+	if rec.BoundKey != nil {
+		key := types.ClientKey{Proof: rec.BoundKey.Proof, CertS256: rec.BoundKey.CertS256}
+		if len(rec.BoundKey.JWK) > 0 {
+			if err := json.Unmarshal(rec.BoundKey.JWK, &key.JWK); err != nil {
+				return types.ClientKey{}, err
+			}
+		}
+		return key, nil
+	}
+
+	grant, ok := h.Store.GetGrant(r.Context(), rec.InstanceID)
+	if !ok || grant == nil {
+		return types.ClientKey{}, gnap.Err("grant not found")
+	}
+	return grant.Client.Key, nil
+}
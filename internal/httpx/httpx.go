@@ -3,6 +3,8 @@ package httpx
 import (
 	"encoding/json"
 	"net/http"
+
+	"github.com/TwigBush/gnap-go/internal/gnap"
 )
 
 type APIError struct {
@@ -18,3 +20,12 @@ func WriteJSON(w http.ResponseWriter, code int, v any) {
 func WriteError(w http.ResponseWriter, code int, msg string) {
 	WriteJSON(w, code, APIError{Error: msg})
 }
+
+// WriteGNAPError writes err as a GNAP error response (RFC 9635 §3.6) with the HTTP
+// status of its code. Errors outside the catalog are reported as invalid_request.
+func WriteGNAPError(w http.ResponseWriter, err error) {
+	ge := gnap.AsError(err)
+	WriteJSON(w, ge.Status(), struct {
+		Error *gnap.Error `json:"error"`
+	}{ge})
+}
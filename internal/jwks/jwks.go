@@ -1,12 +1,150 @@
 package jwks
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
 	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/lestrrat-go/jwx/v3/jwa"
+	"github.com/lestrrat-go/jwx/v3/jwk"
+	"github.com/lestrrat-go/jwx/v3/jws"
 )
 
-func Serve(w http.ResponseWriter, r *http.Request) {
-	_ = json.NewEncoder(w).Encode(map[string]any{
-		"keys": []any{},
-	})
+// KeySet holds the key the AS signs JWTs with, and the public JWK set verifiers
+// fetch from /.well-known/jwks.json.
+type KeySet struct {
+	signing jwk.Key
+	public  jwk.Set
+}
+
+const signingKeyFile = "signing.jwk"
+
+// Load reads the AS signing key from <dataDir>/as_keys, creating an ES256 key on
+// first use. The kid is the key's RFC 7638 thumbprint.
+func Load(dataDir string) (*KeySet, error) {
+	dir := filepath.Join(dataDir, "as_keys")
+	path := filepath.Join(dir, signingKeyFile)
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		b, err = newSigningKey()
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return nil, fmt.Errorf("create as_keys dir: %w", err)
+		}
+		if err := os.WriteFile(path, b, 0o600); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	signing, err := jwk.ParseKey(b)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	pub, err := jwk.PublicKeyOf(signing)
+	if err != nil {
+		return nil, err
+	}
+	public := jwk.NewSet()
+	if err := public.AddKey(pub); err != nil {
+		return nil, err
+	}
+	return &KeySet{signing: signing, public: public}, nil
+}
+
+func newSigningKey() ([]byte, error) {
+	raw, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	key, err := jwk.Import(raw)
+	if err != nil {
+		return nil, err
+	}
+	if err := jwk.AssignKeyID(key); err != nil {
+		return nil, err
+	}
+	if err := key.Set(jwk.AlgorithmKey, jwa.ES256()); err != nil {
+		return nil, err
+	}
+	if err := key.Set(jwk.KeyUsageKey, jwk.ForSignature); err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(key, "", "  ")
+}
+
+// Claims are the JWT claims (RFC 7519 §4.1) of the identity assertions the AS signs
+// and verifies. Times are seconds since the epoch.
+type Claims struct {
+	Issuer    string   `json:"iss,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	Audience  Audience `json:"aud,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	Expiry    int64    `json:"exp,omitempty"`
+	ID        string   `json:"jti,omitempty"`
+}
+
+// Audience is the aud claim, which may be a single string or an array of them.
+type Audience []string
+
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*a = Audience{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return fmt.Errorf("aud must be a string or an array of strings")
+	}
+	*a = many
+	return nil
+}
+
+// Sign signs claims as a JWT with the AS signing key. The JWS header carries its kid.
+func (k *KeySet) Sign(claims Claims) ([]byte, error) {
+	alg, ok := k.signing.Algorithm()
+	if !ok {
+		return nil, errors.New("signing key has no alg")
+	}
+	sigAlg, ok := alg.(jwa.SignatureAlgorithm)
+	if !ok {
+		return nil, fmt.Errorf("signing key alg %s cannot sign", alg)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return nil, err
+	}
+	hdr := jws.NewHeaders()
+	if err := hdr.Set(jws.TypeKey, "JWT"); err != nil {
+		return nil, err
+	}
+	return jws.Sign(payload, jws.WithKey(sigAlg, k.signing, jws.WithProtectedHeaders(hdr)))
+}
+
+// Public is the set of public keys verifiers should trust.
+func (k *KeySet) Public() jwk.Set { return k.public }
+
+// ServeHTTP publishes the public keys. A nil KeySet publishes an empty set.
+func (k *KeySet) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/jwk-set+json")
+	if k == nil {
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"keys": []any{},
+		})
+		return
+	}
+	_ = json.NewEncoder(w).Encode(k.public)
 }
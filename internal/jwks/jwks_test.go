@@ -0,0 +1,36 @@
+package jwks
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	keys, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	reloaded, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	k1, _ := keys.Public().Key(0)
+	k2, _ := reloaded.Public().Key(0)
+	kid1, _ := k1.KeyID()
+	kid2, _ := k2.KeyID()
+	if kid1 == "" || kid1 != kid2 {
+		t.Fatalf("kid after reload = %q, want %q", kid2, kid1)
+	}
+}
+
+func TestKeySet_ServeHTTP_Nil(t *testing.T) {
+	var keys *KeySet
+	rec := httptest.NewRecorder()
+	keys.ServeHTTP(rec, httptest.NewRequest("GET", "/.well-known/jwks.json", nil))
+	if got := strings.TrimSpace(rec.Body.String()); got != `{"keys":[]}` {
+		t.Fatalf("body = %s, want an empty key set", got)
+	}
+}
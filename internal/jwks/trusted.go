@@ -0,0 +1,160 @@
+package jwks
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v3/jwk"
+	"github.com/lestrrat-go/jwx/v3/jws"
+)
+
+var (
+	ErrUntrustedIssuer  = errors.New("assertion issuer is not trusted")
+	ErrInvalidAssertion = errors.New("invalid assertion")
+)
+
+// MaxAssertionAge bounds how long after it was issued an assertion is accepted.
+const MaxAssertionAge = 5 * time.Minute
+
+// TrustedIssuers verifies identity assertions made by other issuers, using each
+// issuer's JWK set from a local file. A nil *TrustedIssuers trusts no one.
+type TrustedIssuers struct {
+	sets map[string]jwk.Set // issuer -> keys
+
+	mu   sync.Mutex
+	seen map[string]time.Time // issuer and jti of accepted assertions -> when to forget them
+}
+
+// LoadTrustedIssuers reads the JWK set file of each issuer in files (issuer -> path).
+func LoadTrustedIssuers(files map[string]string) (*TrustedIssuers, error) {
+	t := &TrustedIssuers{sets: map[string]jwk.Set{}, seen: map[string]time.Time{}}
+	for iss, path := range files {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("trusted issuer %s: %w", iss, err)
+		}
+		set, err := jwk.Parse(b)
+		if err != nil {
+			return nil, fmt.Errorf("trusted issuer %s: parse %s: %w", iss, path, err)
+		}
+		t.sets[iss] = set
+	}
+	return t, nil
+}
+
+// ParseTrustedIssuers parses a list of issuer=path pairs separated by commas, the form
+// used in configuration.
+func ParseTrustedIssuers(spec string) (map[string]string, error) {
+	files := map[string]string{}
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		iss, path, ok := strings.Cut(pair, "=")
+		if !ok || iss == "" || path == "" {
+			return nil, fmt.Errorf("trusted issuer %q: want issuer=path", pair)
+		}
+		files[iss] = path
+	}
+	return files, nil
+}
+
+// Verify checks a JWT assertion's signature against its issuer's keys, that it is
+// meant for audience and currently valid, and returns the issuer and the subject it
+// asserts. An assertion must carry iat and jti: it is accepted only within
+// MaxAssertionAge of being issued, and only once. Without an audience nothing is
+// accepted.
+func (t *TrustedIssuers) Verify(assertion, audience string) (issuer, subject string, err error) {
+	if audience == "" {
+		return "", "", fmt.Errorf("%w: no audience to check", ErrInvalidAssertion)
+	}
+	// The issuer picks the keys, so read it before the signature is checked
+	msg, err := jws.Parse([]byte(assertion))
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %v", ErrInvalidAssertion, err)
+	}
+	var unverified Claims
+	if err := json.Unmarshal(msg.Payload(), &unverified); err != nil {
+		return "", "", fmt.Errorf("%w: claims: %v", ErrInvalidAssertion, err)
+	}
+	issuer = unverified.Issuer
+	var set jwk.Set
+	if t != nil {
+		set = t.sets[issuer]
+	}
+	if set == nil {
+		return "", "", fmt.Errorf("%w: %q", ErrUntrustedIssuer, issuer)
+	}
+
+	payload, err := jws.Verify([]byte(assertion), jws.WithKeySet(set))
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %v", ErrInvalidAssertion, err)
+	}
+	var c Claims
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return "", "", fmt.Errorf("%w: claims: %v", ErrInvalidAssertion, err)
+	}
+	if err := c.validate(issuer, audience, time.Now()); err != nil {
+		return "", "", fmt.Errorf("%w: %v", ErrInvalidAssertion, err)
+	}
+	if !t.firstUse(issuer, c.ID, time.Unix(c.IssuedAt, 0).Add(MaxAssertionAge)) {
+		return "", "", fmt.Errorf("%w: already used", ErrInvalidAssertion)
+	}
+	return issuer, c.Subject, nil
+}
+
+// validate checks that the claims are issuer's, meant for audience and valid at now,
+// and carry everything an assertion must.
+func (c Claims) validate(issuer, audience string, now time.Time) error {
+	switch {
+	case c.Issuer != issuer:
+		return fmt.Errorf("iss %q, want %q", c.Issuer, issuer)
+	case c.Subject == "":
+		return errors.New("missing sub")
+	case c.ID == "":
+		return errors.New("missing jti")
+	case c.Expiry == 0 || c.IssuedAt == 0:
+		return errors.New("missing exp or iat")
+	case !slices.Contains(c.Audience, audience):
+		return fmt.Errorf("aud %v does not include %q", c.Audience, audience)
+	}
+	unix := now.Unix()
+	switch {
+	case unix >= c.Expiry:
+		return errors.New("expired")
+	case c.NotBefore != 0 && unix < c.NotBefore:
+		return errors.New("not yet valid")
+	case c.IssuedAt > unix:
+		return errors.New("issued in the future")
+	case now.Sub(time.Unix(c.IssuedAt, 0)) > MaxAssertionAge:
+		return fmt.Errorf("issued more than %s ago", MaxAssertionAge)
+	}
+	return nil
+}
+
+// firstUse records the assertion issuer and jti name until forget, and reports whether
+// it was not seen before. Past forget the assertion is too old to be accepted anyway.
+func (t *TrustedIssuers) firstUse(issuer, jti string, forget time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	for k, until := range t.seen {
+		if now.After(until) {
+			delete(t.seen, k)
+		}
+	}
+	key := issuer + " " + jti
+	if _, ok := t.seen[key]; ok {
+		return false
+	}
+	t.seen[key] = forget
+	return true
+}
@@ -0,0 +1,128 @@
+package jwks
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTrustedIssuers_Verify(t *testing.T) {
+	idp, err := Load(t.TempDir())
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	other, err := Load(t.TempDir())
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	// Trust the IdP through a local copy of its published JWKS
+	b, _ := json.Marshal(idp.Public())
+	path := filepath.Join(t.TempDir(), "idp.jwks.json")
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		t.Fatalf("write jwks: %v", err)
+	}
+	trusted, err := LoadTrustedIssuers(map[string]string{"https://idp.example": path})
+	if err != nil {
+		t.Fatalf("LoadTrustedIssuers: %v", err)
+	}
+
+	const as = "https://as.example"
+	n := 0
+	build := func(keys *KeySet, iss, sub, aud string, iat, exp time.Time, withJTI bool) string {
+		t.Helper()
+		c := Claims{Issuer: iss, Subject: sub, IssuedAt: iat.Unix(), Expiry: exp.Unix()}
+		if aud != "" {
+			c.Audience = Audience{aud}
+		}
+		if withJTI {
+			n++
+			c.ID = fmt.Sprintf("jti-%d", n)
+		}
+		signed, err := keys.Sign(c)
+		if err != nil {
+			t.Fatalf("Sign: %v", err)
+		}
+		return string(signed)
+	}
+	now := time.Now()
+	later, earlier := now.Add(time.Minute), now.Add(-time.Minute)
+	assertion := func(keys *KeySet, iss, sub string, exp time.Time) string {
+		return build(keys, iss, sub, as, now, exp, true)
+	}
+
+	tests := []struct {
+		name      string
+		trusted   *TrustedIssuers
+		assertion string
+		wantErr   error
+	}{
+		{"trusted", trusted, assertion(idp, "https://idp.example", "alice", later), nil},
+		{"untrusted issuer", trusted, assertion(idp, "https://evil.example", "alice", later), ErrUntrustedIssuer},
+		{"signed by another key", trusted, assertion(other, "https://idp.example", "alice", later), ErrInvalidAssertion},
+		{"expired", trusted, assertion(idp, "https://idp.example", "alice", earlier), ErrInvalidAssertion},
+		{"no subject", trusted, assertion(idp, "https://idp.example", "", later), ErrInvalidAssertion},
+		{"for another audience", trusted, build(idp, "https://idp.example", "alice", "https://other-as.example", now, later, true), ErrInvalidAssertion},
+		{"no audience", trusted, build(idp, "https://idp.example", "alice", "", now, later, true), ErrInvalidAssertion},
+		{"no jti", trusted, build(idp, "https://idp.example", "alice", as, now, later, false), ErrInvalidAssertion},
+		{"issued too long ago", trusted, build(idp, "https://idp.example", "alice", as, now.Add(-MaxAssertionAge-time.Minute), later, true), ErrInvalidAssertion},
+		{"not a JWT", trusted, "not-a-jwt", ErrInvalidAssertion},
+		{"no trusted issuers", nil, assertion(idp, "https://idp.example", "alice", later), ErrUntrustedIssuer},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			iss, sub, err := tt.trusted.Verify(tt.assertion, as)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Verify() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && (iss != "https://idp.example" || sub != "alice") {
+				t.Fatalf("Verify() = %q, %q, want the IdP and alice", iss, sub)
+			}
+		})
+	}
+
+	// An assertion is accepted once
+	replayed := assertion(idp, "https://idp.example", "alice", later)
+	if _, _, err := trusted.Verify(replayed, as); err != nil {
+		t.Fatalf("Verify() first use: %v", err)
+	}
+	if _, _, err := trusted.Verify(replayed, as); !errors.Is(err, ErrInvalidAssertion) {
+		t.Fatalf("Verify() replayed error = %v, want %v", err, ErrInvalidAssertion)
+	}
+
+	// An AS without an issuer URL has nothing to check aud against
+	if _, _, err := trusted.Verify(assertion(idp, "https://idp.example", "alice", later), ""); !errors.Is(err, ErrInvalidAssertion) {
+		t.Fatalf("Verify() without audience error = %v, want %v", err, ErrInvalidAssertion)
+	}
+}
+
+func TestParseTrustedIssuers(t *testing.T) {
+	got, err := ParseTrustedIssuers("https://idp.example=/etc/idp.json, https://b.example=b.json")
+	if err != nil || len(got) != 2 || got["https://idp.example"] != "/etc/idp.json" || got["https://b.example"] != "b.json" {
+		t.Fatalf("ParseTrustedIssuers() = %v, %v", got, err)
+	}
+	if got, err := ParseTrustedIssuers(""); err != nil || len(got) != 0 {
+		t.Fatalf("ParseTrustedIssuers(\"\") = %v, %v, want none", got, err)
+	}
+	if _, err := ParseTrustedIssuers("https://idp.example"); err == nil {
+		t.Fatalf("ParseTrustedIssuers() without a path: want error")
+	}
+}
+
+func TestAudience_UnmarshalJSON(t *testing.T) {
+	for in, want := range map[string]Audience{`"https://as.example"`: {"https://as.example"}, `["a","b"]`: {"a", "b"}} {
+		var got Audience
+		if err := json.Unmarshal([]byte(in), &got); err != nil || len(got) != len(want) || got[0] != want[0] {
+			t.Fatalf("Unmarshal(%s) = %v, %v, want %v", in, got, err, want)
+		}
+	}
+	var got Audience
+	if err := json.Unmarshal([]byte(`42`), &got); err == nil {
+		t.Fatalf("Unmarshal(42): want error")
+	}
+}
@@ -11,6 +11,7 @@ type RSIdentity struct {
 	ID    string // your canonical RS id (use keyid or your own mapping)
 	KeyID string
 	Alg   string
+	Proof string // how the RS proved possession: "httpsig" or "mtls"
 }
 
 func WithRSIdentity(r *http.Request, rs RSIdentity) *http.Request {
@@ -3,11 +3,7 @@ package mw
 import (
 	"bytes"
 	"crypto"
-	"crypto/ecdsa"
-	"crypto/ed25519"
-	"crypto/elliptic"
-	"crypto/sha256"
-	"crypto/sha512"
+	"crypto/x509"
 	"encoding/base64"
 	"errors"
 	"fmt"
@@ -16,12 +12,18 @@ import (
 	"net/textproto"
 	"strings"
 	"time"
+
+	"github.com/TwigBush/gnap-go/internal/sign"
 )
 
 type RSKeyResolver func(r *http.Request, params map[string]string) (crypto.PublicKey, error)
 
+// RSCertResolver maps a TLS client certificate to a registered RS identity.
+type RSCertResolver func(r *http.Request, cert *x509.Certificate) (RSIdentity, error)
+
 type rsCfg struct {
 	resolve        RSKeyResolver
+	resolveCert    RSCertResolver
 	requireTLS     bool
 	requiredComps  []string // components you insist must be covered
 	allowedAlgs    map[string]struct{}
@@ -35,8 +37,9 @@ func WithRSRequiredComponents(v []string) RSOption { return func(c *rsCfg) { c.r
 func WithRSAllowedAlgs(algs ...string) RSOption {
 	return func(c *rsCfg) { c.allowedAlgs = toSet(algs) }
 }
-func WithRSRequireTLS(v bool) RSOption      { return func(c *rsCfg) { c.requireTLS = v } }
-func WithRSMaxSkewSeconds(s int64) RSOption { return func(c *rsCfg) { c.maxSkewSeconds = s } }
+func WithRSCertResolver(fn RSCertResolver) RSOption { return func(c *rsCfg) { c.resolveCert = fn } }
+func WithRSRequireTLS(v bool) RSOption              { return func(c *rsCfg) { c.requireTLS = v } }
+func WithRSMaxSkewSeconds(s int64) RSOption         { return func(c *rsCfg) { c.maxSkewSeconds = s } }
 func toSet(xs []string) map[string]struct{} {
 	m := map[string]struct{}{}
 	for _, x := range xs {
@@ -47,6 +50,8 @@ func toSet(xs []string) map[string]struct{} {
 
 // VerifyRSProof validates an RS caller using HTTP Message Signatures (RFC 9421).
 // It expects headers: Signature-Input and Signature with label `sig1`.
+// When a cert resolver is configured, an RS presenting a registered TLS client certificate
+// is accepted without signature headers (mtls proof).
 func VerifyRSProof(opts ...RSOption) func(http.Handler) http.Handler {
 	cfg := &rsCfg{
 		requireTLS:     false, // todo (joshfischer) derive this from config.yaml
@@ -66,6 +71,16 @@ func VerifyRSProof(opts ...RSOption) func(http.Handler) http.Handler {
 			}
 			sigInput := r.Header.Get("Signature-Input")
 			sig := r.Header.Get("Signature")
+			if cert := sign.PeerCertificate(r); cert != nil && cfg.resolveCert != nil && sigInput == "" {
+				rs, err := cfg.resolveCert(r, cert)
+				if err != nil {
+					http.Error(w, "rs certificate not registered", http.StatusUnauthorized)
+					return
+				}
+				rs.Proof = sign.ProofMTLS
+				next.ServeHTTP(w, WithRSIdentity(r, rs))
+				return
+			}
 			if sigInput == "" || sig == "" {
 				http.Error(w, "missing HTTP Signature headers", http.StatusUnauthorized)
 				return
@@ -115,7 +130,7 @@ func VerifyRSProof(opts ...RSOption) func(http.Handler) http.Handler {
 				return
 			}
 
-			if err := verifySignature(alg, pub, base, rawSig); err != nil {
+			if err := sign.VerifySignature(alg, pub, base, rawSig); err != nil {
 				http.Error(w, "invalid http signature", http.StatusUnauthorized)
 				return
 			}
@@ -123,6 +138,7 @@ func VerifyRSProof(opts ...RSOption) func(http.Handler) http.Handler {
 				ID:    entry.params["keyid"], // or map to your canonical RS id
 				KeyID: entry.params["keyid"],
 				Alg:   alg,
+				Proof: sign.ProofHTTPSig,
 			}
 			r = WithRSIdentity(r, rs)
 
@@ -332,42 +348,6 @@ func buildSignatureBase(r *http.Request, e *sigInputEntry) ([]byte, error) {
 	return b.Bytes(), nil
 }
 
-func verifySignature(alg string, pub crypto.PublicKey, base, sig []byte) error {
-	switch alg {
-	case "ed25519":
-		pk, ok := pub.(ed25519.PublicKey)
-		if !ok {
-			return errors.New("key type mismatch")
-		}
-		if !ed25519.Verify(pk, base, sig) {
-			return errors.New("bad signature")
-		}
-		return nil
-	case "ecdsa-p384-sha384":
-		pk, ok := pub.(*ecdsa.PublicKey)
-		if !ok || pk.Curve != elliptic.P384() {
-			return errors.New("key type mismatch")
-		}
-		h := sha512.Sum384(base)
-		if ok := ecdsa.VerifyASN1(pk, h[:], sig); !ok {
-			return errors.New("bad signature")
-		}
-		return nil
-	case "ecdsa-p256-sha256":
-		pk, ok := pub.(*ecdsa.PublicKey)
-		if !ok || pk.Curve.Params().Name != "P-256" {
-			return errors.New("key type mismatch")
-		}
-		h := sha256.Sum256(base)
-		if ok := ecdsa.VerifyASN1(pk, h[:], sig); !ok {
-			return errors.New("bad signature")
-		}
-		return nil
-	default:
-		return errors.New("unsupported alg")
-	}
-}
-
 // splitTopLevel splits on sep, ignoring commas inside quotes or parentheses.
 func splitTopLevel(s string, sep rune) []string {
 	var out []string
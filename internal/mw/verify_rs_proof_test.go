@@ -0,0 +1,68 @@
+package mw
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyRSProof(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	h := VerifyRSProof(WithRSKeyResolver(func(*http.Request, map[string]string) (crypto.PublicKey, error) {
+		return pub, nil
+	}))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rs, ok := RSIdentityFromContext(r); !ok || rs.KeyID != "rs-kid" {
+			t.Errorf("RS identity = %+v, %v", rs, ok)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	// signed builds an RS request signed over a base in the form RSs have always used:
+	// lowercase @method, the request's own scheme and the label sig1.
+	signed := func(label, method, targetURI, params string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "http://as.example/introspect", nil)
+		base := "\"@method\": " + method + "\n\"@target-uri\": " + targetURI + "\n" +
+			"\"@signature-params\": (\"@method\" \"@target-uri\");" + params + "\n"
+		r.Header.Set("Signature-Input", label+`=("@method" "@target-uri");`+params)
+		r.Header.Set("Signature", label+"=:"+base64.StdEncoding.EncodeToString(ed25519.Sign(priv, []byte(base)))+":")
+		return r
+	}
+	const params = `keyid="rs-kid";alg="ed25519"`
+	created := "created=" + strconv.FormatInt(time.Now().Unix(), 10) + ";" + params
+
+	tests := []struct {
+		name    string
+		req     *http.Request
+		forward string // X-Forwarded-Proto
+		want    int
+	}{
+		{"baseline signer", signed("sig1", "post", "http://as.example/introspect", params), "", http.StatusNoContent},
+		{"with created", signed("sig1", "post", "http://as.example/introspect", created), "", http.StatusNoContent},
+		{"other label", signed("rs", "post", "http://as.example/introspect", params), "", http.StatusUnauthorized},
+		{"uppercase method", signed("sig1", "POST", "http://as.example/introspect", params), "", http.StatusUnauthorized},
+		{"forwarded scheme is not trusted", signed("sig1", "post", "https://as.example/introspect", params), "https", http.StatusUnauthorized},
+		{"stale created", signed("sig1", "post", "http://as.example/introspect", "created=1;"+params), "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.forward != "" {
+				tt.req.Header.Set("X-Forwarded-Proto", tt.forward)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, tt.req)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d (%s), want %d", w.Code, strings.TrimSpace(w.Body.String()), tt.want)
+			}
+		})
+	}
+}
@@ -16,7 +16,7 @@ func MountDebug(r chi.Router, store types.GrantStore) {
 			http.NotFound(w, r)
 			return
 		}
-		if _, err := store.ApproveGrant(r.Context(), id, g.RequestedAccess, "user:debug"); err != nil {
+		if _, err := store.ApproveGrant(r.Context(), id, g.RequestedAccess, "user:debug", true); err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
@@ -2,12 +2,14 @@ package server
 
 import (
 	"crypto"
+	"crypto/x509"
 	"encoding/json"
 	"net/http"
 	"os"
 
 	"github.com/TwigBush/gnap-go/internal/gnap"
 	"github.com/TwigBush/gnap-go/internal/handlers"
+	"github.com/TwigBush/gnap-go/internal/jwks"
 	mw2 "github.com/TwigBush/gnap-go/internal/mw"
 	"github.com/TwigBush/gnap-go/internal/playground"
 	"github.com/TwigBush/gnap-go/internal/types"
@@ -22,16 +24,28 @@ type Options struct {
 	DevNoStore               bool
 	InteractionStartModes    []string
 	InteractionFinishMethods []string
+	AppLaunchURI             string // base URI for the app start mode, e.g. a custom scheme
 	KeyProofs                []string
 	SubIDFormats             []string
 	AssertionFormats         []string
 	KeyRotationSupported     bool
+	LongPollSeconds          int  // hold /continue polls on pending grants open this long; 0 disables
+	AssertedUserSkipsConsent bool // approve grants whose user a trusted assertion vouches for without interaction
+	UnknownClients           gnap.UnknownClientPolicy
+	AdminToken               string // bearer token for the client registry admin API; empty disables it
+	Issuer                   string // the AS's own URL: the aud of user assertions and the iss of its own
 }
 
 type Deps struct {
-	GrantStore types.GrantStore
-	RSKeyStore *gnap.RSKeyStore
-	TokenStore *gnap.TokenStoreContainer
+	GrantStore  types.GrantStore
+	RSKeyStore  *gnap.RSKeyStore
+	TokenStore  *gnap.TokenStoreContainer
+	ClientStore *gnap.ClientStore
+	AccessDefs  *gnap.AccessDefinitions // named access clients may request by reference
+	Pairwise    *gnap.PairwiseSubjects
+	Keys        *jwks.KeySet // AS signing keys, for identity assertions
+
+	TrustedIssuers *jwks.TrustedIssuers // verifies user assertions from clients
 }
 
 func BuildASRouter(d Deps, opts Options, mw ...func(http.Handler) http.Handler) http.Handler {
@@ -48,8 +62,8 @@ func BuildASRouter(d Deps, opts Options, mw ...func(http.Handler) http.Handler)
 
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"http://localhost:8088", "*"},
-		AllowedMethods:   []string{"GET", "POST", "OPTIONS"},
-		AllowedHeaders:   []string{"Content-Type", "Authorization"},
+		AllowedMethods:   []string{"GET", "POST", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization", "Signature", "Signature-Input", "Content-Digest", "Detached-JWS", "X-Tenant-ID"},
 		AllowCredentials: true,
 		MaxAge:           300,
 	}))
@@ -67,23 +81,68 @@ func BuildASRouter(d Deps, opts Options, mw ...func(http.Handler) http.Handler)
 
 	grant := handlers.NewGrantHandler(d.GrantStore)
 	cont := handlers.NewContinueHandler(d.GrantStore, d.TokenStore)
+	if len(opts.InteractionStartModes) > 0 {
+		grant.StartModes = opts.InteractionStartModes
+	}
+	if len(opts.InteractionFinishMethods) > 0 {
+		grant.FinishMethods = opts.InteractionFinishMethods
+	}
+	if len(opts.SubIDFormats) > 0 {
+		grant.SubIDFormats = opts.SubIDFormats
+	}
+	if d.Keys != nil {
+		grant.AssertionFormats = opts.AssertionFormats
+	}
+	grant.AppLaunchURI = opts.AppLaunchURI
+	grant.TrustedIssuers, grant.SkipInteraction = d.TrustedIssuers, opts.AssertedUserSkipsConsent
+	grant.Clients, grant.UnknownClients = d.ClientStore, opts.UnknownClients
+	cont.Clients, cont.UnknownClients = d.ClientStore, opts.UnknownClients
+	grant.AccessDefinitions, cont.AccessDefinitions = d.AccessDefs, d.AccessDefs
+	cont.Pairwise, cont.Keys = d.Pairwise, d.Keys
+	grant.Issuer, cont.Issuer = opts.Issuer, opts.Issuer
+	cont.StartModes, cont.FinishMethods, cont.AppLaunchURI = grant.StartModes, grant.FinishMethods, grant.AppLaunchURI
 	device := handlers.NewDeviceHandler(d.GrantStore)
+	interact := handlers.NewInteractHandler(d.GrantStore)
+	if opts.LongPollSeconds > 0 {
+		waiters := handlers.NewGrantWaiters()
+		cont.LongPollSeconds, cont.Waiters = opts.LongPollSeconds, waiters
+		device.Waiters, interact.Waiters = waiters, waiters
+	}
 	introspect := handlers.NewIntrospectionHandler(d.TokenStore)
+	introspect.Pairwise = d.Pairwise
+	tokens := handlers.NewTokenManageHandler(d.GrantStore, d.TokenStore)
+	tokens.Clients, tokens.KeyRotation = d.ClientStore, opts.KeyRotationSupported
+	resourceSets := handlers.NewResourceSetHandler(d.AccessDefs)
+	resourceSets.RSKeys = d.RSKeyStore
 
 	// Public endpoints - no authentication require
 	r.Get("/healthz", healthCheckHandler)
 	r.Get("/version", handlers.VersionHandler)
 
 	r.Options("/grants", GrantDiscoveryHandler(opts))
-	r.Get("/.well-known/jwks.json", handlers.JWKS)
+	r.Get("/.well-known/jwks.json", handlers.JWKS(d.Keys))
 
 	// Device endpoints - no RS signature verification needed
 	r.Post("/device/verify/json", device.VerifyJSON)
 	r.Post("/device/verify", device.VerifyForm)
 	r.Get("/device", device.Page)
+	r.Get("/device/qr/{code}", device.QR)
+	r.Get("/d/{code}", device.ShortLink)
 	r.Post("/device/consent", device.ConsentForm)
 
+	// Redirect interaction - the user's browser arrives here from the client
+	r.Get("/interact/{interactId}", interact.Page)
+	r.Post("/interact/{interactId}", interact.Consent)
+
+	// Grant requests carry their own client key proof, verified by the handler
+	r.Post("/grants", grant.ServeHTTP)
 	r.Post("/continue/{grantId}", cont.ServeHTTP)
+	r.Patch("/continue/{grantId}", cont.ServeHTTP)
+	r.Delete("/continue/{grantId}", cont.ServeHTTP)
+
+	// Token management carries the management access token and the bound key proof
+	r.Post("/token/{manageId}", tokens.Rotate)
+	r.Delete("/token/{manageId}", tokens.Revoke)
 
 	r.Group(func(rsr chi.Router) {
 		rsr.Use(mw2.VerifyRSProof(
@@ -94,13 +153,21 @@ func BuildASRouter(d Deps, opts Options, mw ...func(http.Handler) http.Handler)
 				return d.RSKeyStore.LookupRSPublicKeyById(kid)
 
 			}),
+			mw2.WithRSCertResolver(func(r *http.Request, cert *x509.Certificate) (mw2.RSIdentity, error) {
+				// An RS may present a client certificate for any of its registered keys
+				rec, err := d.RSKeyStore.LookupRSKeyByPublicKey(cert.PublicKey)
+				if err != nil {
+					return mw2.RSIdentity{}, err
+				}
+				return mw2.RSIdentity{ID: rec.KID, KeyID: rec.KID}, nil
+			}),
 			mw2.WithRSRequiredComponents([]string{"@method", "@target-uri"}), // add "content-digest" if you require it
 			mw2.WithRSAllowedAlgs("ecdsa-p256-sha256", "ecdsa-p384-sha384", "ed25519"),
 		))
-		rsr.Post("/grants", grant.ServeHTTP)
-
 		rsr.Post("/introspect", introspect.Introspect)
-		//rsr.Post("/register", rs.HandleRegisterResourceSet)
+		if d.AccessDefs != nil {
+			rsr.Post("/register", resourceSets.Register)
+		}
 		//rsr.Post("/token", rs.HandleTokenChaining)
 	})
 
@@ -115,6 +182,19 @@ func BuildASRouter(d Deps, opts Options, mw ...func(http.Handler) http.Handler)
 
 	}
 
+	if d.ClientStore != nil {
+		clients := handlers.NewClientsHandler(d.ClientStore)
+		r.Route("/admin/tenants/{tenant}/clients", func(r chi.Router) {
+			// Registered clients are shown to users as verified, so only an admin may register them
+			r.Use(mw2.RequireAdminToken(opts.AdminToken))
+			r.Post("/", clients.RegisterClient)
+			r.Get("/", clients.ListClients)
+			r.Get("/{instanceId}", clients.GetClient)
+			r.Put("/{instanceId}", clients.UpdateClient)
+			r.Delete("/{instanceId}", clients.DeleteClient)
+		})
+	}
+
 	return r
 }
 
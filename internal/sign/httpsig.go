@@ -0,0 +1,424 @@
+package sign
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TwigBush/gnap-go/internal/httpx"
+)
+
+// MaxSkewSeconds bounds how far the `created` signature parameter may drift from now.
+const MaxSkewSeconds = 300
+
+// SignatureInput is one parsed member of the Signature-Input header (RFC 9421 §4.1).
+type SignatureInput struct {
+	Label      string
+	Components []string
+	Params     map[string]string
+	raw        string // serialized inner list, reused verbatim for @signature-params
+}
+
+// VerifyHTTPSig verifies a GNAP `httpsig` key proof (RFC 9635 §7.3.1) with the client's public key.
+// The signature must cover @method and @target-uri, plus content-digest when the request has a body
+// and authorization when an Authorization header is present.
+func VerifyHTTPSig(r *http.Request, body []byte, pub crypto.PublicKey) error {
+	sigInput := r.Header.Get("Signature-Input")
+	sig := r.Header.Get("Signature")
+	if sigInput == "" || sig == "" {
+		return ErrMissingSignature
+	}
+
+	in, err := ParseSignatureInput(sigInput)
+	if err != nil {
+		return err
+	}
+	return verifyHTTPSigInput(r, body, in, pub)
+}
+
+// verifyHTTPSigInput verifies the signature labeled by in, which must cover what every
+// GNAP httpsig proof covers plus any extra components.
+func verifyHTTPSigInput(r *http.Request, body []byte, in *SignatureInput, pub crypto.PublicKey, extra ...string) error {
+	required := append([]string{"@method", "@target-uri"}, extra...)
+	if len(body) > 0 {
+		required = append(required, "content-digest")
+	}
+	if r.Header.Get("Authorization") != "" {
+		required = append(required, "authorization")
+	}
+	if err := in.Covers(required...); err != nil {
+		return err
+	}
+	if err := in.CheckCreated(MaxSkewSeconds); err != nil {
+		return err
+	}
+	if len(body) > 0 {
+		if err := VerifyContentDigest(r.Header.Get("Content-Digest"), body); err != nil {
+			return err
+		}
+	}
+
+	alg, err := algForKey(in.Params["alg"], pub)
+	if err != nil {
+		return err
+	}
+	rawSig, err := SignatureFor(r.Header.Get("Signature"), in.Label)
+	if err != nil {
+		return err
+	}
+	base, err := SignatureBase(r, in)
+	if err != nil {
+		return err
+	}
+	return VerifySignature(alg, pub, base, rawSig)
+}
+
+// ParseSignatureInput parses the first member of a Signature-Input header, for example:
+// sig1=("@method" "@target-uri");created=1697044520;keyid="kid";alg="ecdsa-p256-sha256"
+func ParseSignatureInput(h string) (*SignatureInput, error) {
+	ins, err := ParseSignatureInputs(h)
+	if err != nil {
+		return nil, err
+	}
+	return ins[0], nil
+}
+
+// ParseSignatureInputs parses every member of a Signature-Input header, in order.
+func ParseSignatureInputs(h string) ([]*SignatureInput, error) {
+	var ins []*SignatureInput
+	for _, member := range splitTopLevel(h, ',') {
+		member = strings.TrimSpace(member)
+		eq := strings.IndexByte(member, '=')
+		if eq <= 0 {
+			continue
+		}
+		label := strings.TrimSpace(member[:eq])
+		raw := strings.TrimSpace(member[eq+1:])
+		if !strings.HasPrefix(raw, "(") {
+			return nil, fmt.Errorf("%w: missing components", ErrInvalidSignatureInput)
+		}
+		end := strings.IndexByte(raw, ')')
+		if end < 0 {
+			return nil, fmt.Errorf("%w: unterminated components", ErrInvalidSignatureInput)
+		}
+
+		var comps []string
+		for _, c := range strings.Fields(raw[1:end]) {
+			comp, err := parseComponent(c)
+			if err != nil {
+				return nil, err
+			}
+			comps = append(comps, comp)
+		}
+
+		params := map[string]string{}
+		for _, p := range splitTopLevel(raw[end+1:], ';') {
+			p = strings.TrimSpace(p)
+			if p == "" {
+				continue
+			}
+			k, v, _ := strings.Cut(p, "=")
+			params[strings.ToLower(strings.TrimSpace(k))] = strings.Trim(strings.TrimSpace(v), "\"")
+		}
+
+		ins = append(ins, &SignatureInput{Label: label, Components: comps, Params: params, raw: raw})
+	}
+	if len(ins) == 0 {
+		return nil, ErrInvalidSignatureInput
+	}
+	return ins, nil
+}
+
+// parseComponent parses one component identifier. Of the RFC 9421 component parameters
+// only `key`, which selects a member of a dictionary field, is supported; such components
+// are kept as name;key="member", for example signature;key="old-key".
+func parseComponent(c string) (string, error) {
+	if len(c) < 2 || c[0] != '"' {
+		return "", fmt.Errorf("%w: unsupported component %s", ErrInvalidSignatureInput, c)
+	}
+	end := strings.IndexByte(c[1:], '"') + 1
+	if end < 1 {
+		return "", fmt.Errorf("%w: unsupported component %s", ErrInvalidSignatureInput, c)
+	}
+	name := strings.ToLower(c[1:end])
+	param := c[end+1:]
+	if param == "" {
+		return name, nil
+	}
+	member, ok := strings.CutPrefix(param, ";key=")
+	if !ok || len(member) < 3 || member[0] != '"' || member[len(member)-1] != '"' || strings.HasPrefix(name, "@") {
+		return "", fmt.Errorf("%w: unsupported component %s", ErrInvalidSignatureInput, c)
+	}
+	return name + ";key=" + member, nil
+}
+
+// DictionaryComponent names the member of a dictionary header field, as covered by a
+// signature: DictionaryComponent("signature", "old-key") is signature;key="old-key".
+func DictionaryComponent(name, member string) string {
+	return fmt.Sprintf("%s;key=%q", strings.ToLower(name), member)
+}
+
+// Covers reports an error if any of the given components is not covered by the signature.
+func (in *SignatureInput) Covers(components ...string) error {
+	have := map[string]struct{}{}
+	for _, c := range in.Components {
+		have[c] = struct{}{}
+	}
+	for _, need := range components {
+		// Field names are case-insensitive, dictionary member keys are not
+		name, member, _ := strings.Cut(need, ";key=")
+		if member != "" {
+			member = ";key=" + member
+		}
+		if _, ok := have[strings.ToLower(name)+member]; !ok {
+			return fmt.Errorf("%w: %q", ErrMissingComponent, need)
+		}
+	}
+	return nil
+}
+
+// CheckCreated rejects signatures without a `created` parameter (RFC 9635 §7.3.1),
+// signatures created outside the allowed window, and signatures past their `expires` parameter.
+func (in *SignatureInput) CheckCreated(maxSkew int64) error {
+	now := time.Now().Unix()
+	v := in.Params["created"]
+	if v == "" {
+		return fmt.Errorf("%w: missing created param", ErrInvalidSignatureInput)
+	}
+	created, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: bad created param", ErrInvalidSignatureInput)
+	}
+	if created > now+maxSkew || created < now-maxSkew {
+		return ErrSignatureExpired
+	}
+	if v := in.Params["expires"]; v != "" {
+		expires, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%w: bad expires param", ErrInvalidSignatureInput)
+		}
+		if now > expires {
+			return ErrSignatureExpired
+		}
+	}
+	return nil
+}
+
+// SignatureFor extracts the sf-binary signature for label from a Signature header.
+func SignatureFor(h, label string) ([]byte, error) {
+	for _, member := range splitTopLevel(h, ',') {
+		k, v, ok := strings.Cut(strings.TrimSpace(member), "=")
+		if !ok || strings.TrimSpace(k) != label {
+			continue
+		}
+		v = strings.TrimSpace(v)
+		if len(v) < 2 || !strings.HasPrefix(v, ":") || !strings.HasSuffix(v, ":") {
+			return nil, fmt.Errorf("%w: signature is not sf-binary", ErrMissingSignature)
+		}
+		return base64.StdEncoding.DecodeString(v[1 : len(v)-1])
+	}
+	return nil, fmt.Errorf("%w: no signature for label %q", ErrMissingSignature, label)
+}
+
+// SignatureBase builds the RFC 9421 signature base for the covered components of r.
+func SignatureBase(r *http.Request, in *SignatureInput) ([]byte, error) {
+	var b strings.Builder
+	for _, c := range in.Components {
+		var v string
+		switch c {
+		case "@method":
+			v = r.Method
+		case "@target-uri":
+			v = httpx.BaseURL(r) + r.URL.RequestURI()
+		case "@authority":
+			v = strings.ToLower(r.Host)
+		case "@path":
+			v = r.URL.EscapedPath()
+		case "@query":
+			v = "?" + r.URL.RawQuery
+		default:
+			if strings.HasPrefix(c, "@") {
+				return nil, fmt.Errorf("%w: unsupported derived component %q", ErrInvalidSignatureInput, c)
+			}
+			if name, member, ok := strings.Cut(c, ";key="); ok {
+				v, err := dictionaryMember(r, name, strings.Trim(member, "\""))
+				if err != nil {
+					return nil, err
+				}
+				fmt.Fprintf(&b, "%q;key=%s: %s\n", name, member, v)
+				continue
+			}
+			vals := r.Header.Values(c)
+			if len(vals) == 0 {
+				return nil, fmt.Errorf("%w: missing covered header %q", ErrMissingComponent, c)
+			}
+			for i := range vals {
+				vals[i] = strings.TrimSpace(vals[i])
+			}
+			v = strings.Join(vals, ", ")
+		}
+		fmt.Fprintf(&b, "%q: %s\n", c, v)
+	}
+	fmt.Fprintf(&b, "\"@signature-params\": %s", in.raw)
+	return []byte(b.String()), nil
+}
+
+// dictionaryMember returns the serialized value of member in the dictionary header name.
+func dictionaryMember(r *http.Request, name, member string) (string, error) {
+	for _, m := range splitTopLevel(strings.Join(r.Header.Values(name), ","), ',') {
+		k, v, ok := strings.Cut(strings.TrimSpace(m), "=")
+		if ok && strings.TrimSpace(k) == member {
+			return strings.TrimSpace(v), nil
+		}
+	}
+	return "", fmt.Errorf("%w: missing covered member %q of %q", ErrMissingComponent, member, name)
+}
+
+// VerifyContentDigest checks a Content-Digest header (RFC 9530) against body.
+// At least one sha-256 or sha-512 digest must be present and all supported digests must match.
+func VerifyContentDigest(h string, body []byte) error {
+	if h == "" {
+		return ErrDigestMismatch
+	}
+	matched := false
+	for _, member := range splitTopLevel(h, ',') {
+		alg, v, ok := strings.Cut(strings.TrimSpace(member), "=")
+		if !ok {
+			continue
+		}
+		v = strings.Trim(strings.TrimSpace(v), ":")
+		want, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return ErrDigestMismatch
+		}
+		var got []byte
+		switch strings.ToLower(strings.TrimSpace(alg)) {
+		case "sha-256":
+			sum := sha256.Sum256(body)
+			got = sum[:]
+		case "sha-512":
+			sum := sha512.Sum512(body)
+			got = sum[:]
+		default:
+			continue
+		}
+		if subtle.ConstantTimeCompare(got, want) != 1 {
+			return ErrDigestMismatch
+		}
+		matched = true
+	}
+	if !matched {
+		return ErrDigestMismatch
+	}
+	return nil
+}
+
+// VerifySignature checks sig over base with pub for an RFC 9421 algorithm name.
+// ECDSA signatures are accepted in the RFC 9421 r||s form as well as ASN.1 DER.
+func VerifySignature(alg string, pub crypto.PublicKey, base, sig []byte) error {
+	switch strings.ToLower(alg) {
+	case "ed25519":
+		pk, ok := pub.(ed25519.PublicKey)
+		if !ok {
+			return ErrKeyMismatch
+		}
+		if !ed25519.Verify(pk, base, sig) {
+			return ErrBadSignature
+		}
+		return nil
+	case "ecdsa-p256-sha256":
+		pk, ok := pub.(*ecdsa.PublicKey)
+		if !ok || pk.Curve != elliptic.P256() {
+			return ErrKeyMismatch
+		}
+		h := sha256.Sum256(base)
+		return verifyECDSA(pk, h[:], sig)
+	case "ecdsa-p384-sha384":
+		pk, ok := pub.(*ecdsa.PublicKey)
+		if !ok || pk.Curve != elliptic.P384() {
+			return ErrKeyMismatch
+		}
+		h := sha512.Sum384(base)
+		return verifyECDSA(pk, h[:], sig)
+	default:
+		return ErrUnsupportedAlg
+	}
+}
+
+func verifyECDSA(pk *ecdsa.PublicKey, digest, sig []byte) error {
+	size := (pk.Curve.Params().BitSize + 7) / 8
+	if len(sig) == 2*size {
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if ecdsa.Verify(pk, digest, r, s) {
+			return nil
+		}
+	}
+	if ecdsa.VerifyASN1(pk, digest, sig) {
+		return nil
+	}
+	return ErrBadSignature
+}
+
+// algForKey returns the signature algorithm to use for pub. When the signer declared an
+// `alg` parameter it must agree with the key type.
+func algForKey(declared string, pub crypto.PublicKey) (string, error) {
+	var alg string
+	switch k := pub.(type) {
+	case ed25519.PublicKey:
+		alg = "ed25519"
+	case *ecdsa.PublicKey:
+		switch k.Curve {
+		case elliptic.P256():
+			alg = "ecdsa-p256-sha256"
+		case elliptic.P384():
+			alg = "ecdsa-p384-sha384"
+		default:
+			return "", ErrUnsupportedAlg
+		}
+	default:
+		return "", ErrUnsupportedAlg
+	}
+	if declared != "" && !strings.EqualFold(declared, alg) {
+		return "", ErrKeyMismatch
+	}
+	return alg, nil
+}
+
+// splitTopLevel splits on sep, ignoring separators inside quotes or parentheses.
+func splitTopLevel(s string, sep rune) []string {
+	var out []string
+	var buf strings.Builder
+	depth := 0
+	inQuotes := false
+	for _, r := range s {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+		case r == '(' && !inQuotes:
+			depth++
+		case r == ')' && !inQuotes:
+			depth--
+		case r == sep && depth == 0 && !inQuotes:
+			out = append(out, buf.String())
+			buf.Reset()
+			continue
+		}
+		buf.WriteRune(r)
+	}
+	if buf.Len() > 0 {
+		out = append(out, buf.String())
+	}
+	return out
+}
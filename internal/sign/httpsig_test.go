@@ -0,0 +1,188 @@
+package sign
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TwigBush/gnap-go/internal/types"
+)
+
+// signedRequest builds a POST to the AS with an httpsig proof over comps made with priv.
+func signedRequest(t *testing.T, priv *ecdsa.PrivateKey, body []byte, comps []string) *http.Request {
+	t.Helper()
+
+	r := httptest.NewRequest(http.MethodPost, "http://as.example/grants", bytes.NewReader(body))
+	if len(body) > 0 {
+		d := sha256.Sum256(body)
+		r.Header.Set("Content-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(d[:])+":")
+	}
+
+	quoted := make([]string, len(comps))
+	for i, c := range comps {
+		quoted[i] = fmt.Sprintf("%q", c)
+	}
+	params := fmt.Sprintf("(%s);created=%d;keyid=\"test\";alg=\"ecdsa-p256-sha256\"", strings.Join(quoted, " "), time.Now().Unix())
+	r.Header.Set("Signature-Input", "sig1="+params)
+
+	in, err := ParseSignatureInput(r.Header.Get("Signature-Input"))
+	if err != nil {
+		t.Fatalf("ParseSignatureInput: %v", err)
+	}
+	base, err := SignatureBase(r, in)
+	if err != nil {
+		t.Fatalf("SignatureBase: %v", err)
+	}
+	sum := sha256.Sum256(base)
+	sr, ss, err := ecdsa.Sign(rand.Reader, priv, sum[:])
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	sig := make([]byte, 64)
+	sr.FillBytes(sig[:32])
+	ss.FillBytes(sig[32:])
+	r.Header.Set("Signature", "sig1=:"+base64.StdEncoding.EncodeToString(sig)+":")
+	return r
+}
+
+func clientKeyFor(t *testing.T, priv *ecdsa.PrivateKey) types.ClientKey {
+	t.Helper()
+	b64 := base64.RawURLEncoding.EncodeToString
+	return types.ClientKey{
+		Proof: ProofHTTPSig,
+		JWK: types.JWK{
+			Kty: "EC",
+			Crv: "P-256",
+			X:   b64(priv.PublicKey.X.FillBytes(make([]byte, 32))),
+			Y:   b64(priv.PublicKey.Y.FillBytes(make([]byte, 32))),
+		},
+	}
+}
+
+func TestVerifyRequestProof_HTTPSig(t *testing.T) {
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	body := []byte(`{"access_token":{"access":[{"type":"demo"}]}}`)
+	full := []string{"@method", "@target-uri", "content-digest"}
+
+	tests := []struct {
+		name    string
+		req     func() *http.Request
+		body    []byte
+		key     types.ClientKey
+		wantErr error
+	}{
+		{
+			name: "valid",
+			req:  func() *http.Request { return signedRequest(t, priv, body, full) },
+			body: body,
+			key:  clientKeyFor(t, priv),
+		},
+		{
+			name:    "wrong key",
+			req:     func() *http.Request { return signedRequest(t, other, body, full) },
+			body:    body,
+			key:     clientKeyFor(t, priv),
+			wantErr: ErrBadSignature,
+		},
+		{
+			name:    "tampered body",
+			req:     func() *http.Request { return signedRequest(t, priv, body, full) },
+			body:    []byte(`{"access_token":{"access":[{"type":"admin"}]}}`),
+			key:     clientKeyFor(t, priv),
+			wantErr: ErrDigestMismatch,
+		},
+		{
+			name:    "content-digest not covered",
+			req:     func() *http.Request { return signedRequest(t, priv, body, []string{"@method", "@target-uri"}) },
+			body:    body,
+			key:     clientKeyFor(t, priv),
+			wantErr: ErrMissingComponent,
+		},
+		{
+			name: "unsigned",
+			req: func() *http.Request {
+				return httptest.NewRequest(http.MethodPost, "http://as.example/grants", bytes.NewReader(body))
+			},
+			body:    body,
+			key:     clientKeyFor(t, priv),
+			wantErr: ErrMissingSignature,
+		},
+		{
+			name:    "unsupported proof method",
+			req:     func() *http.Request { return signedRequest(t, priv, body, full) },
+			body:    body,
+			key:     types.ClientKey{Proof: "dpop", JWK: clientKeyFor(t, priv).JWK},
+			wantErr: ErrUnsupportedProof,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := VerifyRequestProof(tt.req(), tt.body, tt.key)
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("VerifyRequestProof() error = %v, want nil", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("VerifyRequestProof() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyContentDigest(t *testing.T) {
+	body := []byte("hello")
+	d := sha256.Sum256(body)
+	good := "sha-256=:" + base64.StdEncoding.EncodeToString(d[:]) + ":"
+
+	if err := VerifyContentDigest(good, body); err != nil {
+		t.Fatalf("good digest rejected: %v", err)
+	}
+	if err := VerifyContentDigest(good, []byte("hellO")); !errors.Is(err, ErrDigestMismatch) {
+		t.Fatalf("mismatched digest error = %v, want %v", err, ErrDigestMismatch)
+	}
+	if err := VerifyContentDigest("md5=:AAAA:", body); !errors.Is(err, ErrDigestMismatch) {
+		t.Fatalf("unsupported-only digest error = %v, want %v", err, ErrDigestMismatch)
+	}
+}
+
+func TestSignatureInput_CheckCreated(t *testing.T) {
+	now := time.Now().Unix()
+	tests := []struct {
+		name    string
+		params  map[string]string
+		wantErr error
+	}{
+		{"fresh", map[string]string{"created": fmt.Sprint(now)}, nil},
+		{"missing created", map[string]string{}, ErrInvalidSignatureInput},
+		{"missing created with expires", map[string]string{"expires": fmt.Sprint(now + 60)}, ErrInvalidSignatureInput},
+		{"stale", map[string]string{"created": fmt.Sprint(now - 3600)}, ErrSignatureExpired},
+		{"expired", map[string]string{"created": fmt.Sprint(now), "expires": fmt.Sprint(now - 1)}, ErrSignatureExpired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := &SignatureInput{Params: tt.params}
+			err := in.CheckCreated(MaxSkewSeconds)
+			if tt.wantErr == nil && err != nil {
+				t.Fatalf("CheckCreated() error = %v, want nil", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CheckCreated() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package sign
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/TwigBush/gnap-go/internal/httpx"
+	"github.com/lestrrat-go/jwx/v3/jwa"
+	"github.com/lestrrat-go/jwx/v3/jws"
+)
+
+// JWS binding types and media type (RFC 9635 §7.3.3, §7.3.4)
+const (
+	TypJWSD         = "gnap-binding-jwsd"
+	TypJWS          = "gnap-binding-jws"
+	JOSEContentType = "application/jose"
+	DetachedJWSHdr  = "Detached-JWS"
+)
+
+// bindingHeader is the protected header GNAP requires on JWS key proofs.
+type bindingHeader struct {
+	Alg     string `json:"alg"`
+	Kid     string `json:"kid"`
+	Typ     string `json:"typ"`
+	Htm     string `json:"htm"`
+	URI     string `json:"uri"`
+	Created int64  `json:"created"`
+	Ath     string `json:"ath,omitempty"`
+}
+
+// VerifyDetachedJWS verifies a `jwsd` proof: a compact JWS in the Detached-JWS header whose
+// payload is the base64url SHA-256 hash of the request content, or empty when there is none.
+// kid is the presented JWK's key ID, if it has one.
+func VerifyDetachedJWS(r *http.Request, body []byte, pub crypto.PublicKey, kid string) error {
+	compact := r.Header.Get(DetachedJWSHdr)
+	if compact == "" {
+		return ErrMissingSignature
+	}
+	return verifyJWSBinding(r, []byte(compact), DetachedPayload(body), TypJWSD, pub, kid)
+}
+
+// VerifyAttachedJWS verifies a `jws` proof: the request content is a compact JWS whose
+// payload is the JSON request. Requests without content carry the JWS over an empty
+// payload in the Detached-JWS header. kid is the presented JWK's key ID, if it has one.
+func VerifyAttachedJWS(r *http.Request, body []byte, pub crypto.PublicKey, kid string) error {
+	if len(body) == 0 {
+		compact := r.Header.Get(DetachedJWSHdr)
+		if compact == "" {
+			return ErrMissingSignature
+		}
+		return verifyJWSBinding(r, []byte(compact), []byte{}, TypJWS, pub, kid)
+	}
+	if !IsJOSE(r) {
+		return fmt.Errorf("%w: content is not %s", ErrMissingSignature, JOSEContentType)
+	}
+	return verifyJWSBinding(r, body, nil, TypJWS, pub, kid)
+}
+
+// DetachedPayload returns the `jwsd` signing payload for body.
+func DetachedPayload(body []byte) []byte {
+	if len(body) == 0 {
+		return []byte{}
+	}
+	sum := sha256.Sum256(body)
+	return []byte(base64.RawURLEncoding.EncodeToString(sum[:]))
+}
+
+// AccessTokenHash computes the `ath` header value for an access token.
+func AccessTokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// IsJOSE reports whether the request content is an attached JWS.
+func IsJOSE(r *http.Request) bool {
+	mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mt == JOSEContentType
+}
+
+// RequestPayload returns the JSON message of a request. For attached JWS requests this is
+// the (not yet verified) JWS payload; otherwise it is the body itself. Callers must still
+// verify the key proof before trusting the result.
+func RequestPayload(r *http.Request, body []byte) ([]byte, error) {
+	if !IsJOSE(r) {
+		return body, nil
+	}
+	parts := bytes.Split(bytes.TrimSpace(body), []byte("."))
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed compact JWS", ErrMissingSignature)
+	}
+	return base64.RawURLEncoding.DecodeString(string(parts[1]))
+}
+
+// verifyJWSBinding checks the GNAP protected header against r and the presented key, and
+// the signature against pub. A nil detached payload means the payload is attached to the
+// compact serialization.
+func verifyJWSBinding(r *http.Request, compact, detached []byte, typ string, pub crypto.PublicKey, kid string) error {
+	compact = bytes.TrimSpace(compact)
+	parts := bytes.Split(compact, []byte("."))
+	if len(parts) != 3 {
+		return fmt.Errorf("%w: malformed compact JWS", ErrMissingSignature)
+	}
+	rawHdr, err := base64.RawURLEncoding.DecodeString(string(parts[0]))
+	if err != nil {
+		return fmt.Errorf("%w: bad protected header", ErrMissingSignature)
+	}
+	var hdr bindingHeader
+	if err := json.Unmarshal(rawHdr, &hdr); err != nil {
+		return fmt.Errorf("%w: bad protected header", ErrMissingSignature)
+	}
+
+	if hdr.Typ != typ {
+		return fmt.Errorf("%w: typ %q", ErrMissingComponent, hdr.Typ)
+	}
+	// The header names the key it was signed with, which must be the presented one
+	if hdr.Kid == "" {
+		return fmt.Errorf("%w: kid", ErrMissingComponent)
+	}
+	if kid != "" && hdr.Kid != kid {
+		return fmt.Errorf("%w: kid %q", ErrKeyMismatch, hdr.Kid)
+	}
+	if hdr.Htm != r.Method {
+		return fmt.Errorf("%w: htm", ErrMissingComponent)
+	}
+	if hdr.URI != httpx.BaseURL(r)+r.URL.RequestURI() {
+		return fmt.Errorf("%w: uri", ErrMissingComponent)
+	}
+	now := time.Now().Unix()
+	if hdr.Created == 0 || hdr.Created > now+MaxSkewSeconds || hdr.Created < now-MaxSkewSeconds {
+		return ErrSignatureExpired
+	}
+	if tok, ok := httpx.ExtractGNAPToken(r.Header.Get("Authorization")); ok && tok != "" {
+		if hdr.Ath != AccessTokenHash(tok) {
+			return fmt.Errorf("%w: ath", ErrMissingComponent)
+		}
+	}
+
+	alg, err := jwsAlgForKey(pub)
+	if err != nil {
+		return err
+	}
+	if hdr.Alg != alg.String() {
+		return ErrKeyMismatch
+	}
+
+	opts := []jws.VerifyOption{jws.WithKey(alg, pub)}
+	if detached != nil {
+		opts = append(opts, jws.WithDetachedPayload(detached))
+	}
+	if _, err := jws.Verify(compact, opts...); err != nil {
+		return fmt.Errorf("%w: %v", ErrBadSignature, err)
+	}
+	return nil
+}
+
+// jwsAlgForKey returns the JWS algorithm matching a public key.
+func jwsAlgForKey(pub crypto.PublicKey) (jwa.SignatureAlgorithm, error) {
+	switch k := pub.(type) {
+	case ed25519.PublicKey:
+		return jwa.EdDSA(), nil
+	case *ecdsa.PublicKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return jwa.ES256(), nil
+		case elliptic.P384():
+			return jwa.ES384(), nil
+		}
+	}
+	return jwa.SignatureAlgorithm{}, ErrUnsupportedAlg
+}
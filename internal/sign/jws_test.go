@@ -0,0 +1,100 @@
+package sign
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v3/jwa"
+	"github.com/lestrrat-go/jwx/v3/jws"
+)
+
+func jwsBindingHeaders(t *testing.T, typ, method, uri, token string) jws.Headers {
+	t.Helper()
+	hdr := jws.NewHeaders()
+	_ = hdr.Set(jws.TypeKey, typ)
+	_ = hdr.Set(jws.KeyIDKey, "test")
+	_ = hdr.Set("htm", method)
+	_ = hdr.Set("uri", uri)
+	_ = hdr.Set("created", time.Now().Unix())
+	if token != "" {
+		_ = hdr.Set("ath", AccessTokenHash(token))
+	}
+	return hdr
+}
+
+func TestVerifyRequestProof_DetachedJWS(t *testing.T) {
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	key := clientKeyFor(t, priv)
+	key.Proof = ProofJWSD
+	key.JWK.Kid = "test"
+	body := []byte(`{"interact_ref":"abc"}`)
+	const uri = "http://as.example/continue/g1"
+
+	newReq := func(signedBody []byte, token, athToken string) *http.Request {
+		hdr := jwsBindingHeaders(t, TypJWSD, http.MethodPost, uri, athToken)
+		compact, err := jws.Sign(nil, jws.WithKey(jwa.ES256(), priv, jws.WithProtectedHeaders(hdr)), jws.WithDetachedPayload(DetachedPayload(signedBody)))
+		if err != nil {
+			t.Fatalf("sign: %v", err)
+		}
+		r := httptest.NewRequest(http.MethodPost, uri, bytes.NewReader(body))
+		r.Header.Set(DetachedJWSHdr, string(compact))
+		if token != "" {
+			r.Header.Set("Authorization", "GNAP "+token)
+		}
+		return r
+	}
+
+	if err := VerifyRequestProof(newReq(body, "tok", "tok"), body, key); err != nil {
+		t.Fatalf("valid jwsd rejected: %v", err)
+	}
+	if err := VerifyRequestProof(newReq([]byte(`{}`), "", ""), body, key); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("payload mismatch error = %v, want %v", err, ErrBadSignature)
+	}
+	if err := VerifyRequestProof(newReq(body, "tok", "other"), body, key); !errors.Is(err, ErrMissingComponent) {
+		t.Fatalf("ath mismatch error = %v, want %v", err, ErrMissingComponent)
+	}
+
+	// The header must name the presented key
+	key.JWK.Kid = "other"
+	if err := VerifyRequestProof(newReq(body, "", ""), body, key); !errors.Is(err, ErrKeyMismatch) {
+		t.Fatalf("kid mismatch error = %v, want %v", err, ErrKeyMismatch)
+	}
+}
+
+func TestVerifyRequestProof_AttachedJWS(t *testing.T) {
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	key := clientKeyFor(t, priv)
+	key.Proof = ProofJWS
+	payload := []byte(`{"access_token":{"access":["read"]}}`)
+	const uri = "http://as.example/grants"
+
+	hdr := jwsBindingHeaders(t, TypJWS, http.MethodPost, uri, "")
+	compact, err := jws.Sign(payload, jws.WithKey(jwa.ES256(), priv, jws.WithProtectedHeaders(hdr)))
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, uri, bytes.NewReader(compact))
+	r.Header.Set("Content-Type", JOSEContentType)
+
+	got, err := RequestPayload(r, compact)
+	if err != nil || !bytes.Equal(got, payload) {
+		t.Fatalf("RequestPayload() = %q, %v; want %q", got, err, payload)
+	}
+	if err := VerifyRequestProof(r, compact, key); err != nil {
+		t.Fatalf("valid jws rejected: %v", err)
+	}
+
+	// The same body under a different method must not verify
+	key.Proof = ProofHTTPSig
+	if err := VerifyRequestProof(r, compact, key); !errors.Is(err, ErrUnsupportedProof) {
+		t.Fatalf("jose body with httpsig error = %v, want %v", err, ErrUnsupportedProof)
+	}
+}
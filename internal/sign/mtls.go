@@ -0,0 +1,67 @@
+package sign
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+
+	"github.com/TwigBush/gnap-go/internal/types"
+)
+
+// VerifyMTLS verifies an `mtls` key proof (RFC 9635 §7.3.2): the TLS client certificate
+// presented on this connection must be the one named by the client key, either by value
+// (`cert`), by thumbprint (`cert#S256`), or by matching the public key in `jwk`.
+func VerifyMTLS(r *http.Request, key types.ClientKey) error {
+	cert := PeerCertificate(r)
+	if cert == nil {
+		return ErrMissingClientCert
+	}
+
+	switch {
+	case key.Cert != "":
+		der, err := base64.StdEncoding.DecodeString(key.Cert)
+		if err != nil {
+			return fmt.Errorf("%w: cert is not base64 DER", ErrInvalidKey)
+		}
+		if subtle.ConstantTimeCompare(der, cert.Raw) != 1 {
+			return ErrCertMismatch
+		}
+		return nil
+	case key.CertS256 != "":
+		if subtle.ConstantTimeCompare([]byte(CertThumbprint(cert)), []byte(key.CertS256)) != 1 {
+			return ErrCertMismatch
+		}
+		return nil
+	case key.JWK.Kty != "":
+		pub, err := PublicKeyFromJWK(key.JWK)
+		if err != nil {
+			return err
+		}
+		eq, ok := pub.(interface{ Equal(crypto.PublicKey) bool })
+		if !ok || !eq.Equal(cert.PublicKey) {
+			return ErrCertMismatch
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: mtls requires cert, cert#S256 or jwk", ErrInvalidKey)
+	}
+}
+
+// PeerCertificate returns the leaf client certificate of a TLS request, if any.
+func PeerCertificate(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+	return r.TLS.PeerCertificates[0]
+}
+
+// CertThumbprint computes the base64url SHA-256 thumbprint of a certificate's DER encoding,
+// the value carried in `cert#S256`.
+func CertThumbprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,72 @@
+package sign
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/TwigBush/gnap-go/internal/types"
+)
+
+func selfSignedCert(t *testing.T) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatalf("create cert: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return cert, priv
+}
+
+func TestVerifyRequestProof_MTLS(t *testing.T) {
+	cert, priv := selfSignedCert(t)
+	other, _ := selfSignedCert(t)
+
+	withCert := func(c *x509.Certificate) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "https://as.example/grants", nil)
+		r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{c}}
+		return r
+	}
+
+	tests := []struct {
+		name    string
+		r       *http.Request
+		key     types.ClientKey
+		wantErr error
+	}{
+		{"cert by value", withCert(cert), types.ClientKey{Proof: ProofMTLS, Cert: base64.StdEncoding.EncodeToString(cert.Raw)}, nil},
+		{"cert thumbprint", withCert(cert), types.ClientKey{Proof: ProofMTLS, CertS256: CertThumbprint(cert)}, nil},
+		{"jwk matches cert key", withCert(cert), types.ClientKey{Proof: ProofMTLS, JWK: clientKeyFor(t, priv).JWK}, nil},
+		{"different cert", withCert(other), types.ClientKey{Proof: ProofMTLS, CertS256: CertThumbprint(cert)}, ErrCertMismatch},
+		{"no client cert", httptest.NewRequest(http.MethodPost, "http://as.example/grants", nil), types.ClientKey{Proof: ProofMTLS, CertS256: CertThumbprint(cert)}, ErrMissingClientCert},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := VerifyRequestProof(tt.r, nil, tt.key)
+			if tt.wantErr == nil && err != nil {
+				t.Fatalf("VerifyRequestProof() error = %v, want nil", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("VerifyRequestProof() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -1,8 +1,176 @@
 package sign
 
-import "net/http"
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
 
-func VerifyRequestProof(r *http.Request) error {
-	// TODO: detached JWS
-	return nil
+	"github.com/TwigBush/gnap-go/internal/gnap"
+	"github.com/TwigBush/gnap-go/internal/types"
+	"github.com/lestrrat-go/jwx/v3/jwk"
+)
+
+// Key proof methods (RFC 9635 §7.3)
+const (
+	ProofHTTPSig = "httpsig"
+	ProofJWSD    = "jwsd"
+	ProofJWS     = "jws"
+	ProofMTLS    = "mtls"
+)
+
+var (
+	ErrMissingProof          = gnap.Err("missing key proof method")
+	ErrUnsupportedProof      = gnap.Err("unsupported key proof method")
+	ErrInvalidKey            = gnap.Err("invalid client key")
+	ErrMissingSignature      = gnap.Err("missing http signature")
+	ErrInvalidSignatureInput = gnap.Err("invalid Signature-Input")
+	ErrMissingComponent      = gnap.Err("required component not covered")
+	ErrSignatureExpired      = gnap.Err("signature outside time window")
+	ErrDigestMismatch        = gnap.Err("content-digest mismatch")
+	ErrUnsupportedAlg        = gnap.Err("unsupported signature alg")
+	ErrKeyMismatch           = gnap.Err("signature alg does not match key")
+	ErrBadSignature          = gnap.Err("bad signature")
+	ErrMissingClientCert     = gnap.Err("missing TLS client certificate")
+	ErrCertMismatch          = gnap.Err("client certificate does not match key")
+)
+
+// VerifyRequestProof checks that r was sent by the holder of key, using the proof
+// method the key declares. body is the raw request body already read by the caller.
+func VerifyRequestProof(r *http.Request, body []byte, key types.ClientKey) error {
+	if key.Proof == "" {
+		return ErrMissingProof
+	}
+	// An attached JWS body is only meaningful with the jws method
+	if IsJOSE(r) && key.Proof != ProofJWS {
+		return fmt.Errorf("%w: %s content requires the %q method", ErrUnsupportedProof, JOSEContentType, ProofJWS)
+	}
+
+	var verify func(*http.Request, []byte, crypto.PublicKey) error
+	switch key.Proof {
+	case ProofMTLS:
+		return VerifyMTLS(r, key)
+	case ProofHTTPSig:
+		verify = VerifyHTTPSig
+	case ProofJWSD:
+		verify = func(r *http.Request, body []byte, pub crypto.PublicKey) error {
+			return VerifyDetachedJWS(r, body, pub, key.JWK.Kid)
+		}
+	case ProofJWS:
+		verify = func(r *http.Request, body []byte, pub crypto.PublicKey) error {
+			return VerifyAttachedJWS(r, body, pub, key.JWK.Kid)
+		}
+	default:
+		return fmt.Errorf("%w: %q", ErrUnsupportedProof, key.Proof)
+	}
+
+	pub, err := PublicKeyFromJWK(key.JWK)
+	if err != nil {
+		return err
+	}
+	return verify(r, body, pub)
+}
+
+// VerifyRotationProof checks the proof of a key rotation request (RFC 9635 §7.3.1.1):
+// the request is signed with oldKey, and signed again with newKey over the old key's
+// Signature and Signature-Input members as well. Only httpsig keys can be rotated.
+func VerifyRotationProof(r *http.Request, body []byte, oldKey, newKey types.ClientKey) error {
+	if oldKey.Proof != ProofHTTPSig || newKey.Proof != ProofHTTPSig {
+		return fmt.Errorf("%w: key rotation requires %q", ErrUnsupportedProof, ProofHTTPSig)
+	}
+	oldPub, err := PublicKeyFromJWK(oldKey.JWK)
+	if err != nil {
+		return err
+	}
+	newPub, err := PublicKeyFromJWK(newKey.JWK)
+	if err != nil {
+		return err
+	}
+	if r.Header.Get("Signature-Input") == "" || r.Header.Get("Signature") == "" {
+		return ErrMissingSignature
+	}
+	ins, err := ParseSignatureInputs(r.Header.Get("Signature-Input"))
+	if err != nil {
+		return err
+	}
+
+	// The new key's signature is the one covering another signature
+	for _, in := range ins {
+		for _, prev := range ins {
+			if prev == in {
+				continue
+			}
+			over := []string{DictionaryComponent("signature", prev.Label), DictionaryComponent("signature-input", prev.Label)}
+			if in.Covers(over...) != nil {
+				continue
+			}
+			if err := verifyHTTPSigInput(r, body, prev, oldPub); err != nil {
+				return fmt.Errorf("old key: %w", err)
+			}
+			if err := verifyHTTPSigInput(r, body, in, newPub, over...); err != nil {
+				return fmt.Errorf("new key: %w", err)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: no signature by the new key over the old key's signature", ErrMissingSignature)
+}
+
+// PublicKeyFromJWK converts a client JWK into a public key usable for verification.
+func PublicKeyFromJWK(j types.JWK) (crypto.PublicKey, error) {
+	b, err := json.Marshal(j)
+	if err != nil {
+		return nil, err
+	}
+	var raw any
+	if err := jwk.ParseRawKey(b, &raw); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+	switch k := raw.(type) {
+	case ed25519.PublicKey:
+		return k, nil
+	case *ecdsa.PublicKey:
+		return k, nil
+	default:
+		return nil, fmt.Errorf("%w: unsupported key type %T", ErrInvalidKey, k)
+	}
+}
+
+// KeyThumbprint identifies a client key: the base64url RFC 7638 SHA-256 thumbprint of
+// its JWK, or for certificate-only mtls keys the certificate thumbprint.
+func KeyThumbprint(key types.ClientKey) (string, error) {
+	switch {
+	case key.JWK.Kty != "":
+		b, err := json.Marshal(key.JWK)
+		if err != nil {
+			return "", err
+		}
+		k, err := jwk.ParseKey(b)
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", ErrInvalidKey, err)
+		}
+		sum, err := k.Thumbprint(crypto.SHA256)
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", ErrInvalidKey, err)
+		}
+		return base64.RawURLEncoding.EncodeToString(sum), nil
+	case key.Cert != "":
+		der, err := base64.StdEncoding.DecodeString(key.Cert)
+		if err != nil {
+			return "", fmt.Errorf("%w: cert is not base64 DER", ErrInvalidKey)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", ErrInvalidKey, err)
+		}
+		return CertThumbprint(cert), nil
+	case key.CertS256 != "":
+		return key.CertS256, nil
+	default:
+		return "", ErrInvalidKey
+	}
 }
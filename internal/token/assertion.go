@@ -0,0 +1,42 @@
+package token
+
+import (
+	"time"
+
+	"github.com/TwigBush/gnap-go/internal/jwks"
+)
+
+// AssertionTTL is how long identity assertions are valid for.
+const AssertionTTL = 5 * time.Minute
+
+// AssertionConfig describes an identity assertion: who it is about and who it is for.
+type AssertionConfig struct {
+	Issuer   string
+	Subject  string // the user's identifier as the client knows it
+	Audience string // the client
+}
+
+// IssueAssertion mints an ID-token-style JWT asserting the user's identity to the
+// client, signed with the AS signing keys.
+func IssueAssertion(keys *jwks.KeySet, cfg AssertionConfig) (string, error) {
+	jti, err := randomValue()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	claims := jwks.Claims{
+		Issuer:   cfg.Issuer,
+		Subject:  cfg.Subject,
+		IssuedAt: now.Unix(),
+		Expiry:   now.Add(AssertionTTL).Unix(),
+		ID:       jti,
+	}
+	if cfg.Audience != "" {
+		claims.Audience = jwks.Audience{cfg.Audience}
+	}
+	signed, err := keys.Sign(claims)
+	if err != nil {
+		return "", err
+	}
+	return string(signed), nil
+}
@@ -0,0 +1,75 @@
+package token
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/TwigBush/gnap-go/internal/jwks"
+	"github.com/lestrrat-go/jwx/v3/jwk"
+	"github.com/lestrrat-go/jwx/v3/jws"
+)
+
+func TestIssueAssertion(t *testing.T) {
+	keys, err := jwks.Load(t.TempDir())
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	other, err := jwks.Load(t.TempDir())
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	// Verifiers get the keys the way a client would, from the published JWKS
+	srv := httptest.NewServer(keys)
+	defer srv.Close()
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("GET jwks: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	published, err := jwk.Parse(body)
+	if err != nil || published.Len() != 1 {
+		t.Fatalf("published JWKS = %s, %v", body, err)
+	}
+	if k, _ := published.Key(0); k != nil {
+		if _, ok := k.(jwk.ECDSAPrivateKey); ok {
+			t.Fatalf("JWKS publishes a private key")
+		}
+	}
+
+	assertion, err := IssueAssertion(keys, AssertionConfig{Issuer: "http://as.example", Subject: "pairwise-1", Audience: "client-1"})
+	if err != nil {
+		t.Fatalf("IssueAssertion: %v", err)
+	}
+
+	payload, err := jws.Verify([]byte(assertion), jws.WithKeySet(published))
+	if err != nil {
+		t.Fatalf("verify against JWKS: %v", err)
+	}
+	var claims jwks.Claims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		t.Fatalf("claims: %v", err)
+	}
+	if claims.Issuer != "http://as.example" || len(claims.Audience) != 1 || claims.Audience[0] != "client-1" {
+		t.Fatalf("iss, aud = %q, %v, want http://as.example, client-1", claims.Issuer, claims.Audience)
+	}
+	if claims.Subject != "pairwise-1" {
+		t.Fatalf("sub = %q, want pairwise-1", claims.Subject)
+	}
+	if claims.Expiry == 0 || claims.ID == "" {
+		t.Fatalf("assertion has no exp or jti: %+v", claims)
+	}
+
+	// Signed by another AS, it must not verify
+	forged, err := IssueAssertion(other, AssertionConfig{Issuer: "http://as.example", Subject: "pairwise-1", Audience: "client-1"})
+	if err != nil {
+		t.Fatalf("IssueAssertion: %v", err)
+	}
+	if _, err := jws.Verify([]byte(forged), jws.WithKeySet(published)); err == nil {
+		t.Fatalf("assertion signed with another key verified")
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"encoding/json"
 	"log"
+	"slices"
+	"time"
 
 	"github.com/TwigBush/gnap-go/internal/gnap"
 	"github.com/TwigBush/gnap-go/internal/types"
@@ -15,6 +17,7 @@ type IssueConfig struct {
 	TokenTTLSeconds int
 	BoundProof      string          // "httpsig",  etc.
 	ClientJWK       json.RawMessage // the client's bound key
+	ClientCertS256  string          // or, for mtls, its certificate thumbprint
 }
 
 func IssueToken(ctx context.Context, store *gnap.TokenStoreContainer, grant *types.GrantState, cfg IssueConfig) ([]*Token, error) {
@@ -28,24 +31,58 @@ func IssueToken(ctx context.Context, store *gnap.TokenStoreContainer, grant *typ
 	for _, g := range grant.ApprovedAccess {
 		log.Printf("Issuing token for grant: %v", g)
 
+		// Tokens are bound to the client key unless the client asked for a bearer token
+		boundProof, clientJWK, certS256 := cfg.BoundProof, cfg.ClientJWK, cfg.ClientCertS256
+		var flags []string
+		if g.HasFlag(types.FlagBearer) {
+			boundProof, clientJWK, certS256 = "", nil, ""
+			flags = append(flags, types.FlagBearer)
+		}
+		if g.HasFlag(types.FlagDurable) {
+			flags = append(flags, types.FlagDurable)
+		}
+
+		// Each token gets its own management URI and management access token
+		manageID, err := randomValue()
+		if err != nil {
+			return nil, err
+		}
+		manageToken, err := randomValue()
+		if err != nil {
+			return nil, err
+		}
+
 		// Generate opaque token using the new pattern
 		tokenValue, err := IssueOpaqueToken(ctx, store, g.Access, IssueOpaqueConfig{
 			Issuer:          cfg.Issuer,
 			Audience:        cfg.Audience,
 			TokenTTLSeconds: cfg.TokenTTLSeconds,
-			BoundProof:      cfg.BoundProof,
-			ClientJWK:       cfg.ClientJWK,
+			BoundProof:      boundProof,
+			ClientJWK:       clientJWK,
+			ClientCertS256:  certS256,
 			Subject:         subjectOrAnon(grant.Subject),
+			Tenant:          grant.Tenant,
 			InstanceID:      grant.ID,
+			Label:           g.Label,
+			Flags:           flags,
+			ManageID:        manageID,
+			ManageTokenHash: Hash(manageToken),
 		})
 		if err != nil {
 			return nil, err
 		}
 
 		t := &Token{
-			Value:  tokenValue,
-			Access: g.Access,
-			Label:  g.Label,
+			Value:     tokenValue,
+			Access:    g.Access,
+			Label:     g.Label,
+			ExpiresIn: int64(cfg.TokenTTLSeconds),
+			Key:       boundKey(boundProof, clientJWK, certS256),
+			Flags:     flags,
+			Manage: &Manage{
+				URI:         cfg.Issuer + ManagePath(manageID),
+				AccessToken: &ManageToken{Value: manageToken},
+			},
 		}
 		tokens = append(tokens, t)
 	}
@@ -53,11 +90,71 @@ func IssueToken(ctx context.Context, store *gnap.TokenStoreContainer, grant *typ
 	return tokens, nil
 }
 
-var ErrNotApproved = gnap.Err("grant not approved")
+var ErrNotApproved = gnap.NewError(gnap.CodeInvalidContinuation, "grant not approved")
+
+var ErrTokenInactive = gnap.NewError(gnap.CodeInvalidRotation, "access token revoked or expired")
+
+// RotateToken replaces rec with a fresh token value carrying the same access, binding
+// and management URI (RFC 9635 §6.1). The old value is revoked unless the token is
+// durable, in which case it keeps working until it expires but is no longer managed.
+func RotateToken(ctx context.Context, store *gnap.TokenStoreContainer, rec *gnap.TokenRecord) (*Token, error) {
+	now := time.Now().Unix()
+	if rec.Revoked || (rec.Exp != 0 && rec.Exp <= now) {
+		return nil, ErrTokenInactive
+	}
+
+	cfg := IssueOpaqueConfig{
+		Issuer:          rec.Iss,
+		Audience:        rec.Aud,
+		TokenTTLSeconds: int(rec.Exp - rec.Iat),
+		Subject:         rec.Sub,
+		Tenant:          rec.Tenant,
+		InstanceID:      rec.InstanceID,
+		Label:           rec.Label,
+		Flags:           rec.Flags,
+		ManageID:        rec.ManageID,
+		ManageTokenHash: rec.ManageTokenHash,
+	}
+	if rec.BoundKey != nil {
+		cfg.BoundProof = rec.BoundProof
+		cfg.ClientJWK = rec.BoundKey.JWK
+		cfg.ClientCertS256 = rec.BoundKey.CertS256
+	} else if len(cfg.Flags) == 0 {
+		// Tokens issued before flags were recorded are bearer tokens when unbound
+		cfg.Flags = []string{types.FlagBearer}
+	}
+
+	tokenValue, err := IssueOpaqueToken(ctx, store, rec.Access, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if slices.Contains(rec.Flags, types.FlagDurable) {
+		old := *rec
+		old.ManageID, old.ManageTokenHash = "", ""
+		if err := store.Put(ctx, rec.HashB64, &old); err != nil {
+			return nil, err
+		}
+	} else if err := store.Revoke(ctx, rec.HashB64); err != nil {
+		return nil, err
+	}
+
+	return &Token{
+		Value:     tokenValue,
+		Access:    rec.Access,
+		Label:     rec.Label,
+		ExpiresIn: int64(cfg.TokenTTLSeconds),
+		Key:       boundKey(cfg.BoundProof, cfg.ClientJWK, cfg.ClientCertS256),
+		Flags:     cfg.Flags,
+		Manage:    &Manage{URI: rec.Iss + ManagePath(rec.ManageID)},
+	}, nil
+}
+
+// AnonymousSubject is the subject of tokens issued without a known account.
+const AnonymousSubject = "anonymous"
 
 func subjectOrAnon(s *string) string {
 	if s == nil || *s == "" {
-		return "anonymous"
+		return AnonymousSubject
 	}
 	return *s
 }
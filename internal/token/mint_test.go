@@ -0,0 +1,134 @@
+package token
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"slices"
+	"testing"
+
+	"github.com/TwigBush/gnap-go/internal/gnap"
+	"github.com/TwigBush/gnap-go/internal/types"
+)
+
+func TestIssueToken_Binding(t *testing.T) {
+	store, err := gnap.NewTokenStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewTokenStore: %v", err)
+	}
+	jwk := json.RawMessage(`{"kty":"OKP","crv":"Ed25519","x":"abc"}`)
+
+	tests := []struct {
+		name      string
+		flags     []string
+		wantBound bool
+	}{
+		{"bound by default", nil, true},
+		{"bearer opts out", []string{types.FlagBearer}, false},
+		{"durable stays bound", []string{types.FlagDurable}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			grant := &types.GrantState{
+				ID: "g1",
+				ApprovedAccess: types.AccessTokenRequest{{
+					Access: []types.AccessItem{{Type: "photo-api"}},
+					Flags:  tt.flags,
+				}},
+			}
+			toks, err := IssueToken(context.Background(), store, grant, IssueConfig{
+				Issuer:          "http://as.example",
+				TokenTTLSeconds: 60,
+				BoundProof:      "httpsig",
+				ClientJWK:       jwk,
+			})
+			if err != nil || len(toks) != 1 {
+				t.Fatalf("IssueToken() = %v, %v", toks, err)
+			}
+
+			sum := sha256.Sum256([]byte(toks[0].Value))
+			rec, err := store.GetByHash(context.Background(), base64.RawURLEncoding.EncodeToString(sum[:]))
+			if err != nil || rec == nil {
+				t.Fatalf("GetByHash: %v", err)
+			}
+			if bound := rec.BoundKey != nil; bound != tt.wantBound {
+				t.Fatalf("bound = %v, want %v", bound, tt.wantBound)
+			}
+			if tt.wantBound && (rec.BoundProof != "httpsig" || string(rec.BoundKey.JWK) != string(jwk)) {
+				t.Fatalf("BoundKey = %+v, want httpsig with client jwk", rec.BoundKey)
+			}
+			if (toks[0].Key != nil) != tt.wantBound || toks[0].ExpiresIn != 60 {
+				t.Fatalf("token key = %+v, expires_in = %d, want bound %v and 60", toks[0].Key, toks[0].ExpiresIn, tt.wantBound)
+			}
+			if !slices.Equal(toks[0].Flags, tt.flags) || !slices.Equal(rec.Flags, tt.flags) {
+				t.Fatalf("Flags = %v, recorded %v, want %v", toks[0].Flags, rec.Flags, tt.flags)
+			}
+		})
+	}
+}
+
+func TestRotateToken(t *testing.T) {
+	ctx := context.Background()
+	store, err := gnap.NewTokenStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewTokenStore: %v", err)
+	}
+	grant := &types.GrantState{
+		ID:             "g1",
+		ApprovedAccess: types.AccessTokenRequest{{Label: "photos", Access: []types.AccessItem{{Type: "photo-api"}}}},
+	}
+	toks, err := IssueToken(ctx, store, grant, IssueConfig{
+		Issuer:          "http://as.example",
+		TokenTTLSeconds: 60,
+		BoundProof:      "httpsig",
+		ClientJWK:       json.RawMessage(`{"kty":"OKP","crv":"Ed25519","x":"abc"}`),
+	})
+	if err != nil {
+		t.Fatalf("IssueToken: %v", err)
+	}
+	old := toks[0]
+	if old.Manage == nil || old.Manage.AccessToken == nil {
+		t.Fatalf("issued token has no manage info: %+v", old)
+	}
+
+	rec, _ := store.GetByHash(ctx, Hash(old.Value))
+	rotated, err := RotateToken(ctx, store, rec)
+	if err != nil {
+		t.Fatalf("RotateToken: %v", err)
+	}
+	if rotated.Value == old.Value || rotated.Manage.URI != old.Manage.URI || rotated.Label != "photos" {
+		t.Fatalf("rotated = %+v, want new value under %s", rotated, old.Manage.URI)
+	}
+
+	rec, _ = store.GetByHash(ctx, Hash(old.Value))
+	if !rec.Revoked {
+		t.Fatalf("old token not revoked")
+	}
+	live, _ := store.GetByManageID(ctx, rec.ManageID)
+	if live == nil || live.HashB64 != Hash(rotated.Value) || live.BoundKey == nil {
+		t.Fatalf("GetByManageID() = %+v, want the rotated, still bound token", live)
+	}
+
+	if _, err := RotateToken(ctx, store, rec); err != ErrTokenInactive {
+		t.Fatalf("rotating a revoked token error = %v, want %v", err, ErrTokenInactive)
+	}
+
+	// A durable token's old value outlives the rotation, but not its management URI
+	manageID := live.ManageID
+	live.Flags = []string{types.FlagDurable}
+	if err := store.Put(ctx, live.HashB64, live); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	durable, err := RotateToken(ctx, store, live)
+	if err != nil {
+		t.Fatalf("RotateToken(durable): %v", err)
+	}
+	if rec, _ = store.GetByHash(ctx, Hash(rotated.Value)); rec.Revoked || rec.ManageID != "" {
+		t.Fatalf("old durable token = %+v, want live and unmanaged", rec)
+	}
+	if live, _ = store.GetByManageID(ctx, manageID); live == nil || live.HashB64 != Hash(durable.Value) {
+		t.Fatalf("GetByManageID() = %+v, want the rotated durable token", live)
+	}
+}
@@ -19,25 +19,26 @@ type IssueOpaqueConfig struct {
 	TokenTTLSeconds int
 	BoundProof      string          // "httpsig", "dpop", etc.
 	ClientJWK       json.RawMessage // the client's bound key
+	ClientCertS256  string          // or, for mtls, its certificate thumbprint
 	Subject         string
+	Tenant          string // tenant the grant was made in
 	InstanceID      string
+	Label           string
+	Flags           []string
+	ManageID        string // token management URI path segment
+	ManageTokenHash string // hash of the token management access token
 }
 
 // IssueOpaqueToken generates a cryptographically random opaque token,
 // stores only its hash with the token metadata, and returns the token value.
 func IssueOpaqueToken(ctx context.Context, store *gnap.TokenStoreContainer, access []types.AccessItem, cfg IssueOpaqueConfig) (string, error) {
-	// Generate 32 random bytes
-	raw := make([]byte, 32)
-	if _, err := rand.Read(raw); err != nil {
+	tokenValue, err := randomValue()
+	if err != nil {
 		return "", err
 	}
 
-	// Encode as base64url - this is what the client receives
-	tokenValue := base64.RawURLEncoding.EncodeToString(raw)
-
 	// Hash the token value - this is what we store
-	sum := sha256.Sum256([]byte(tokenValue))
-	hashB64 := base64.RawURLEncoding.EncodeToString(sum[:])
+	hashB64 := Hash(tokenValue)
 
 	now := time.Now().Unix()
 	exp := now + int64(cfg.TokenTTLSeconds)
@@ -49,20 +50,23 @@ func IssueOpaqueToken(ctx context.Context, store *gnap.TokenStoreContainer, acce
 		Access:     access,
 		Aud:        cfg.Audience,
 		Sub:        cfg.Subject,
+		Tenant:     cfg.Tenant,
 		InstanceID: cfg.InstanceID,
+		Label:      cfg.Label,
+		Flags:      cfg.Flags,
 		Iat:        now,
 		Exp:        exp,
 		Nbf:        now,
 		Revoked:    false,
+
+		ManageID:        cfg.ManageID,
+		ManageTokenHash: cfg.ManageTokenHash,
 	}
 
 	// Add key binding if provided
-	if cfg.BoundProof != "" && len(cfg.ClientJWK) > 0 {
+	if key := boundKey(cfg.BoundProof, cfg.ClientJWK, cfg.ClientCertS256); key != nil {
 		record.BoundProof = cfg.BoundProof
-		record.BoundKey = &gnap.BoundKey{
-			Proof: cfg.BoundProof,
-			JWK:   cfg.ClientJWK,
-		}
+		record.BoundKey = key
 	}
 
 	// Store by hash
@@ -73,3 +77,27 @@ func IssueOpaqueToken(ctx context.Context, store *gnap.TokenStoreContainer, acce
 	// Return the actual token value to the client
 	return tokenValue, nil
 }
+
+// Hash returns the base64url SHA-256 of a token value, the form tokens are stored under.
+func Hash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// randomValue generates 32 random bytes encoded as base64url - this is what the client receives
+func randomValue() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// boundKey is the key a token issued with proof and key material is bound to, or nil
+// for a bearer token.
+func boundKey(proof string, jwk json.RawMessage, certS256 string) *gnap.BoundKey {
+	if proof == "" || (len(jwk) == 0 && certS256 == "") {
+		return nil
+	}
+	return &gnap.BoundKey{Proof: proof, JWK: jwk, CertS256: certS256}
+}
@@ -1,9 +1,33 @@
 package token
 
-import "github.com/TwigBush/gnap-go/internal/types"
+import (
+	"github.com/TwigBush/gnap-go/internal/gnap"
+	"github.com/TwigBush/gnap-go/internal/types"
+)
 
+// Token is an access token in a grant or rotation response (RFC 9635 §3.2.1).
 type Token struct {
-	Value  string             `json:"value"`
-	Access []types.AccessItem `json:"access"`
-	Label  string             `json:"label"`
+	Value     string             `json:"value"`
+	Label     string             `json:"label,omitempty"` // set when the client asked for several tokens
+	Access    []types.AccessItem `json:"access"`
+	ExpiresIn int64              `json:"expires_in,omitempty"` // seconds
+	Key       *gnap.BoundKey     `json:"key,omitempty"`        // the key the token is bound to; none for bearer tokens
+	Flags     []string           `json:"flags,omitempty"`
+	Manage    *Manage            `json:"manage,omitempty"`
+}
+
+// Manage tells the client where and how to manage an access token (RFC 9635 §3.2.1)
+type Manage struct {
+	URI         string       `json:"uri"`
+	AccessToken *ManageToken `json:"access_token,omitempty"`
+}
+
+// ManageToken is the token management access token, presented as Authorization: GNAP
+type ManageToken struct {
+	Value string `json:"value"`
+}
+
+// ManagePath is the path of the token management URI for manageID.
+func ManagePath(manageID string) string {
+	return "/token/" + manageID
 }
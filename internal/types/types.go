@@ -1,6 +1,7 @@
 package types
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"time"
@@ -9,25 +10,84 @@ import (
 type GrantStatus string
 
 const (
-	GrantStatusPending  GrantStatus = "pending"
-	GrantStatusApproved GrantStatus = "approved"
-	GrantStatusDenied   GrantStatus = "denied"
-	GrantStatusExpired  GrantStatus = "expired"
+	GrantStatusProcessing GrantStatus = "processing"
+	GrantStatusPending    GrantStatus = "pending"
+	GrantStatusApproved   GrantStatus = "approved"
+	GrantStatusFinalized  GrantStatus = "finalized"
+	GrantStatusDenied     GrantStatus = "denied"
+	GrantStatusExpired    GrantStatus = "expired"
+	GrantStatusRevoked    GrantStatus = "revoked"
 )
 
+// grantTransitions is the grant state machine (RFC 9635 §1.5). A grant is processing
+// while the AS evaluates it, pending while it waits on the user, approved once the user
+// agrees, and finalized once its tokens are issued. Modification can send approved and
+// finalized grants back through approval. Denied, expired and revoked are terminal.
+var grantTransitions = map[GrantStatus][]GrantStatus{
+	GrantStatusProcessing: {GrantStatusPending, GrantStatusApproved, GrantStatusDenied, GrantStatusExpired, GrantStatusRevoked},
+	GrantStatusPending:    {GrantStatusPending, GrantStatusApproved, GrantStatusDenied, GrantStatusExpired, GrantStatusRevoked},
+	GrantStatusApproved:   {GrantStatusPending, GrantStatusApproved, GrantStatusFinalized, GrantStatusExpired, GrantStatusRevoked},
+	GrantStatusFinalized:  {GrantStatusPending, GrantStatusApproved, GrantStatusRevoked},
+}
+
+// Terminal reports whether a grant in status s has ended for good.
+func (s GrantStatus) Terminal() bool {
+	return s == GrantStatusDenied || s == GrantStatusExpired || s == GrantStatusRevoked
+}
+
+// CanTransition reports whether a grant in status s may move to status to.
+func (s GrantStatus) CanTransition(to GrantStatus) bool {
+	for _, next := range grantTransitions[s] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
 type JWK struct {
 	Kty string `json:"kty"`
 	Crv string `json:"crv,omitempty"`
 	X   string `json:"x,omitempty"`
 	Y   string `json:"y,omitempty"`
+	Kid string `json:"kid,omitempty"`
 }
 
 type ClientKey struct {
-	Proof string `json:"proof"`
-	JWK   JWK    `json:"jwk"`
+	Proof    string `json:"proof"`
+	JWK      JWK    `json:"jwk"`
+	Cert     string `json:"cert,omitempty"`      // base64 DER X.509 certificate, for mtls
+	CertS256 string `json:"cert#S256,omitempty"` // base64url SHA-256 thumbprint of cert, for mtls
+}
+
+// HasKeyMaterial reports whether the key carries a JWK or certificate the AS can verify against.
+func (k ClientKey) HasKeyMaterial() bool {
+	return k.JWK.Kty != "" || k.Cert != "" || k.CertS256 != ""
 }
+
 type Client struct {
-	Key ClientKey `json:"key"`
+	Key        ClientKey      `json:"key"`
+	InstanceID string         `json:"instance_id,omitempty"` // set once the client is known to the AS
+	Display    *ClientDisplay `json:"display,omitempty"`
+}
+
+// UnmarshalJSON accepts the client as an object or, by reference, as the instance_id
+// the AS knows it by (RFC 9635 §2.3.1).
+func (c *Client) UnmarshalJSON(data []byte) error {
+	var ref string
+	if err := json.Unmarshal(data, &ref); err == nil {
+		*c = Client{InstanceID: ref}
+		return nil
+	}
+	type plain Client
+	return json.Unmarshal(data, (*plain)(c))
+}
+
+// ClientDisplay is how a client presents itself to the user (RFC 9635 §2.3.2).
+type ClientDisplay struct {
+	Name    string `json:"name,omitempty"`
+	URI     string `json:"uri,omitempty"`
+	LogoURI string `json:"logo_uri,omitempty"`
 }
 
 type GrantedAccess struct {
@@ -41,7 +101,115 @@ type GrantedAccess struct {
 type AccessConstraint map[string]string
 
 type Interact struct {
-	Start []string `json:"start,omitempty"`
+	Start  []string        `json:"start,omitempty"`
+	Finish *InteractFinish `json:"finish,omitempty"`
+}
+
+// InteractFinish is how the client wants to learn that the user is done (RFC 9635 §2.5.2)
+type InteractFinish struct {
+	Method     string `json:"method"`
+	URI        string `json:"uri"`
+	Nonce      string `json:"nonce"`
+	HashMethod string `json:"hash_method,omitempty"`
+}
+
+// Interaction start modes and finish methods (RFC 9635 §2.5)
+const (
+	InteractStartRedirect    = "redirect"
+	InteractStartApp         = "app"
+	InteractStartUserCode    = "user_code"
+	InteractStartUserCodeURI = "user_code_uri"
+
+	InteractFinishRedirect = "redirect"
+	InteractFinishPush     = "push"
+)
+
+// HasStart reports whether the client asked for start mode m.
+func (i *Interact) HasStart(m string) bool {
+	if i == nil {
+		return false
+	}
+	for _, v := range i.Start {
+		if v == m {
+			return true
+		}
+	}
+	return false
+}
+
+// FinishMethod returns the requested finish method, or "" when the client will poll.
+func (i *Interact) FinishMethod() string {
+	if i == nil || i.Finish == nil {
+		return ""
+	}
+	return i.Finish.Method
+}
+
+// SubjectRequest asks for identifiers of the end user (RFC 9635 §2.2).
+type SubjectRequest struct {
+	SubIDFormats     []string `json:"sub_id_formats,omitempty"`
+	AssertionFormats []string `json:"assertion_formats,omitempty"`
+	SubIDs           []SubID  `json:"sub_ids,omitempty"` // identifiers the client expects the user to have
+}
+
+// SubID is a subject identifier (RFC 9493). Which fields are set depends on Format.
+type SubID struct {
+	Format string `json:"format"`
+	ID     string `json:"id,omitempty"`    // opaque, public and pairwise
+	Email  string `json:"email,omitempty"` // email
+	Iss    string `json:"iss,omitempty"`   // iss_sub
+	Sub    string `json:"sub,omitempty"`   // iss_sub
+}
+
+// Subject identifier formats. public and pairwise carry an id like opaque; a pairwise
+// id differs for each client so clients cannot correlate the user.
+const (
+	SubIDFormatOpaque   = "opaque"
+	SubIDFormatEmail    = "email"
+	SubIDFormatIssSub   = "iss_sub"
+	SubIDFormatPublic   = "public"
+	SubIDFormatPairwise = "pairwise"
+)
+
+// AssertionFormatJWT is an identity assertion in the form of an OpenID Connect ID token.
+const AssertionFormatJWT = "jwt"
+
+// Assertion is an identity assertion about the end user.
+type Assertion struct {
+	Format string `json:"format"`
+	Value  string `json:"value"`
+}
+
+// SubjectInfo is the subject information returned to the client (RFC 9635 §3.4).
+type SubjectInfo struct {
+	SubIDs     []SubID     `json:"sub_ids,omitempty"`
+	Assertions []Assertion `json:"assertions,omitempty"`
+}
+
+// UserRequest tells the AS who the client believes the end user is (RFC 9635 §2.4):
+// identifiers, assertions, or a reference the AS handed out earlier.
+type UserRequest struct {
+	SubIDs     []SubID     `json:"sub_ids,omitempty"`
+	Assertions []Assertion `json:"assertions,omitempty"`
+	Ref        string      `json:"-"` // the user by reference
+}
+
+// UnmarshalJSON accepts the user as an object or, by reference, as a string.
+func (u *UserRequest) UnmarshalJSON(data []byte) error {
+	var ref string
+	if err := json.Unmarshal(data, &ref); err == nil {
+		*u = UserRequest{Ref: ref}
+		return nil
+	}
+	type plain UserRequest
+	return json.Unmarshal(data, (*plain)(u))
+}
+
+// UserHint is the account the AS expects at interaction, from the client's user request.
+type UserHint struct {
+	Account  string `json:"account"`
+	Verified bool   `json:"verified,omitempty"` // vouched for by an assertion from a trusted issuer
+	Issuer   string `json:"issuer,omitempty"`   // that issuer
 }
 
 type AccessToken struct {
@@ -50,11 +218,30 @@ type AccessToken struct {
 	Flags  []string     `json:"flags,omitempty"`
 }
 
+// Access token flags (RFC 9635 §2.1.1)
+const (
+	// FlagBearer asks for a token that is not bound to the client key
+	FlagBearer = "bearer"
+	// FlagDurable asks for a token that keeps working when the client rotates its key
+	FlagDurable = "durable"
+)
+
+// HasFlag reports whether the token request carries flag f.
+func (t AccessToken) HasFlag(f string) bool {
+	for _, v := range t.Flags {
+		if v == f {
+			return true
+		}
+	}
+	return false
+}
+
 // AccessTokenRequest can be either a single AccessToken or an array of AccessTokens
 type AccessTokenRequest []AccessToken
 
-// UnmarshalJSON Custom unmarshaling to handle both single object and array formats
-// todo - revist this for single vs multiple access token issuance
+// UnmarshalJSON accepts a single token request object or an array of them. Which form
+// the client used is recorded by the enclosing request, since it decides the shape of
+// the response (RFC 9635 §3.2).
 func (a *AccessTokenRequest) UnmarshalJSON(data []byte) error {
 	// Try to unmarshal as array first
 	var tokens []AccessToken
@@ -81,31 +268,128 @@ type AccessItem struct {
 	Datatypes   []string        `json:"datatypes,omitempty"`
 	Identifier  string          `json:"identifier,omitempty"`
 	Constraints json.RawMessage `json:"constraints,omitempty"`
+	Ref         string          `json:"-"` // the access by reference, before the AS expands it
+}
+
+// UnmarshalJSON accepts an access item as an object or, by reference, as a string
+// naming access the AS knows (RFC 9635 §8.1).
+func (a *AccessItem) UnmarshalJSON(data []byte) error {
+	var ref string
+	if err := json.Unmarshal(data, &ref); err == nil {
+		*a = AccessItem{Ref: ref}
+		return nil
+	}
+	type plain AccessItem
+	return json.Unmarshal(data, (*plain)(a))
+}
+
+// MarshalJSON writes an unexpanded reference back as a string.
+func (a AccessItem) MarshalJSON() ([]byte, error) {
+	if a.Ref != "" && a.Type == "" {
+		return json.Marshal(a.Ref)
+	}
+	type plain AccessItem
+	return json.Marshal(plain(a))
 }
 
 type GrantRequest struct {
 	AccessToken AccessTokenRequest `json:"access_token"`
 	Client      Client             `json:"client"`
 	Interact    *Interact          `json:"interact,omitempty"`
+	Subject     *SubjectRequest    `json:"subject,omitempty"`
+	User        *UserRequest       `json:"user,omitempty"`
 	TokenFormat string             `json:"token_format,omitempty"`
+
+	// AccessRefs are the access references in AccessToken, with the items each
+	// expanded to. Filled in by the AS.
+	AccessRefs map[string][]AccessItem `json:"-"`
+
+	// MultipleTokens is set when AccessToken was sent as an array, asking for several
+	// labeled tokens (RFC 9635 §2.1.2) rather than one.
+	MultipleTokens bool `json:"-"`
+}
+
+// UnmarshalJSON also records whether access_token asked for one token or several.
+func (g *GrantRequest) UnmarshalJSON(data []byte) error {
+	type plain GrantRequest
+	if err := json.Unmarshal(data, (*plain)(g)); err != nil {
+		return err
+	}
+	var err error
+	g.MultipleTokens, err = multipleTokens(data)
+	return err
+}
+
+// multipleTokens reports whether the access_token member of a request is in the array form.
+func multipleTokens(data []byte) (bool, error) {
+	var raw struct {
+		AccessToken json.RawMessage `json:"access_token"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return false, err
+	}
+	return bytes.HasPrefix(bytes.TrimSpace(raw.AccessToken), []byte("[")), nil
 }
 
 type GrantState struct {
-	ID                    string             `json:"id"`
-	Status                GrantStatus        `json:"status"`
-	Client                Client             `json:"client"`
-	RequestedAccess       AccessTokenRequest `json:"requested_access"`
-	ApprovedAccess        AccessTokenRequest `json:"approved_access,omitempty"` // set when approved
-	Subject               *string            `json:"subject,omitempty"`         // e.g. sub id
-	ContinuationToken     string             `json:"continuation_token"`
-	TokenFormat           string             `json:"token_format"`
-	CreatedAt             time.Time          `json:"created_at"`
-	UpdatedAt             time.Time          `json:"updated_at"`
-	ExpiresAt             time.Time          `json:"expires_at"`
-	Locations             []string           `json:"locations,omitempty"`
-	UserCode              *string            `json:"user_code,omitempty"`
-	ApprovedAccessGranted []GrantedAccess    `json:"approved_access_granted,omitempty"`
-	CodeVerified          bool               `json:"code_verified"`
+	ID                    string                  `json:"id"`
+	Status                GrantStatus             `json:"status"`
+	Tenant                string                  `json:"tenant,omitempty"`
+	Client                Client                  `json:"client"`
+	RequestedAccess       AccessTokenRequest      `json:"requested_access"`
+	ApprovedAccess        AccessTokenRequest      `json:"approved_access,omitempty"` // set when approved
+	AccessRefs            map[string][]AccessItem `json:"access_refs,omitempty"`     // references the client asked for, as expanded
+	MultipleTokens        bool                    `json:"multiple_tokens,omitempty"` // tokens are returned as a labeled array
+	Subject               *string                 `json:"subject,omitempty"`         // e.g. sub id
+	SubjectRequest        *SubjectRequest         `json:"subject_request,omitempty"` // as requested by the client
+	SubjectShared         bool                    `json:"subject_shared,omitempty"`  // the user agreed to share Subject
+	User                  *UserHint               `json:"user,omitempty"`            // who the client said is present
+	ContinuationToken     string                  `json:"continuation_token"`
+	TokenFormat           string                  `json:"token_format"`
+	CreatedAt             time.Time               `json:"created_at"`
+	UpdatedAt             time.Time               `json:"updated_at"`
+	ExpiresAt             time.Time               `json:"expires_at"`
+	Locations             []string                `json:"locations,omitempty"`
+	UserCode              *string                 `json:"user_code,omitempty"`
+	ApprovedAccessGranted []GrantedAccess         `json:"approved_access_granted,omitempty"`
+	CodeVerified          bool                    `json:"code_verified"`
+	Interact              *Interact               `json:"interact,omitempty"`       // as requested by the client
+	InteractID            string                  `json:"interact_id,omitempty"`    // path of the redirect interaction URI
+	InteractNonce         string                  `json:"interact_nonce,omitempty"` // AS nonce returned as interact.finish
+	InteractRef           string                  `json:"interact_ref,omitempty"`   // set once the interaction finishes
+	PushDeliveries        []PushDelivery          `json:"push_deliveries,omitempty"`
+	PolledAt              time.Time               `json:"polled_at"`           // when the client was last told to wait
+	PollWait              int                     `json:"poll_wait,omitempty"` // seconds; raised for clients that poll too early
+}
+
+// PushDelivery records one attempt to deliver a push interaction finish to the client.
+type PushDelivery struct {
+	Attempt   int       `json:"attempt"`
+	At        time.Time `json:"at"`
+	Status    int       `json:"status,omitempty"` // HTTP status returned by the client, if any
+	Delivered bool      `json:"delivered"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// ContinueRequest is the body of a continuation (RFC 9635 §5.1) or grant
+// modification (§5.3) request
+type ContinueRequest struct {
+	InteractRef string             `json:"interact_ref,omitempty"`
+	AccessToken AccessTokenRequest `json:"access_token,omitempty"`
+	Interact    *Interact          `json:"interact,omitempty"`
+
+	MultipleTokens bool `json:"-"` // AccessToken was sent as an array
+}
+
+// UnmarshalJSON also records whether access_token asked for one token or several.
+func (c *ContinueRequest) UnmarshalJSON(data []byte) error {
+	type plain ContinueRequest
+	if err := json.Unmarshal(data, (*plain)(c)); err != nil {
+		return err
+	}
+	var err error
+	c.MultipleTokens, err = multipleTokens(data)
+	return err
 }
 
 type Config struct {
@@ -119,14 +403,45 @@ type ErrorResponse struct {
 }
 
 type GrantStore interface {
-	CreateGrant(ctx context.Context, req GrantRequest) (*GrantState, error)
+	// CreateGrant starts a grant for req in tenant, expecting user at interaction if set.
+	CreateGrant(ctx context.Context, tenant string, req GrantRequest, user *UserHint) (*GrantState, error)
 	GetGrant(ctx context.Context, id string) (*GrantState, bool)
 	FindGrantByUserCodePending(ctx context.Context, code string) (*GrantState, bool)
+	FindGrantByInteractID(ctx context.Context, interactID string) (*GrantState, bool)
 
-	ApproveGrant(ctx context.Context, id string, approved AccessTokenRequest, subject string) (*GrantState, error)
+	// ApproveGrant records the user's consent: the access approved, the account they
+	// approved as, and whether they agreed to share it with the client.
+	ApproveGrant(ctx context.Context, id string, approved AccessTokenRequest, subject string, shareSubject bool) (*GrantState, error)
 	DenyGrant(ctx context.Context, id string) (*GrantState, error)
+	// FinalizeGrant moves an approved grant to finalized, running issue exactly once
+	// under the transition. The finalized grant is stored before issue runs, so nothing
+	// is issued if it cannot be; if issue fails the grant stays approved.
+	FinalizeGrant(ctx context.Context, id string, issue func(*GrantState) error) (*GrantState, error)
+	// RevokeGrant ends a grant at the client's request; it cannot be continued afterwards.
+	// A grant that has already ended is returned unchanged.
+	RevokeGrant(ctx context.Context, id string) (*GrantState, error)
 
 	MarkCodeVerified(ctx context.Context, id string) error
+	// FinishInteraction records the end of user interaction and returns the grant with its interact_ref set.
+	FinishInteraction(ctx context.Context, id string) (*GrantState, error)
+	RecordPushDelivery(ctx context.Context, id string, d PushDelivery) error
+	// RotateContinuationToken replaces the grant's continuation token if it is still
+	// current, so each continue response carries a fresh token and the old one stops working.
+	RotateContinuationToken(ctx context.Context, id, current string) (*GrantState, error)
+	// RotateClientKey replaces the grant's client key from with to (RFC 9635 §6.1.1), so
+	// continuation requests must then be signed with to. It fails if from is no longer
+	// the grant's key.
+	RotateClientKey(ctx context.Context, id string, from, to ClientKey) (*GrantState, error)
+	// RecordPoll records a continuation poll. A poll sooner than the grant's wait
+	// (wait seconds unless already raised) is rejected with too_fast and raises the
+	// wait, and one that honored it resets the wait to wait seconds; the returned
+	// grant carries the wait the client must now honor.
+	RecordPoll(ctx context.Context, id string, wait int) (*GrantState, error)
+	// ModifyGrant replaces the requested access of a pending, approved or finalized grant,
+	// with refs the access references it was expanded from and multiple whether it asks
+	// for several labeled tokens. With keepApproval the approval is narrowed to access; otherwise the grant returns to
+	// pending with fresh interaction for interact. Either way the grant's lifetime starts over.
+	ModifyGrant(ctx context.Context, id string, access AccessTokenRequest, refs map[string][]AccessItem, multiple bool, interact *Interact, keepApproval bool) (*GrantState, error)
 }
 
 type KeyPair struct {
@@ -154,7 +469,7 @@ type Configuration struct {
 type Continue struct {
 	AccessToken string `json:"access_token"`
 	URI         string `json:"uri"`
-	Wait        int    `json:"wait"` // seconds to poll before calling /continue
+	Wait        int    `json:"wait,omitempty"` // seconds to poll before calling /continue
 }
 
 type UserCode struct {
@@ -162,12 +477,18 @@ type UserCode struct {
 	URI  string `json:"uri"`
 }
 
+// InteractOut carries only the start modes the client asked for (RFC 9635 §3.3)
 type InteractOut struct {
-	Expires  time.Time `json:"expires"`
-	UserCode UserCode  `json:"user_code"`
+	Expires     time.Time `json:"expires"`
+	Redirect    string    `json:"redirect,omitempty"`
+	App         string    `json:"app,omitempty"`
+	UserCode    *UserCode `json:"user_code,omitempty"`
+	UserCodeURI *UserCode `json:"user_code_uri,omitempty"`
+	Finish      string    `json:"finish,omitempty"` // AS nonce for the interaction hash
 }
 
 type GrantResponse struct {
-	Continue Continue    `json:"continue"`
-	Interact InteractOut `json:"interact"`
+	Continue   Continue     `json:"continue"`
+	Interact   *InteractOut `json:"interact,omitempty"`
+	InstanceID string       `json:"instance_id,omitempty"` // the client's, once it is known to the AS
 }
@@ -97,18 +97,11 @@ const sample = {
             ]
         }
     ],
+    // The playground adds client.key: the public half of the key it signs requests with
     client: {
-        key: {
-            proof: "httpsig",
-            jwk: {
-                kid: "ec1",
-                kty: "EC",
-                crv: "P-256",
-                x: "abc",
-                y: "def",
-                use: "sig",
-                alg: "ES256"
-            }
+        display: {
+            name: "Soup Planner",
+            uri: "https://soup.example"
         }
     },
     interact: {
@@ -153,6 +146,7 @@ let currentGrantId = null;
 let continueUri = null;
 let contToken = null;
 let pollTimer = null;
+let pollWait = 5;   // seconds; the AS raises it if we poll too early
 
 // ---- Helpers -----------------------------------------------------------------
 function $(id) { return document.getElementById(id); }
@@ -174,6 +168,9 @@ stateDiagram-v2
   pending --> denied: user denies
   pending --> expired: ttl
   approved --> finalized: continue/issue token
+  approved --> pending: modify
+  finalized --> pending: modify
+  finalized --> revoked: revoke
   denied --> finalized
   expired --> finalized
 `;
@@ -209,6 +206,54 @@ function addEventLine(kind, details = "") {
     $("events").prepend(li);
 }
 
+// ---- Client key and request signing -----------------------------------------
+// The AS checks that grant and continue requests are signed with the client's key
+// (RFC 9635 §7.3.1, RFC 9421), so the playground makes a P-256 key when it loads and
+// signs its requests with it. The private key cannot be exported from the page.
+const clientKeyPair = crypto.subtle.generateKey({ name: "ECDSA", namedCurve: "P-256" }, false, ["sign", "verify"]);
+
+async function clientKey() {
+    const { publicKey } = await clientKeyPair;
+    const { kty, crv, x, y } = await crypto.subtle.exportKey("jwk", publicKey);
+    return { proof: "httpsig", jwk: { kty, crv, x, y, alg: "ES256" } };
+}
+
+function base64(buf) {
+    return btoa(String.fromCharCode(...new Uint8Array(buf)));
+}
+
+// signedFetch sends a request with an HTTP message signature covering the method,
+// target URI, body digest and GNAP Authorization, as the AS requires.
+async function signedFetch(method, url, { body, token } = {}) {
+    const target = new URL(url).href;
+    const covered = {};
+    const headers = {};
+    if (body !== undefined) {
+        const digest = await crypto.subtle.digest("SHA-256", new TextEncoder().encode(body));
+        headers["Content-Type"] = "application/json";
+        headers["Content-Digest"] = covered["content-digest"] = `sha-256=:${base64(digest)}:`;
+    }
+    if (token) headers["Authorization"] = covered["authorization"] = `GNAP ${token}`;
+
+    const components = ["@method", "@target-uri", ...Object.keys(covered)];
+    const params = `(${components.map((c) => `"${c}"`).join(" ")});created=${Math.floor(Date.now() / 1000)};alg="ecdsa-p256-sha256"`;
+    const lines = components.map((c) => {
+        if (c === "@method") return `"@method": ${method}`;
+        if (c === "@target-uri") return `"@target-uri": ${target}`;
+        return `"${c}": ${covered[c]}`;
+    });
+    lines.push(`"@signature-params": ${params}`);
+
+    const { privateKey } = await clientKeyPair;
+    const sig = await crypto.subtle.sign({ name: "ECDSA", hash: "SHA-256" }, privateKey, new TextEncoder().encode(lines.join("\n")));
+    headers["Signature-Input"] = `sig1=${params}`;
+    headers["Signature"] = `sig1=:${base64(sig)}:`;
+
+    const res = await fetch(target, { method, headers, body });
+    if (!res.ok) throw new Error(await res.text());
+    return res.json();
+}
+
 async function postJSON(url, body) {
     const res = await fetch(url, {
         method: "POST",
@@ -229,15 +274,6 @@ async function postForm(url, data) {
     return res.text();
 }
 
-async function authGetJSON(url, token) {
-    const res = await fetch(url, {
-        method: "POST",
-        headers: { "Authorization": `GNAP ${token}` }
-    });
-    if (!res.ok) throw new Error(await res.text());
-    return res.json();
-}
-
 // ---- Device code UX helpers --------------------------------------------------
 function normalizeCode(v) {
     v = (v || "").toUpperCase().replace(/[^A-Z0-9]/g, "");
@@ -249,6 +285,46 @@ function setIssuedCode(code) {
     $("inputCode").value = code || "";
 }
 
+// Shows who is asking, as the consent page does: display info, key thumbprint, and an
+// "unverified" badge for clients that are not pre-registered with the AS.
+function renderClient(grant) {
+    const box = $("clientInfo");
+    box.replaceChildren();
+    const display = (grant.client && grant.client.display) || {};
+
+    if (display.logo_uri) {
+        const img = document.createElement("img");
+        img.src = display.logo_uri;
+        img.alt = "";
+        img.referrerPolicy = "no-referrer";
+        box.append(img);
+    }
+    const info = document.createElement("div");
+    const name = document.createElement("div");
+    name.textContent = display.name || "Unnamed application";
+    if (!grant.client_verified) {
+        const badge = document.createElement("span");
+        badge.className = "unverified";
+        badge.textContent = "unverified";
+        name.append(badge);
+    }
+    info.append(name);
+    if (display.uri) {
+        const link = document.createElement("a");
+        link.href = display.uri;
+        link.target = "_blank";
+        link.rel = "noopener noreferrer";
+        link.textContent = display.uri;
+        info.append(link);
+    }
+    const thumb = document.createElement("div");
+    thumb.className = "small mono";
+    thumb.textContent = "key " + (grant.client_thumbprint || "—");
+    info.append(thumb);
+    box.append(info);
+    box.hidden = false;
+}
+
 function preview(str, head = 12, tail = 8) {
     if (!str || typeof str !== "string") return "—";
     if (str.length <= head + tail + 1) return str;
@@ -281,28 +357,45 @@ async function callContinue() {
         return;
     }
     try {
-        const data = await authGetJSON(continueUri, contToken);
+        const data = await signedFetch("POST", continueUri, { token: contToken });
         $("continueOut").textContent = JSON.stringify(data, null, 2);
         addEventLine("continue", `grant=${currentGrantId}`);
+        if (data?.continue?.wait) pollWait = data.continue.wait;
+        // Every continue response rotates the continuation token; the old one stops working
+        if (data?.continue?.access_token) {
+            contToken = data.continue.access_token;
+            $("curContTok").textContent = preview(contToken, 18, 12);
+        }
 
         if (data.access_token) {
             stateBadge("finalized");
             setDiagram();
-            addEventLine("finalized", "token issued");
-            const tok = data?.access_token?.value;
-            if (tok) $("curContTok").textContent = preview(tok, 18, 12);
+            // One token comes back as an object, several as a labeled array
+            const tok = [].concat(data.access_token)[0]?.value;
+            addEventLine("finalized", `token issued ${preview(tok, 10, 6)}`);
         }
+        // Only a grant still waiting for the user comes back with a wait
+        return !data.continue?.wait;
     } catch (e) {
         $("continueOut").textContent = `Error: ${e.message}`;
+        // A denied, expired or finalized grant cannot be continued again
+        return /request_denied|invalid_continuation/.test(e.message);
     }
 }
 
+// With long polling on (the AS default), the AS holds each continue call open until
+// the user decides on the AS, so the tokens arrive as soon as they approve. Poll again
+// only after the wait the AS asked for, and stop once the grant is done.
 function startPolling() {
     if (pollTimer) return;
-    pollTimer = setInterval(callContinue, 2000);
+    const tick = async () => {
+        if (await callContinue()) return stopPolling();
+        if (pollTimer) pollTimer = setTimeout(tick, pollWait * 1000);
+    };
+    pollTimer = setTimeout(tick, pollWait * 1000);
 }
 function stopPolling() {
-    if (pollTimer) clearInterval(pollTimer);
+    if (pollTimer) clearTimeout(pollTimer);
     pollTimer = null;
 }
 
@@ -321,8 +414,9 @@ window.addEventListener("DOMContentLoaded", () => {
     $("btnGrant").onclick = async () => {
         try {
             const body = JSON.parse($("grantJSON").value || "{}");
+            body.client = { ...body.client, key: await clientKey() };
             // GNAP: POST /grants on the AS
-            const resp = await postJSON(joinURL(AS_BASE, "/grants"), body);
+            const resp = await signedFetch("POST", joinURL(AS_BASE, "/grants"), { body: JSON.stringify(body) });
 
             // Resolve continue URI absolute
             const contUriRaw = resp?.continue?.uri || "";
@@ -350,11 +444,12 @@ window.addEventListener("DOMContentLoaded", () => {
         }
     };
 
-    // Debug approve/deny (demo endpoints on playground)
+    // Approve or deny through the AS consent endpoint, as the /device page does, so a
+    // held continue call wakes up right away
     $("btnApprove").onclick = async () => {
         try {
             if (!currentGrantId) return;
-            await postForm(joinURL(PLAYGROUND_BASE, `/debug/approve/${currentGrantId}`), {});
+            await postForm(joinURL(AS_BASE, "/device/consent"), { grant_id: currentGrantId, decision: "approve" });
             addEventLine("approved", `grant=${currentGrantId}`);
             stateBadge("approved");
         } catch (e) {
@@ -365,7 +460,7 @@ window.addEventListener("DOMContentLoaded", () => {
     $("btnDeny").onclick = async () => {
         try {
             if (!currentGrantId) return;
-            await postForm(joinURL(PLAYGROUND_BASE, `/debug/deny/${currentGrantId}`), {});
+            await postForm(joinURL(AS_BASE, "/device/consent"), { grant_id: currentGrantId, decision: "deny" });
             addEventLine("denied", `grant=${currentGrantId}`);
             stateBadge("denied");
         } catch (e) {
@@ -389,7 +484,8 @@ window.addEventListener("DOMContentLoaded", () => {
             return;
         }
         try {
-            await postJSON(joinURL(AS_BASE, "/device/verify"), { user_code: code });
+            const grant = await postJSON(joinURL(AS_BASE, "/device/verify/json"), { user_code: code });
+            renderClient(grant);
             $("verifyMsg").textContent = "Code accepted. Review consent in the other tab or Approve or Deny here.";
             addEventLine("code_verified", `code=${code}`);
             $("btnApprove").disabled = false;